@@ -39,7 +39,7 @@ func CastWSPublisher(ws *websocket.Conn, qo QueryOption) (err error) {
 		return
 	}
 
-	_, trk, err := c.addSourceTrackByLabel(qo.Source.Label, qo.Track.Label)
+	_, trk, err := c.addSourceTrackByOption(qo)
 	if err != nil {
 		log.Println(err)
 		return
@@ -48,6 +48,7 @@ func CastWSPublisher(ws *websocket.Conn, qo QueryOption) (err error) {
 	s.SourceID = qo.Source.Label
 	s.TrackID = qo.Track.Label
 	s.setTimeoutInUnit(qo.Session.Timeout, qo.Session.Unit)
+	s.setQueryOptions(qo)
 
 	err = trk.handleBuffersByCastAPI(ws, s)
 	return
@@ -88,6 +89,7 @@ func CastWSSubscriber(ws *websocket.Conn, qo QueryOption) (err error) {
 	s.SourceID = qo.Source.Label
 	s.TrackID = qo.Track.Label
 	s.setTimeoutInUnit(qo.Session.Timeout, qo.Session.Unit)
+	s.setQueryOptions(qo)
 
 	err = s.trk.handleBuffersByCastAPI(ws, s)
 	return
@@ -100,10 +102,10 @@ func (trk *Track) handleBuffersByCastAPI(ws *websocket.Conn, s *Session) (err er
 
 	switch s.Name {
 	case "/cast/ws/pub":
-		rbuf := trk.Rings[BUFFER_NUM_FORE] // 0: foreward direction
+		rbuf := trk.getRingByOrder(BUFFER_NUM_FORE) // 0: foreward direction
 		err = rbuf.recvTrackBufferInWSMessage(ws, s, true)
 	case "/cast/ws/sub":
-		sbuf := trk.Rings[BUFFER_NUM_FORE] // 0: forward direction
+		sbuf := trk.getRingByOrder(BUFFER_NUM_FORE) // 0: forward direction
 		err = sbuf.sendTrackBufferInWSMessage(ws, s, true)
 	default:
 		err = fmt.Errorf("not support API: %s", s.Name)
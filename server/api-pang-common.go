@@ -7,8 +7,11 @@
 package main
 
 import (
+	"encoding/binary"
 	"fmt"
 	"log"
+	"strconv"
+	"time"
 )
 
 // ---------------------------------------------------------------------------------
@@ -22,6 +25,14 @@ const (
 	RSSP_MAX_DATA_SIZE = 1024 * 1024 // max data size
 )
 
+const (
+	RSSP_MARK_RSEQ  = "RSEQ"             // stamp header of slot: seq, from, capture and ingest time
+	RSSP_MARK_RCAP  = "RCAP"             // capture time prefix of data from publisher
+	RSSP_STAMP_SIZE = 4 + 8 + 20 + 8 + 8 // RSEQ + seq + from (xid) + capture + ingest
+	RSSP_MARK_RPAR  = "RP"               // ring tag prefix "RPnn" of data from parallel buffers
+	RSSP_MARK_RDST  = "RDST"             // destination prefix of data: session id, request id or card name
+)
+
 // ---------------------------------------------------------------------------------
 func GetChannelSourceTrack(channel, source, track string) (chn *Channel, src *Source, trk *Track, err error) {
 	log.Println("i.GetChannelSourceTrack:", channel, source, track)
@@ -40,4 +51,97 @@ func GetChannelSourceTrack(channel, source, track string) (chn *Channel, src *So
 	return
 }
 
+// ---------------------------------------------------------------------------------
+// StripCaptureTime removes the capture time prefix, "RCAP" + unix time in nanoseconds (8 bytes)
+//   - only for the publisher of capture=on, not to alter the data starting with "RCAP"
+//
+// ---------------------------------------------------------------------------------
+func StripCaptureTime(data []byte) (ctime time.Time, body []byte) {
+	if len(data) < 12 || string(data[:4]) != RSSP_MARK_RCAP {
+		return time.Time{}, data
+	}
+	ctime = time.Unix(0, int64(binary.BigEndian.Uint64(data[4:12])))
+	return ctime, data[12:]
+}
+
+// PrefixCaptureTime prefixes the capture time to the data, counterpart of StripCaptureTime
+func PrefixCaptureTime(ctime time.Time, data []byte) []byte {
+	head := binary.BigEndian.AppendUint64([]byte(RSSP_MARK_RCAP), uint64(ctime.UnixNano()))
+	return append(head, data...)
+}
+
+// ---------------------------------------------------------------------------------
+// StripDestination removes the destination prefix, "RDST" + length (1 byte) + destination
+//   - the destination is a session id, request id or agent card name to deliver only
+//   - only for the publisher of direct=on, not to alter the data starting with "RDST"
+//
+// ---------------------------------------------------------------------------------
+func StripDestination(data []byte) (to string, body []byte) {
+	if len(data) < 5 || string(data[:4]) != RSSP_MARK_RDST {
+		return "", data
+	}
+	n := int(data[4])
+	if len(data) < 5+n {
+		return "", data
+	}
+	return string(data[5 : 5+n]), data[5+n:]
+}
+
+// AddressData prefixes the destination to the data, counterpart of StripDestination
+func AddressData(to string, data []byte) []byte {
+	if len(to) > 255 {
+		to = to[:255]
+	}
+	head := append([]byte(RSSP_MARK_RDST), byte(len(to)))
+	head = append(head, to...)
+	return append(head, data...)
+}
+
+// ---------------------------------------------------------------------------------
+// StampSlotData prefixes the data of slot with its stamp header
+//   - binary: "RSEQ" + seq(8) + from(20) + capture(8) + ingest(8) in big endian, unix nano
+//   - text: "RSEQ <seq> <from> <capture> <ingest>\n"
+//
+// ---------------------------------------------------------------------------------
+func StampSlotData(bs *Slot, text bool) (data []byte) {
+	from, _ := bs.Head.(string)
+	var ctime int64
+	if !bs.Capture.IsZero() {
+		ctime = bs.Capture.UnixNano()
+	}
+
+	if text {
+		head := fmt.Sprintf("%s %d %s %d %d\n", RSSP_MARK_RSEQ, bs.Seq, from, ctime, bs.Time.UnixNano())
+		return append([]byte(head), bs.Data...)
+	}
+
+	data = make([]byte, RSSP_STAMP_SIZE, RSSP_STAMP_SIZE+len(bs.Data))
+	copy(data[0:4], RSSP_MARK_RSEQ)
+	binary.BigEndian.PutUint64(data[4:12], bs.Seq)
+	copy(data[12:32], from)
+	binary.BigEndian.PutUint64(data[32:40], uint64(ctime))
+	binary.BigEndian.PutUint64(data[40:48], uint64(bs.Time.UnixNano()))
+	return append(data, bs.Data...)
+}
+
+// ---------------------------------------------------------------------------------
+// TagRingData prefixes the ring tag "RPnn" to the data, nn is the order of ring (buffer)
+// ---------------------------------------------------------------------------------
+func TagRingData(order int, data []byte) []byte {
+	tag := fmt.Sprintf("%s%02d", RSSP_MARK_RPAR, order)
+	return append([]byte(tag), data...)
+}
+
+// UntagRingData removes the ring tag and returns the order of ring, -1 if not tagged
+func UntagRingData(data []byte) (order int, body []byte) {
+	if len(data) < RSSP_MARK_SIZE || string(data[:2]) != RSSP_MARK_RPAR {
+		return -1, data
+	}
+	n, err := strconv.Atoi(string(data[2:RSSP_MARK_SIZE]))
+	if err != nil {
+		return -1, data
+	}
+	return n, data[RSSP_MARK_SIZE:]
+}
+
 //=================================================================================
@@ -0,0 +1,107 @@
+// =================================================================================
+// Filename: api-pang-common_test.go
+// Function: Test functions for api-pang-common.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// ---------------------------------------------------------------------------------
+func TestCaptureTime(t *testing.T) {
+	ctime := time.Unix(1700000000, 123456789)
+	tm, body := StripCaptureTime(PrefixCaptureTime(ctime, []byte("frame")))
+	if !tm.Equal(ctime) || string(body) != "frame" {
+		t.Fatal("invalid capture time:", tm, string(body))
+	}
+	for _, data := range [][]byte{[]byte("RCAP"), []byte("RCAPshort"), []byte("frame-data")} {
+		if tm, body := StripCaptureTime(data); !tm.IsZero() || !bytes.Equal(body, data) {
+			t.Fatal("data is altered:", string(data))
+		}
+	}
+
+	// stripped only for the publisher of capture=on
+	for _, opt := range []string{"", "capture=on"} {
+		qo, err := GetQueryOptionFromString("ws", "/pang/ws/pub", "channel=test&"+opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := NewSessionPointerWithName("/pang/ws/pub")
+		s.setQueryOptions(qo)
+		if s.Capture != (opt != "") {
+			t.Fatal("invalid capture option:", opt, s.Capture)
+		}
+	}
+}
+
+func TestStampSlotData(t *testing.T) {
+	from := GetXidString()
+	bs := &Slot{Head: from, Seq: 7, Capture: time.Unix(0, 1000), Time: time.Unix(0, 2000), Data: []byte("data")}
+
+	data := StampSlotData(bs, false)
+	if len(data) != RSSP_STAMP_SIZE+4 || string(data[:4]) != RSSP_MARK_RSEQ ||
+		binary.BigEndian.Uint64(data[4:12]) != 7 || string(data[12:32]) != from ||
+		binary.BigEndian.Uint64(data[32:40]) != 1000 || binary.BigEndian.Uint64(data[40:48]) != 2000 ||
+		string(data[RSSP_STAMP_SIZE:]) != "data" {
+		t.Fatal("invalid binary stamp:", data)
+	}
+
+	var seq, ctime, itime int64
+	var mark, sfrom string
+	var body string
+	n, err := fmt.Sscanf(string(StampSlotData(bs, true)), "%s %d %s %d %d\n%s", &mark, &seq, &sfrom, &ctime, &itime, &body)
+	if err != nil || n != 6 || mark != RSSP_MARK_RSEQ || seq != 7 || sfrom != from || ctime != 1000 || itime != 2000 || body != "data" {
+		t.Fatal("invalid text stamp:", n, err)
+	}
+
+	bs.Capture = time.Time{} // zero if not given by the publisher
+	if data = StampSlotData(bs, false); binary.BigEndian.Uint64(data[32:40]) != 0 {
+		t.Fatal("invalid zero capture time")
+	}
+}
+
+func TestStampTCPSlots(t *testing.T) {
+	s := NewSessionPointerWithName("/pang/tcp/sub")
+	s.chn = NewChannelPointer()
+	s.chn.State = Using
+	s.trk = NewTrackDualBuffers("test", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS)
+	s.Stamp, s.TimeOver, s.TimeUnit = true, time.Second, time.Millisecond
+	s.setState(Using)
+	b := s.trk.getRingByOrder(BUFFER_NUM_FORE)
+
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.sendTrackBufferInTCPMessage(conn, s, true) // ended by the timeout
+	}()
+	time.Sleep(50 * time.Millisecond) // to read the live slots
+
+	// the text stamp for the text slots as WS, the binary one for others
+	from := GetXidString()
+	b.writeSlot(Slot{Head: from, FrameType: websocket.TextMessage, Mark: RSSP_MARK_RTXT, Data: []byte("text")}, false)
+	b.writeSlot(Slot{Head: from, FrameType: websocket.BinaryMessage, Mark: RSSP_MARK_RBIN, Data: []byte("data")}, false)
+	mark, data, err := TCPRecvMessage(peer, time.Second)
+	if err != nil || mark != RSSP_MARK_RTXT || !bytes.HasPrefix(data, []byte(RSSP_MARK_RSEQ+" 1 ")) || !bytes.HasSuffix(data, []byte("\ntext")) {
+		t.Fatal("invalid text stamp:", mark, string(data), err)
+	}
+	mark, data, err = TCPRecvMessage(peer, time.Second)
+	if err != nil || mark != RSSP_MARK_RBIN || len(data) != RSSP_STAMP_SIZE+4 || binary.BigEndian.Uint64(data[4:12]) != 2 {
+		t.Fatal("invalid binary stamp:", mark, data, err)
+	}
+	<-done
+}
+
+//=================================================================================
@@ -46,6 +46,7 @@ func PangTCPPeering(conn net.Conn, qo QueryOption) (err error) {
 	defer pStudio.deleteSessionWithClose(s)
 
 	s.setTimeoutInUnit(qo.Session.Timeout, qo.Session.Unit)
+	s.setQueryOptions(qo)
 
 	var pnh *Punch = nil
 
@@ -134,6 +135,7 @@ func PangTCPReflector(conn net.Conn, qo QueryOption) (err error) {
 	defer pStudio.deleteSessionWithClose(s)
 
 	s.setTimeoutInUnit(qo.Session.Timeout, qo.Session.Unit)
+	s.setQueryOptions(qo)
 
 	for s.isState(Using) {
 		prefix, data, err := TCPRecvMessage(conn, 3*time.Second)
@@ -141,7 +143,7 @@ func PangTCPReflector(conn net.Conn, qo QueryOption) (err error) {
 			log.Println(prefix, err)
 			break
 		}
-		s.InBytes += len(data)
+		s.addIn(len(data))
 
 		// simulate the buffering delay
 		time.Sleep(s.TimeUnit)
@@ -151,7 +153,7 @@ func PangTCPReflector(conn net.Conn, qo QueryOption) (err error) {
 			log.Println(n, err)
 			break
 		}
-		s.OutBytes += len(data)
+		s.addOut(len(data))
 	}
 	return
 }
@@ -200,18 +202,29 @@ func PangTCPPublisher(conn net.Conn, qo QueryOption) (err error) {
 		return
 	}
 
-	s.src, s.trk, err = s.chn.addSourceTrackByLabel(qo.Source.Label, qo.Track.Label)
+	s.src, s.trk, err = s.chn.addSourceTrackByOption(qo)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 	s.trk.Mode = qo.Track.Mode
 	s.trk.Style = qo.Track.Style
+	err = s.chn.attachTrackProc(s.trk, qo.Track.Proc)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = s.trk.setCompress(qo.Track.Compress, qo.Track.Dict == "on")
+	if err != nil {
+		log.Println(err)
+		return
+	}
 	defer s.resetTrackInfo()
 
 	s.SourceID = qo.Source.Label
 	s.TrackID = qo.Track.Label
 	s.setTimeoutInUnit(qo.Session.Timeout, qo.Session.Unit)
+	s.setQueryOptions(qo)
 
 	s.chn.pushEvent("pub-in", s.ID, s.Name, s.RequestID)
 	defer s.chn.pushEvent("pub-out", s.ID, s.Name, s.RequestID)
@@ -265,8 +278,21 @@ func PangTCPSubscriber(conn net.Conn, qo QueryOption) (err error) {
 	s.SourceID = qo.Source.Label
 	s.TrackID = qo.Track.Label
 	s.setTimeoutInUnit(qo.Session.Timeout, qo.Session.Unit)
+	s.setQueryOptions(qo)
 	s.chn.AtUsed = time.Now()
 
+	if qo.Session.Scale != "" || qo.Session.Quality != "" { // to read the rendition track
+		w, h, q, _ := ParseScaleOptions(qo.Session.Scale, qo.Session.Quality)
+		var r *Rendition
+		r, err = s.chn.acquireRendition(qo.Source.Label, qo.Track.Label, w, h, q)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		defer s.chn.releaseRendition(r)
+		s.trk, s.TrackID = r.getTrack(), r.Label
+	}
+
 	s.chn.pushEvent("sub-in", s.ID, s.Name, s.RequestID)
 	defer s.chn.pushEvent("sub-out", s.ID, s.Name, s.RequestID)
 
@@ -299,7 +325,7 @@ func PangTCPMedusa(conn net.Conn, qo QueryOption) (err error) {
 		return
 	}
 
-	s.src, s.trk, err = s.chn.addSourceTrackByLabel(qo.Source.Label, qo.Track.Label)
+	s.src, s.trk, err = s.chn.addSourceTrackByOption(qo)
 	if err != nil {
 		log.Println(err)
 		return
@@ -313,6 +339,7 @@ func PangTCPMedusa(conn net.Conn, qo QueryOption) (err error) {
 	s.SourceID = qo.Source.Label
 	s.TrackID = qo.Track.Label
 	s.setTimeoutInUnit(qo.Session.Timeout, qo.Session.Unit)
+	s.setQueryOptions(qo)
 	s.chn.AtUsed = time.Now()
 
 	s.chn.pushEvent("meb-in", s.ID, s.Name, s.RequestID)
@@ -329,31 +356,46 @@ func (trk *Track) handleBuffersByPangTCPAPI(conn net.Conn, s *Session, mode stri
 
 	switch s.Name {
 	case "/pang/tcp/pub": // publisher type
-		rbuf := trk.Rings[BUFFER_NUM_FORE] // [0]: foreward direction
-		sbuf := trk.Rings[BUFFER_NUM_BACK] // [1]: backward direction
-		if mode == "bundle" {              // bi-directional
+		var rbuf *Buffer
+		rbuf, err = trk.getRingBySession(s, BUFFER_NUM_FORE) // [0]: foreward direction
+		if err != nil {
+			return
+		}
+		sbuf := trk.getRingByOrder(BUFFER_NUM_BACK) // [1]: backward direction
+		if mode == "bundle" && trk.Parallel == 0 {  // bi-directional
 			go sbuf.sendTrackBufferInTCPMessage(conn, s, false) // sender routine
 		}
 		// rbuf.setBufferSizeLen(10) // for testing
 		err = rbuf.recvTrackBufferInTCPMessage(conn, s, false) // receiver routine
 	case "/pang/tcp/sub": // subscriber type
-		rbuf := trk.Rings[BUFFER_NUM_BACK] // [1]: backward direction
-		sbuf := trk.Rings[BUFFER_NUM_FORE] // [0]: forward direction
-		if mode == "bundle" {              // bi-directional
+		rbuf := trk.getRingByOrder(BUFFER_NUM_BACK) // [1]: backward direction
+		if mode == "bundle" && trk.Parallel == 0 {  // bi-directional
 			go rbuf.recvTrackBufferInTCPMessage(conn, s, true) // receiver routine
 		}
+		if s.Order == BUFFER_ORDER_ALL { // all buffers interleaved with ring tag
+			err = trk.sendTrackRingsInTCPMessage(conn, s, true)
+			break
+		}
+		var sbuf *Buffer
+		sbuf, err = trk.getRingBySession(s, BUFFER_NUM_FORE) // [0]: forward direction
+		if err != nil {
+			return
+		}
 		err = sbuf.sendTrackBufferInTCPMessage(conn, s, true) // sender routine
 	case "/pang/tcp/meb": // broadcast type apps: text, voice chat, data hub
-		rbuf := trk.Rings[BUFFER_NUM_FORE]                     // [0]: both direction,
-		sbuf := trk.Rings[BUFFER_NUM_FORE]                     // [0]: single buffer
-		go rbuf.recvTrackBufferInTCPMessage(conn, s, true)     // from multi pubs
-		err = sbuf.sendTrackBufferInTCPMessage(conn, s, false) // to multi subs
+		var buf *Buffer
+		buf, err = trk.getRingBySession(s, BUFFER_NUM_FORE) // [0]: both direction, single buffer
+		if err != nil {
+			return
+		}
+		go buf.recvTrackBufferInTCPMessage(conn, s, true)     // from multi pubs
+		err = buf.sendTrackBufferInTCPMessage(conn, s, false) // to multi subs
 	case "/pang/tcp/d2m": // p2p monitoring mode
 		if mode == "bundle" { // backward = subscriber
-			sbuf := trk.Rings[BUFFER_NUM_BACK]
+			sbuf := trk.getRingByOrder(BUFFER_NUM_BACK)
 			err = sbuf.sendTrackBufferInTCPMessage(conn, s, true) // timeout is set
 		} else { // == "single", forward = publisher
-			sbuf := trk.Rings[BUFFER_NUM_FORE]
+			sbuf := trk.getRingByOrder(BUFFER_NUM_FORE)
 			err = sbuf.sendTrackBufferInTCPMessage(conn, s, true)
 		}
 	case "/pang/tcp/p2p": // do nothing because of no buffering
@@ -373,49 +415,98 @@ func (b *Buffer) sendTrackBufferInTCPMessage(conn net.Conn, s *Session, fout boo
 
 	defer s.setState(Idle)
 
-	// send the mime information for the track
-	if s.chn.isState(Using) && s.trk.Mime != "" {
-		_, err = TCPSendMessage(conn, s.TimeOver, RSSP_MARK_RTXT, []byte(s.trk.Mime))
+	// send the mime information for the buffer (pipe)
+	if mime := s.trk.getRingMime(b); s.chn.isState(Using) && mime != "" {
+		_, err = TCPSendMessage(conn, s.TimeOver, RSSP_MARK_RTXT, []byte(mime))
 		if err != nil {
 			log.Println(err)
 			return
 		}
-		log.Println(s.trk.Mime)
+		log.Println(b.Label, mime)
+	}
+
+	send := func(bs *Slot) (err error) {
+		_, err = TCPSendMessage(conn, s.TimeOver, bs.Mark, s.getSlotData(bs, bs.FrameType == websocket.TextMessage))
+		return
 	}
 
-	lpos := b.PosWrite
+	// send the cached gop first, then the live slots
+	lseq, err := b.burstStartSlots(s, send)
+	if err != nil {
+		return
+	}
+	b.addReader(s, lseq)
+	defer b.deleteReader(s)
 	etime := time.Now().Add(s.TimeOver)
 
 	// send slots in the buffer while the session and channel are using
 	for s.isState(Using) && s.chn.isState(Using) {
-		if lpos == b.PosWrite {
-			if time.Now().After(etime) {
-				if fout { // if the timeout is set, then return
-					log.Println("timeout:", s.TimeOver, s.TimeUnit)
-					return
+		err = s.sendQueuedAcks(send)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		var bs *Slot
+		var nseq uint64
+		bs, nseq, err = b.readSlotByPolicy(s, lseq, BUFFER_GAP_SLOTS)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if bs == nil {
+			if nseq == lseq { // no more slot to send
+				if time.Now().After(etime) {
+					if fout { // if the timeout is set, then return
+						log.Println("timeout:", s.TimeOver, s.TimeUnit)
+						return
+					}
 				}
+				time.Sleep(s.TimeUnit)
 			}
-			time.Sleep(s.TimeUnit)
+			lseq = nseq
 			continue
 		}
+		lseq = nseq
 		etime = time.Now().Add(s.TimeOver)
 
-		bs := b.readSlotByPos(lpos)
-
-		if bs.Head.(string) != s.ID { // skip the self message
-			_, err = TCPSendMessage(conn, s.TimeOver, bs.Mark, bs.Data)
+		if s.isDeliverable(bs) { // deliver by the filter of session
+			err = send(bs)
 			if err != nil {
 				log.Println(err)
 				return
 			}
 
-			s.OutBytes += bs.Length
-			s.trk.OutBytes += bs.Length
-			s.chn.OutBytes += bs.Length
+			s.countOutSlot(bs.Length)
 		}
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// sendTrackRingsInTCPMessage(timeout) : sender routine for all buffers with ring tag
+// ---------------------------------------------------------------------------------
+func (trk *Track) sendTrackRingsInTCPMessage(conn net.Conn, s *Session, fout bool) (err error) {
+	log.Println("i.sendTrackRingsInTCPMessage:", trk.Label)
+	defer log.Println("o.sendTrackRingsInTCPMessage:", err)
+
+	defer s.setState(Idle)
 
-		lpos = b.setReadPos(lpos)
+	// send the mime information for each buffer (pipe)
+	for i, b := range trk.getRings() {
+		if mime := trk.getRingMime(b); s.chn.isState(Using) && mime != "" {
+			_, err = TCPSendMessage(conn, s.TimeOver, RSSP_MARK_RTXT, TagRingData(i, []byte(mime)))
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			log.Println(b.Label, mime)
+		}
 	}
+
+	err = trk.sendTrackRings(s, fout, func(order int, bs *Slot) (err error) {
+		_, err = TCPSendMessage(conn, s.TimeOver, bs.Mark, TagRingData(order, s.getSlotData(bs, bs.FrameType == websocket.TextMessage)))
+		return
+	})
 	return
 }
 
@@ -429,25 +520,43 @@ func (b *Buffer) recvTrackBufferInTCPMessage(conn net.Conn, s *Session, flock bo
 	defer s.setState(Idle)
 
 	for s.isState(Using) && s.chn.isState(Using) {
-		bs := Slot{Head: s.ID, FrameType: websocket.BinaryMessage, Mark: RSSP_MARK_RBIN}
+		bs := Slot{Head: s.ID, Group: s.GroupID, FrameType: websocket.BinaryMessage, Mark: RSSP_MARK_RBIN}
 		bs.Mark, bs.Data, err = TCPRecvMessage(conn, s.TimeOver)
 		if err != nil {
 			log.Println(err)
 			return
 		}
 
+		if s.Direct {
+			bs.To, bs.Data = StripDestination(bs.Data)
+		}
+		if s.Capture && bs.Mark == RSSP_MARK_RBIN {
+			bs.Capture, bs.Data = StripCaptureTime(bs.Data)
+		}
 		if bs.Mark == RSSP_MARK_RTXT {
 			bs.FrameType = websocket.TextMessage
-			s.trk.Mime = string(bs.Data)
-			log.Println(s.Name, s.trk.Label, s.trk.Mime)
+			if IsInternalJSONMessage(bs.Data) {
+				if !ProcInternalJSONMessage(s, b, &bs) {
+					continue // done by the server
+				}
+			} else if bs.To == "" { // the directed text is not the mime
+				s.trk.setRingMime(b, string(bs.Data))
+			}
 		}
 
 		bs.getLengthTime()
-		b.writeSlot(bs, flock)
+		bs.Key = s.trk.inspectCodec(&bs, b)
+		s.trk.compressSlot(&bs, b)
+		err = s.secureSlot(&bs)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		bs.Seq = b.writeSlot(bs, flock)
+		b.cacheGopSlot(bs.Seq, bs.Key)
+		s.recordDVRSlot(b, &bs)
 
-		s.InBytes += bs.Length
-		s.trk.InBytes += bs.Length
-		s.chn.InBytes += bs.Length
+		s.countInSlot(bs.Length)
 	}
 	return
 }
@@ -57,17 +57,28 @@ func PangUDPPublisher(ws *websocket.Conn, qo QueryOption) (err error) {
 		return
 	}
 
-	_, s.trk, err = s.chn.addSourceTrackByLabel(qo.Source.Label, qo.Track.Label)
+	_, s.trk, err = s.chn.addSourceTrackByOption(qo)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 	s.trk.Mode = qo.Track.Mode
 	s.trk.Style = qo.Track.Style
+	err = s.chn.attachTrackProc(s.trk, qo.Track.Proc)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = s.trk.setCompress(qo.Track.Compress, qo.Track.Dict == "on")
+	if err != nil {
+		log.Println(err)
+		return
+	}
 
 	s.SourceID = qo.Source.Label
 	s.TrackID = qo.Track.Label
 	s.setTimeoutInUnit(qo.Session.Timeout, qo.Session.Unit)
+	s.setQueryOptions(qo)
 
 	//-------------------------------------------------------
 	for s.isState(Using) {
@@ -90,7 +101,7 @@ func PangUDPPublisher(ws *websocket.Conn, qo QueryOption) (err error) {
 				return
 			}
 			log.Println(qo.Stream.Addr)
-			s.trk.Mime = qo.Stream.Mime
+			s.trk.setRingMime(s.trk.getRingByOrder(BUFFER_NUM_FORE), qo.Stream.Mime)
 
 			udp, uaddr, err := openUDPRecvPort("udp", ":0")
 			if err != nil {
@@ -153,6 +164,7 @@ func PangUDPSubscriber(ws *websocket.Conn, qo QueryOption) (err error) {
 	s.SourceID = qo.Source.Label
 	s.TrackID = qo.Track.Label
 	s.setTimeoutInUnit(qo.Session.Timeout, qo.Session.Unit)
+	s.setQueryOptions(qo)
 
 	//-------------------------------------------------------
 	for s.isState(Using) {
@@ -186,7 +198,7 @@ func PangUDPSubscriber(ws *websocket.Conn, qo QueryOption) (err error) {
 			go s.trk.handleBuffersByPangUDPAPI(udp, s)
 
 			sm.Type = "answer"
-			qo.Stream.Mime = s.trk.Mime
+			qo.Stream.Mime = s.trk.getRingByOrder(BUFFER_NUM_FORE).Mime
 			qo.Stream.Addr = uaddr
 			data, _ := json.Marshal(qo.Stream)
 			sm.Data = string(data)
@@ -265,10 +277,18 @@ func (trk *Track) handleBuffersByPangUDPAPI(udp *net.UDPConn, s *Session) (err e
 
 	switch s.Name {
 	case "/pang/udp/pub":
-		rbuf := trk.Rings[BUFFER_NUM_FORE] // 0: foreward direction
+		var rbuf *Buffer
+		rbuf, err = trk.getRingBySession(s, BUFFER_NUM_FORE) // 0: foreward direction
+		if err != nil {
+			return
+		}
 		err = rbuf.recvTrackBufferInUDPMessage(udp, s, true)
 	case "/pang/udp/sub":
-		sbuf := trk.Rings[BUFFER_NUM_FORE] // 0: forward direction
+		var sbuf *Buffer
+		sbuf, err = trk.getRingBySession(s, BUFFER_NUM_FORE) // 0: forward direction
+		if err != nil {
+			return
+		}
 		err = sbuf.sendTrackBufferInUDPMessage(udp, s, true)
 	default:
 		err = fmt.Errorf("not support pang UDP API: %s", s.Name)
@@ -285,22 +305,35 @@ func (b *Buffer) recvTrackBufferInUDPMessage(udp *net.UDPConn, s *Session, flock
 
 	for s.isState(Using) && s.chn.isState(Using) {
 		udp.SetReadDeadline(time.Now().Add(10 * time.Second))
-		bs := Slot{Head: s.ID}
+		bs := Slot{Head: s.ID, Group: s.GroupID}
 		n, addr, err := udp.ReadFrom(buf)
 		if err != nil {
 			log.Println(err, n, addr)
 			break
 		}
 		bs.FrameType = websocket.BinaryMessage
-		bs.Data = buf[:n]
+		bs.Data = append([]byte(nil), buf[:n]...) // copy, buf is reused for the next read
+		if s.Direct {
+			bs.To, bs.Data = StripDestination(bs.Data)
+		}
+		if s.Capture {
+			bs.Capture, bs.Data = StripCaptureTime(bs.Data)
+		}
 		bs.getLengthTime()
 		// fmt.Println(addr, n)
 
-		b.writeSlot(bs, flock)
+		bs.Key = s.trk.inspectCodec(&bs, b)
+		s.trk.compressSlot(&bs, b)
+		err = s.secureSlot(&bs)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		bs.Seq = b.writeSlot(bs, flock)
+		b.cacheGopSlot(bs.Seq, bs.Key)
+		s.recordDVRSlot(b, &bs)
 
-		s.InBytes += bs.Length
-		s.trk.InBytes += bs.Length
-		s.chn.InBytes += bs.Length
+		s.countInSlot(bs.Length)
 	}
 	return
 }
@@ -309,36 +342,52 @@ func (b *Buffer) sendTrackBufferInUDPMessage(udp *net.UDPConn, s *Session, fout
 	log.Println("i.sendTrackBufferInUDPMessage:")
 	defer log.Println("o.sendTrackBufferInUDPMessage:", err)
 
-	lpos := b.PosWrite
+	send := func(bs *Slot) (err error) {
+		_, err = udp.Write(s.getSlotData(bs, false))
+		return
+	}
+
+	// send the cached gop first, then the live slots
+	lseq, err := b.burstStartSlots(s, send)
+	if err != nil {
+		return
+	}
+	b.addReader(s, lseq)
+	defer b.deleteReader(s)
 	etime := time.Now().Add(s.TimeOver)
 
 	for s.isState(Using) && s.chn.isState(Using) {
-		if lpos == b.PosWrite {
-			if time.Now().After(etime) {
-				if fout {
-					log.Println("timeout:", s.TimeOver, s.TimeUnit)
-					return
+		var bs *Slot
+		var nseq uint64
+		bs, nseq, err = b.readSlotByPolicy(s, lseq, 0) // no gap, only skip the overwritten
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if bs == nil {
+			if nseq == lseq {
+				if time.Now().After(etime) {
+					if fout {
+						log.Println("timeout:", s.TimeOver, s.TimeUnit)
+						return
+					}
 				}
+				time.Sleep(s.TimeUnit)
 			}
-			time.Sleep(s.TimeUnit)
+			lseq = nseq
 			continue
 		}
+		lseq = nseq
 		etime = time.Now().Add(s.TimeOver)
 
-		bs := b.readSlotByPos(lpos)
-
-		if bs.Head.(string) != s.ID { // ignore its self messages
-			n, err := udp.Write(bs.Data)
+		if s.isDeliverable(bs) { // deliver by the filter of session
+			err := send(bs)
 			if err != nil {
-				log.Println(err, n)
+				log.Println(err)
 				break
 			}
-			s.OutBytes += bs.Length
-			s.trk.OutBytes += bs.Length
-			s.chn.OutBytes += bs.Length
+			s.countOutSlot(bs.Length)
 		}
-
-		lpos = (lpos + 1) % b.SizeLen // CAUTION: lpos != trk.RPos
 	}
 	return
 }
@@ -26,6 +26,7 @@ func PangWSReflector(ws *websocket.Conn, qo QueryOption) (err error) {
 	defer pStudio.deleteSessionWithClose(s)
 
 	s.setTimeoutInUnit(qo.Session.Timeout, qo.Session.Unit)
+	s.setQueryOptions(qo)
 
 	for s.isState(Using) {
 		mt, data, err := ws.ReadMessage()
@@ -33,7 +34,7 @@ func PangWSReflector(ws *websocket.Conn, qo QueryOption) (err error) {
 			log.Println("ws.ReadMessage:", err)
 			break
 		}
-		s.InBytes += len(data)
+		s.addIn(len(data))
 
 		// simulate the buffering delay
 		time.Sleep(s.TimeUnit)
@@ -43,7 +44,7 @@ func PangWSReflector(ws *websocket.Conn, qo QueryOption) (err error) {
 			log.Println("ws.WriteMessage:", err)
 			break
 		}
-		s.OutBytes += len(data)
+		s.addOut(len(data))
 	}
 	return
 }
@@ -208,19 +209,30 @@ func PangWSPuller(b *Bridge, ws *websocket.Conn, qo QueryOption) (err error) {
 		return
 	}
 
-	s.src, s.trk, err = s.chn.addSourceTrackByLabel(qo.Source.Label, qo.Track.Label)
+	s.src, s.trk, err = s.chn.addSourceTrackByOption(qo)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 	s.trk.Mode = qo.Track.Mode
 	s.trk.Style = qo.Track.Style
+	err = s.chn.attachTrackProc(s.trk, qo.Track.Proc)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = s.trk.setCompress(qo.Track.Compress, qo.Track.Dict == "on")
+	if err != nil {
+		log.Println(err)
+		return
+	}
 	defer s.resetTrackInfo()
 
 	s.BridgeID = b.ID
 	s.SourceID = qo.Source.Label
 	s.TrackID = qo.Track.Label
 	s.setTimeoutInUnit(qo.Session.Timeout, qo.Session.Unit)
+	s.setQueryOptions(qo)
 	s.chn.AtUsed = time.Now()
 
 	s.chn.pushEvent("pull-in", s.ID, s.Name, s.BridgeID)
@@ -265,6 +277,7 @@ func PangWSPusher(b *Bridge, ws *websocket.Conn, qo QueryOption) (err error) {
 	s.SourceID = qo.Source.Label
 	s.TrackID = qo.Track.Label
 	s.setTimeoutInUnit(qo.Session.Timeout, qo.Session.Unit)
+	s.setQueryOptions(qo)
 	s.chn.AtUsed = time.Now()
 
 	s.chn.pushEvent("push-in", s.ID, s.Name, s.BridgeID)
@@ -319,21 +332,36 @@ func PangWSPublisher(ws *websocket.Conn, qo QueryOption) (err error) {
 		return
 	}
 
-	s.src, s.trk, err = s.chn.addSourceTrackByLabel(qo.Source.Label, qo.Track.Label)
+	s.src, s.trk, err = s.chn.addSourceTrackByOption(qo)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 	s.trk.Mode = qo.Track.Mode
 	s.trk.Style = qo.Track.Style
+	err = s.chn.attachTrackProc(s.trk, qo.Track.Proc)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = s.trk.setCompress(qo.Track.Compress, qo.Track.Dict == "on")
+	if err != nil {
+		log.Println(err)
+		return
+	}
 	defer s.resetTrackInfo()
 
 	// NOTICE: Testing for track buffer size by query option
-	s.trk.setTrackBufferSize(qo.Buffer.Len)
+	if b := s.trk.getRingByOrder(qo.Buffer.Order); b != nil {
+		b.setBufferSizeLen(qo.Buffer.Len) // only the buffer given by buf_order
+	} else {
+		s.trk.setTrackBufferSize(qo.Buffer.Len)
+	}
 
 	s.SourceID = qo.Source.Label
 	s.TrackID = qo.Track.Label
 	s.setTimeoutInUnit(qo.Session.Timeout, qo.Session.Unit)
+	s.setQueryOptions(qo)
 	s.chn.AtUsed = time.Now()
 
 	s.chn.pushEvent("pub-in", s.ID, s.Name, s.RequestID)
@@ -386,8 +414,21 @@ func PangWSSubscriber(ws *websocket.Conn, qo QueryOption) (err error) {
 	s.SourceID = qo.Source.Label
 	s.TrackID = qo.Track.Label
 	s.setTimeoutInUnit(qo.Session.Timeout, qo.Session.Unit)
+	s.setQueryOptions(qo)
 	s.chn.AtUsed = time.Now()
 
+	if qo.Session.Scale != "" || qo.Session.Quality != "" { // to read the rendition track
+		w, h, q, _ := ParseScaleOptions(qo.Session.Scale, qo.Session.Quality)
+		var r *Rendition
+		r, err = s.chn.acquireRendition(qo.Source.Label, qo.Track.Label, w, h, q)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		defer s.chn.releaseRendition(r)
+		s.trk, s.TrackID = r.getTrack(), r.Label
+	}
+
 	s.chn.pushEvent("sub-in", s.ID, s.Name, s.RequestID)
 	defer s.chn.pushEvent("sub-out", s.ID, s.Name, s.RequestID)
 
@@ -420,7 +461,7 @@ func PangWSMedusa(ws *websocket.Conn, qo QueryOption) (err error) {
 		return
 	}
 
-	s.src, s.trk, err = s.chn.addSourceTrackByLabel(qo.Source.Label, qo.Track.Label)
+	s.src, s.trk, err = s.chn.addSourceTrackByOption(qo)
 	if err != nil {
 		log.Println(err)
 		return
@@ -434,6 +475,7 @@ func PangWSMedusa(ws *websocket.Conn, qo QueryOption) (err error) {
 	s.SourceID = qo.Source.Label
 	s.TrackID = qo.Track.Label
 	s.setTimeoutInUnit(qo.Session.Timeout, qo.Session.Unit)
+	s.setQueryOptions(qo)
 	s.chn.AtUsed = time.Now()
 
 	s.chn.pushEvent("meb-in", s.ID, s.Name, s.RequestID)
@@ -468,7 +510,7 @@ func PangWSZebber(ws *websocket.Conn, qo QueryOption) (err error) {
 		return
 	}
 
-	s.src, s.trk, err = s.chn.addSourceTrackByLabel(qo.Source.Label, qo.Track.Label)
+	s.src, s.trk, err = s.chn.addSourceTrackByOption(qo)
 	if err != nil {
 		log.Println(err)
 		return
@@ -478,6 +520,7 @@ func PangWSZebber(ws *websocket.Conn, qo QueryOption) (err error) {
 	s.SourceID = qo.Source.Label
 	s.TrackID = qo.Track.Label
 	s.setTimeoutInUnit(qo.Session.Timeout, qo.Session.Unit)
+	s.setQueryOptions(qo)
 	s.chn.AtUsed = time.Now()
 
 	// -- zeb method = single buffer, multi pubs
@@ -497,26 +540,41 @@ func (trk *Track) handleBuffersByPangWSAPI(ws *websocket.Conn, s *Session, mode
 	defer log.Println("o.handleBuffersByPangWSAPI:", err)
 
 	switch s.Name {
-	case "/pang/ws/pub": // publisher type
-		rbuf := trk.Rings[BUFFER_NUM_FORE] // [0]: foreward direction
-		sbuf := trk.Rings[BUFFER_NUM_BACK] // [1]: backward direction
-		if mode == "bundle" {              // bi-directional
+	case "/pang/ws/pub", "/pang/int/pub": // publisher type, bridge puller
+		var rbuf *Buffer
+		rbuf, err = trk.getRingBySession(s, BUFFER_NUM_FORE) // [0]: foreward direction
+		if err != nil {
+			return
+		}
+		sbuf := trk.getRingByOrder(BUFFER_NUM_BACK) // [1]: backward direction
+		if mode == "bundle" && trk.Parallel == 0 {  // bi-directional
 			go sbuf.sendTrackBufferInWSMessage(ws, s, false) // sender routine
 		}
 		// rbuf.setBufferSizeLen(10) // for testing
 		err = rbuf.recvTrackBufferInWSMessage(ws, s, false) // receiver routine
-	case "/pang/ws/sub": // subscriber type
-		rbuf := trk.Rings[BUFFER_NUM_BACK] // [1]: backward direction
-		sbuf := trk.Rings[BUFFER_NUM_FORE] // [0]: forward direction
-		if mode == "bundle" {              // bi-directional
+	case "/pang/ws/sub", "/pang/int/sub": // subscriber type, bridge pusher
+		rbuf := trk.getRingByOrder(BUFFER_NUM_BACK) // [1]: backward direction
+		if mode == "bundle" && trk.Parallel == 0 {  // bi-directional
 			go rbuf.recvTrackBufferInWSMessage(ws, s, true) // receiver routine
 		}
+		if s.Order == BUFFER_ORDER_ALL { // all buffers interleaved with ring tag
+			err = trk.sendTrackRingsInWSMessage(ws, s, true)
+			break
+		}
+		var sbuf *Buffer
+		sbuf, err = trk.getRingBySession(s, BUFFER_NUM_FORE) // [0]: forward direction
+		if err != nil {
+			return
+		}
 		err = sbuf.sendTrackBufferInWSMessage(ws, s, true) // sender routine
 	case "/pang/ws/meb": // broadcast type apps: text, voice chat, data hub
-		rbuf := trk.Rings[BUFFER_NUM_FORE]                  // [0]: both direction,
-		sbuf := trk.Rings[BUFFER_NUM_FORE]                  // [0]: single buffer
-		go rbuf.recvTrackBufferInWSMessage(ws, s, true)     // from multi pubs
-		err = sbuf.sendTrackBufferInWSMessage(ws, s, false) // to multi subs
+		var buf *Buffer
+		buf, err = trk.getRingBySession(s, BUFFER_NUM_FORE) // [0]: both direction, single buffer
+		if err != nil {
+			return
+		}
+		go buf.recvTrackBufferInWSMessage(ws, s, true)     // from multi pubs
+		err = buf.sendTrackBufferInWSMessage(ws, s, false) // to multi subs
 	default:
 		err = fmt.Errorf("not support Pang WS API: %s", s.Name)
 	}
@@ -532,17 +590,27 @@ func (b *Buffer) sendTrackBufferInWSMessage(ws *websocket.Conn, s *Session, fout
 
 	defer s.setState(Idle)
 
-	// send the mime information for the track
-	if s.chn.isState(Using) && s.trk.Mime != "" {
-		err = ws.WriteMessage(websocket.TextMessage, []byte(s.trk.Mime))
+	// send the mime information for the buffer (pipe)
+	if mime := s.trk.getRingMime(b); s.chn.isState(Using) && mime != "" {
+		err = ws.WriteMessage(websocket.TextMessage, []byte(mime))
 		if err != nil {
 			log.Println(err)
 			return
 		}
-		log.Println(s.trk.Mime)
+		log.Println(b.Label, mime)
 	}
 
-	lpos := b.PosWrite
+	send := func(bs *Slot) error {
+		return ws.WriteMessage(bs.FrameType, s.getSlotData(bs, bs.FrameType == websocket.TextMessage))
+	}
+
+	// send the cached gop first, then the live slots
+	lseq, err := b.burstStartSlots(s, send)
+	if err != nil {
+		return
+	}
+	b.addReader(s, lseq)
+	defer b.deleteReader(s)
 	etime := time.Now().Add(s.TimeOver)
 
 	// extend the websocket timeout by ping message
@@ -554,34 +622,72 @@ func (b *Buffer) sendTrackBufferInWSMessage(ws *websocket.Conn, s *Session, fout
 
 	// send slots in the buffer while the session and channel are using
 	for s.isState(Using) && s.chn.isState(Using) {
-		if lpos == b.PosWrite {
-			if time.Now().After(etime) {
-				if fout { // if the timeout is set, then return
-					log.Println("timeout:", s.TimeOver, s.TimeUnit)
-					return
+		err = s.sendQueuedAcks(send)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		var bs *Slot
+		var nseq uint64
+		bs, nseq, err = b.readSlotByPolicy(s, lseq, BUFFER_GAP_SLOTS)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if bs == nil {
+			if nseq == lseq { // no more slot to send
+				if time.Now().After(etime) {
+					if fout { // if the timeout is set, then return
+						log.Println("timeout:", s.TimeOver, s.TimeUnit)
+						return
+					}
 				}
+				time.Sleep(s.TimeUnit)
 			}
-			time.Sleep(s.TimeUnit)
+			lseq = nseq
 			continue
 		}
+		lseq = nseq
 		etime = time.Now().Add(s.TimeOver)
 
-		bs := b.readSlotByPos(lpos)
-
-		if bs.Head.(string) != s.ID { // skip the self message
-			err = ws.WriteMessage(bs.FrameType, bs.Data)
+		if s.isDeliverable(bs) { // deliver by the filter of session
+			err = send(bs)
 			if err != nil {
 				log.Println(err)
 				return
 			}
 
-			s.OutBytes += bs.Length
-			s.trk.OutBytes += bs.Length
-			s.chn.OutBytes += bs.Length
+			s.countOutSlot(bs.Length)
 		}
+	}
+	return
+}
 
-		lpos = b.setReadPos(lpos)
+// ---------------------------------------------------------------------------------
+// sendTrackRingsInWSMessage(timeout) : sender routine for all buffers with ring tag
+// ---------------------------------------------------------------------------------
+func (trk *Track) sendTrackRingsInWSMessage(ws *websocket.Conn, s *Session, fout bool) (err error) {
+	log.Println("i.sendTrackRingsInWSMessage:", trk.Label)
+	defer log.Println("o.sendTrackRingsInWSMessage:", err)
+
+	defer s.setState(Idle)
+
+	// send the mime information for each buffer (pipe)
+	for i, b := range trk.getRings() {
+		if mime := trk.getRingMime(b); s.chn.isState(Using) && mime != "" {
+			err = ws.WriteMessage(websocket.TextMessage, TagRingData(i, []byte(mime)))
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			log.Println(b.Label, mime)
+		}
 	}
+
+	err = trk.sendTrackRings(s, fout, func(order int, bs *Slot) error {
+		data := s.getSlotData(bs, bs.FrameType == websocket.TextMessage)
+		return ws.WriteMessage(bs.FrameType, TagRingData(order, data))
+	})
 	return
 }
 
@@ -593,6 +699,7 @@ func (b *Buffer) recvTrackBufferInWSMessage(ws *websocket.Conn, s *Session, floc
 	defer log.Println("o.recvTrackBufferInWSMessage:", err)
 
 	defer s.setState(Idle)
+	defer s.trk.deleteCard(s.ID)
 
 	// extend the websocket timeout
 	ws.SetPingHandler(func(msg string) (err error) {
@@ -604,32 +711,48 @@ func (b *Buffer) recvTrackBufferInWSMessage(ws *websocket.Conn, s *Session, floc
 
 	for s.isState(Using) && s.chn.isState(Using) {
 		s.setWebSocketTimeout(ws)
-		bs := Slot{Head: s.ID, FrameType: websocket.BinaryMessage, Mark: RSSP_MARK_RBIN}
+		bs := Slot{Head: s.ID, Group: s.GroupID, FrameType: websocket.BinaryMessage, Mark: RSSP_MARK_RBIN}
 		bs.FrameType, bs.Data, err = ws.ReadMessage()
 		if err != nil {
 			log.Println(err)
 			return
 		}
+		if s.Direct {
+			bs.To, bs.Data = StripDestination(bs.Data)
+		}
+		if s.Capture && bs.FrameType == websocket.BinaryMessage {
+			bs.Capture, bs.Data = StripCaptureTime(bs.Data)
+		}
 
 		if bs.FrameType == websocket.TextMessage {
 			bs.Mark = RSSP_MARK_RTXT
 			if IsExtTextMessage(bs.Data) {
-				err = ProcExtTextMessage(s, s.trk, bs.Data)
+				err = ProcExtTextMessage(s, b, bs.Data)
 				if err != nil {
 					log.Println("ProcExtTextMessage:", err)
 				}
-			} else {
-				s.trk.Mime = string(bs.Data)
-				log.Println(s.Name, s.trk.Label, s.trk.Mime)
+			} else if IsInternalJSONMessage(bs.Data) {
+				if !ProcInternalJSONMessage(s, b, &bs) {
+					continue // done by the server
+				}
+			} else if bs.To == "" { // the directed text is not the mime
+				s.trk.setRingMime(b, string(bs.Data))
 			}
 		}
 
 		bs.getLengthTime()
-		b.writeSlot(bs, flock)
+		bs.Key = s.trk.inspectCodec(&bs, b)
+		s.trk.compressSlot(&bs, b)
+		err = s.secureSlot(&bs)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		bs.Seq = b.writeSlot(bs, flock)
+		b.cacheGopSlot(bs.Seq, bs.Key)
+		s.recordDVRSlot(b, &bs)
 
-		s.InBytes += bs.Length
-		s.trk.InBytes += bs.Length
-		s.chn.InBytes += bs.Length
+		s.countInSlot(bs.Length)
 	}
 	return
 }
@@ -647,7 +770,7 @@ func IsExtTextMessage(data []byte) (ret bool) {
 }
 
 // ---------------------------------------------------------------------------------
-func ProcExtTextMessage(s *Session, trk *Track, data []byte) (err error) {
+func ProcExtTextMessage(s *Session, b *Buffer, data []byte) (err error) {
 	if len(data) < 12 {
 		return fmt.Errorf("invalid ext msg length: %d", len(data))
 	}
@@ -655,11 +778,10 @@ func ProcExtTextMessage(s *Session, trk *Track, data []byte) (err error) {
 	xbody := string(data[8:])  // Body of Ext Message
 	switch xhead {
 	case "MIME": // Text MIME Message (new style)
-		trk.Mime = xbody
-		log.Println("MIME:", trk.Mime)
+		s.trk.setRingMime(b, xbody)
 	case "CARD": // Text Agent Card Message
-		trk.Cards[s.ID] = xbody
-		log.Println("CARD:", trk.Cards[s.ID])
+		s.trk.setCard(s.ID, xbody)
+		log.Println("CARD:", xbody)
 	case "XCMD": // Text Command Message
 	case "XACK": // Text Acknowledgement Message
 	case "XERR": // Text Error Message
@@ -701,6 +823,7 @@ func PangWSPeerDirect(ws *websocket.Conn, qo QueryOption) (err error) {
 	s.SourceID = qo.Source.Label
 	s.TrackID = qo.Track.Label
 	s.setTimeoutInUnit(qo.Session.Timeout, qo.Session.Unit)
+	s.setQueryOptions(qo)
 	s.chn.AtUsed = time.Now()
 
 	s.chn.pushEvent("p2p-in", s.ID, s.Name, s.RequestID)
@@ -757,21 +880,23 @@ func PangWSPeerDirect(ws *websocket.Conn, qo QueryOption) (err error) {
 
 // ---------------------------------------------------------------------------------
 func (s *Session) tossMessageToBuffer(b *Buffer, mt int, msg []byte) (err error) {
-	bs := Slot{Head: s.ID, FrameType: mt, Mark: RSSP_MARK_RBIN}
-	bs.Data = msg
+	bs := Slot{Head: s.ID, Group: s.GroupID, FrameType: mt, Mark: RSSP_MARK_RBIN, Data: msg}
+	if s.Direct {
+		bs.To, bs.Data = StripDestination(msg)
+	}
 
 	if bs.FrameType == websocket.TextMessage {
 		bs.Mark = RSSP_MARK_RTXT
-		b.Mime = string(bs.Data)
-		log.Println(s.Name, s.trk.Label, s.trk.Mime, b.Mime)
+		if bs.To == "" {
+			s.trk.setRingMime(b, string(bs.Data))
+		}
 	}
 
 	bs.getLengthTime()
-	b.writeSlot(bs, false)
+	bs.Seq = b.writeSlot(bs, false)
+	s.recordDVRSlot(b, &bs)
 
-	s.InBytes += bs.Length
-	s.trk.InBytes += bs.Length
-	s.chn.InBytes += bs.Length
+	s.countInSlot(bs.Length)
 	return
 }
 
@@ -0,0 +1,119 @@
+// =================================================================================
+// Filename: api-record-http.go
+// Function: http API to download recordings in fMP4
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var reRecordID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ---------------------------------------------------------------------------------
+// API: /record/{id}.mp4, id is the name of recording without the extension
+//   - mp4 recordings are served as they are, rssp ones are converted at the first request
+//   - range requests are handled by http.ServeContent
+//
+// ---------------------------------------------------------------------------------
+func RecordMP4Download(w http.ResponseWriter, r *http.Request) (err error) {
+	log.Println("IN RecordMP4Download:", r.URL)
+	defer log.Println("OUT RecordMP4Download:", r.URL, err)
+
+	base := path.Base(r.URL.Path)
+	id := strings.TrimSuffix(base, ".mp4")
+	if id == base || !reRecordID.MatchString(id) {
+		err = fmt.Errorf("invalid record id: %s", base)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fname, err := FindRecordMP4File(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	file, err := os.Open(fname)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	if strings.HasSuffix(fname, ".tmp") { // recording in progress, not cached
+		defer os.Remove(fname)
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp4")
+	http.ServeContent(w, r, id+".mp4", fi.ModTime(), file)
+	return
+}
+
+// find the mp4 file of the recording in DirRecord or its channel directories,
+// converting the rssp one into mp4 if not found
+func FindRecordMP4File(id string) (fname string, err error) {
+	for _, ext := range []string{".mp4", RECORD_FILE_EXT} {
+		for _, pattern := range []string{
+			filepath.Join(mConfig.DirRecord, id+ext),
+			filepath.Join(mConfig.DirRecord, "*", id+ext),
+		} {
+			files, _ := filepath.Glob(pattern)
+			if len(files) == 0 {
+				continue
+			}
+			if ext == ".mp4" {
+				return files[0], nil
+			}
+			return ConvertRecordFileToMP4(files[0])
+		}
+	}
+	err = fmt.Errorf("not found record: %s", id)
+	return
+}
+
+// ConvertRecordFileToMP4 converts the rssp file into mp4 at the same directory,
+// the temporary file is returned if the recording is not ended
+func ConvertRecordFileToMP4(rname string) (fname string, err error) {
+	rr, err := OpenRecordReader(rname)
+	if err != nil {
+		return
+	}
+	defer rr.Close()
+
+	tname := strings.TrimSuffix(rname, RECORD_FILE_EXT) + fmt.Sprintf(".%s.tmp", GetXidString())
+	file, err := os.Create(tname)
+	if err != nil {
+		return
+	}
+	_, err = ConvertRecordToMP4(rr, file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tname)
+		return
+	}
+	if rr.Header.AtEnded.IsZero() {
+		return tname, nil
+	}
+
+	fname = strings.TrimSuffix(rname, RECORD_FILE_EXT) + ".mp4"
+	err = os.Rename(tname, fname)
+	log.Println("record converted:", rname, fname, err)
+	return
+}
+
+//=================================================================================
@@ -46,8 +46,12 @@ type MothConfig struct {
 	DirLog       string        `json:"dir_log,omitempty"`
 	DirData      string        `json:"dir_data,omitempty"`
 	DirRecord    string        `json:"dir_record,omitempty"`
+	TransCmd     string        `json:"trans_cmd,omitempty"` // default command template of transcoding
+	RelayURL     string        `json:"relay_url,omitempty"` // upstream server of all channels, ws(s)://host:port
+	RelayKey     string        `json:"relay_key,omitempty"` // stream key of the upstream channels
 	KeyManager   string        `json:"key_manager"`
 	KeyLicense   string        `json:"key_license"`
+	SecureFile   string        `json:"secure_file,omitempty"`
 	SecTicket    bool          `json:"sec_ticket"`
 	SecHost      bool          `json:"sec_host"`
 	HostCIDR     string        `json:"host_cidr"`
@@ -220,6 +224,10 @@ func (d *MothConfig) readConfig(object, fname string) (err error) {
 	if d.DirRecord != "" {
 		os.MkdirAll(d.DirRecord, os.ModePerm)
 	}
+	d.TransCmd = config.TransCmd
+	d.RelayURL = config.RelayURL
+	d.RelayKey = config.RelayKey
+	d.SecureFile = config.SecureFile
 	d.SecHost = config.SecHost
 	d.SecTicket = config.SecTicket
 	d.CORSAllow = config.CORSAllow
@@ -15,6 +15,7 @@ import (
 	"os/exec"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fasthttp/websocket"
@@ -91,20 +92,6 @@ type Handle interface {
 	*websocket.Conn
 }
 
-// ---------------------------------------------------------------------------------
-type Metric struct {
-	BPS      float64 `json:"bps,omitempty"`
-	FPS      float64 `json:"fps,omitempty"`
-	InBytes  int     `json:"in_bytes,omitempty"`
-	OutBytes int     `json:"out_bytes,omitempty"`
-	Interval int     `json:"interval,omitempty"` // in Seconds
-}
-
-func (d *Metric) String() (str string) {
-	return fmt.Sprintf("since: %v, total: (%d,%d), bps:%.2f, fps:%.2f",
-		d.Interval, d.InBytes, d.OutBytes, d.BPS, d.FPS)
-}
-
 // ---------------------------------------------------------------------------------
 // Session: core data structure
 // ---------------------------------------------------------------------------------
@@ -120,8 +107,21 @@ type Session struct {
 	RequestID  string        `json:"request_id,omitempty"`
 	TimeOver   time.Duration `json:"time_over"` // timeout in second
 	TimeUnit   time.Duration `json:"time_unit"` // time clock in scale
+	GopCache   bool          `json:"gop_cache"` // send the cached gop first to subscribe
+	Policy     string        `json:"policy"`    // backpressure policy: latest, keyframe, block, disconnect
+	Stamp      bool          `json:"stamp"`     // prefix the stamp header to the data to subscribe
+	Order      int           `json:"order"`     // buffer order to read or write, -1: default, -2: all
+	Filter     string        `json:"filter"`    // delivery filter: echo, self, (group), all
+	From       time.Time     `json:"from"`      // time to start reading from the dvr
+	Accept     string        `json:"accept"`    // compressed frames to receive: zstd, brotli
+	Capture    bool          `json:"capture"`   // the data of publisher has the capture time prefix
+	Direct     bool          `json:"direct"`    // the data of publisher has the destination prefix
 	// --- internal variables
 	sync.Mutex
+	seqRead   atomic.Uint64 // sequence number of the last read slot
+	waitKey   bool          // waiting the next key frame after the loss
+	lagTime   time.Time     // last time to report the lag
+	acks      chan Slot     // acks of the server to the subscriber, sent by its sender routine
 	eventChan chan EventMessage
 	req       *http.Request
 	chn       *Channel
@@ -143,11 +143,25 @@ func (d *Session) String() (str string) {
 	return
 }
 
+// custom json marshal to take the snapshot of metric
+func (d *Session) MarshalJSON() ([]byte, error) {
+	type Alias Session
+	return json.Marshal(&struct {
+		*Alias
+		Metric MetricRates `json:"metric"`
+	}{
+		Alias:  (*Alias)(d),
+		Metric: d.getRates(),
+	})
+}
+
 func (d *Session) newSessionValue() {
 	d.State = Idle
 	d.AtCreated = time.Now()
 	d.AtUsed = d.AtCreated
+	d.Order = BUFFER_ORDER_NONE
 	d.eventChan = make(chan EventMessage, 2)
+	d.acks = make(chan Slot, IMESSAGE_ACK_SLOTS)
 }
 
 func NewSessionPointer() (d *Session) {
@@ -205,12 +219,97 @@ func (d *Session) setTimeoutInUnit(tout int, tunit string) {
 	log.Println("settime:", tunit, tout, "=>", d.TimeUnit, d.TimeOver)
 }
 
+// set the session options given by the client
+func (d *Session) setQueryOptions(qo QueryOption) {
+	d.GopCache = (qo.Session.Gop != "off")
+	d.Policy = qo.Session.Policy
+	d.Stamp = (qo.Session.Stamp == "on")
+	d.Order = qo.Buffer.Order
+	d.Filter = qo.Track.Filter
+	d.GroupID = qo.Session.Group
+	d.Accept = qo.Session.Accept
+	d.Capture = (qo.Session.Capture == "on")
+	d.Direct = (qo.Session.Direct == "on")
+	if qo.Session.From != "" {
+		from, err := ParseFromTime(qo.Session.From, time.Now())
+		if err != nil {
+			log.Println("invalid from:", err)
+		}
+		d.From = from
+	}
+}
+
+// check if the slot is delivered to the session by its destination or filter
+//   - echo: only its own, self: all except its own
+//   - group: others in the same group, all: its own and others in the same group
+func (d *Session) isDeliverable(bs *Slot) bool {
+	if bs.To != "" { // directed to a session only
+		return d.isAddressed(bs.To)
+	}
+	from, _ := bs.Head.(string)
+	own := (from == d.ID)
+	switch d.Filter {
+	case "echo":
+		return own
+	case "self":
+		return !own
+	case "all":
+		return own || bs.Group == d.GroupID
+	default: // group
+		return !own && bs.Group == d.GroupID
+	}
+}
+
+// check if the destination is the session id, request id or its agent card name
+func (d *Session) isAddressed(to string) bool {
+	if to == d.ID || (d.RequestID != "" && to == d.RequestID) {
+		return true
+	}
+	return d.trk != nil && d.trk.getCardName(d.ID) == to
+}
+
+// get the data of slot to send, with the stamp header if required
+//   - the compressed data is decompressed if the session doesn't accept it
+func (d *Session) getSlotData(bs *Slot, text bool) []byte {
+	if IsCompressedData(bs.Data) && !d.isAcceptable(bs.Data) && d.trk != nil {
+		bs = d.trk.decompressSlot(bs)
+	}
+	if d.Stamp {
+		return StampSlotData(bs, text)
+	}
+	return bs.Data
+}
+
+// count the dropped slots and report it as a channel event, once a second at most
+func (d *Session) reportLag(drops int, lag uint64) {
+	total := d.addDrops(drops)
+	if time.Since(d.lagTime) < time.Second || d.chn == nil {
+		return
+	}
+	d.lagTime = time.Now()
+	data, _ := json.Marshal(struct {
+		Session string `json:"session"`
+		Policy  string `json:"policy"`
+		Drops   int    `json:"drops"`
+		Lag     uint64 `json:"lag"`
+	}{d.ID, d.Policy, total, lag})
+	if !d.chn.tryPushEvent("sub-lag", string(data), d.Name, d.RequestID) {
+		log.Println("sub-lag dropped:", d.ID, string(data))
+	}
+}
+
 func (d *Session) resetTrackInfo() {
 	if d.trk != nil {
+		d.trk.Lock()
 		d.trk.Mime = ""
+		d.trk.Codec = CodecInfo{}
+		for _, r := range d.trk.Rings {
+			r.Mime = ""
+			r.resetGopCache()
+		}
+		d.trk.Unlock()
+		d.trk.resetMetric()
 	}
-	d.trk.InBytes = 0
-	d.trk.OutBytes = 0
 }
 
 // ---------------------------------------------------------------------------------
@@ -227,16 +326,27 @@ type Channel struct {
 	Eventers    map[string]*websocket.Conn `json:"-"`
 	EventState  State                      `json:"-"`
 
-	RecordAuto  bool  `json:"record_auto"`  // recording
-	RecordState State `json:"record_state"` //
-	TransAuto   bool  `json:"trans_auto"`   // transcoding
-	TransState  State `json:"trans_state"`  //
-	ProcsAuto   bool  `json:"procs_auto"`   // processing
-	ProcsState  State `json:"procs_state"`  //
-	RelayAuto   bool  `json:"relay_auto"`   // relaying
-	RelayState  State `json:"relay_state"`  //
-	ShootAuto   bool  `json:"shoot_auto"`   // shooting
-	ShootState  State `json:"shoot_state"`
+	DVRWindow     int            `json:"dvr_window"`              // time-shift window in seconds, 0: off
+	RecordAuto    bool           `json:"record_auto"`             // recording
+	RecordState   State          `json:"record_state"`            //
+	RecordFile    string         `json:"record_file,omitempty"`   // current file in recording
+	RecordFormat  string         `json:"record_format,omitempty"` // rssp (default), mp4
+	TransAuto     bool           `json:"trans_auto"`              // transcoding
+	TransState    State          `json:"trans_state"`             //
+	TransCmd      string         `json:"trans_cmd,omitempty"`     // command template, {channel} {source} {track} {mime} {codec}
+	TransSuffix   string         `json:"trans_suffix,omitempty"`  // label suffix of derived tracks, @480p
+	TransFraming  string         `json:"trans_framing,omitempty"` // stdin/stdout: (frame) 4CC+length, raw
+	TransMime     string         `json:"trans_mime,omitempty"`    // mime of derived tracks, the same if empty
+	ProcsAuto     bool           `json:"procs_auto"`              // processing
+	ProcsState    State          `json:"procs_state"`             //
+	RelayAuto     bool           `json:"relay_auto"`              // relaying
+	RelayState    State          `json:"relay_state"`             //
+	RelayTargets  []*RelayTarget `json:"relay_targets,omitempty"` // upstream servers
+	ShootAuto     bool           `json:"shoot_auto"`              // shooting
+	ShootState    State          `json:"shoot_state"`
+	ShootInterval int            `json:"shoot_interval,omitempty"` // seconds between snapshots
+	ShootKeep     int            `json:"shoot_keep,omitempty"`     // number of snapshots kept per track
+	ShootTracks   string         `json:"shoot_tracks,omitempty"`   // track labels or source/track, empty for all
 	// --- internal variables
 	sync.Mutex
 	eventChan chan EventMessage `json:"-"`
@@ -245,6 +355,9 @@ type Channel struct {
 	procsCmd  *exec.Cmd         `json:"-"`
 	relayCmd  *exec.Cmd         `json:"-"`
 	shootCmd  *exec.Cmd         `json:"-"`
+	// renditions of jpeg tracks by source/label, shared by the subscribers
+	renditions map[string]*Rendition
+	scaleLock  sync.Mutex
 }
 
 // custom json marshal
@@ -255,10 +368,12 @@ func (d *Channel) MarshalJSON() ([]byte, error) {
 	type Alias Channel
 	return json.Marshal(&struct {
 		*Alias
-		Publishers  int `json:"n_pubs"`
-		Subscribers int `json:"n_subs"`
+		Metric      MetricRates `json:"metric"` // snapshot, not to race with the sampler
+		Publishers  int         `json:"n_pubs"`
+		Subscribers int         `json:"n_subs"`
 	}{
 		Alias:       (*Alias)(d),
+		Metric:      d.getRates(),
 		Publishers:  len(d.Publishers),
 		Subscribers: len(d.Subscribers),
 	})
@@ -274,6 +389,10 @@ func (d *Channel) String() (str string) {
 	str += fmt.Sprintf("\n\tRecord: %v, %s, Trans: %v, %s, Procs: %v, %s, Relay: %v, %s |",
 		d.RecordAuto, d.RecordState.String(), d.TransAuto, d.TransState.String(),
 		d.ProcsAuto, d.ProcsState.String(), d.RelayAuto, d.RelayState.String())
+	str += fmt.Sprintf("\n\tShoot: %v, %s, %ds, %s |", d.ShootAuto, d.ShootState.String(), d.ShootInterval, d.ShootTracks)
+	for _, rt := range d.RelayTargets {
+		str += fmt.Sprintf("\n\tRelay to: %s", rt.String())
+	}
 	str += fmt.Sprintf("\n\tstats: %s", d.Metric.String())
 	str += fmt.Sprintf("\n\tPubs(%d): ", len(d.Publishers))
 	for k := range d.Publishers {
@@ -292,6 +411,7 @@ func (d *Channel) newChannelValue() {
 	d.AtCreated = time.Now()
 	d.AtUsed = d.AtCreated
 	d.initChannelData()
+	d.initRelayTargets()
 }
 
 func (d *Channel) setChannelValue() {
@@ -302,7 +422,9 @@ func (d *Channel) setChannelValue() {
 	d.TransState = Idle
 	d.ProcsState = Idle
 	d.RelayState = Idle
+	d.ShootState = Idle
 	d.initChannelData()
+	d.initRelayTargets()
 }
 
 func (d *Channel) initChannelData() {
@@ -351,6 +473,16 @@ func (d *Channel) deletePublisher(s *Session) {
 	delete(d.Publishers, s.ID)
 }
 
+// count the publishers other than the derived ones, called with the lock
+func (d *Channel) countSourcePublishers() (n int) {
+	for _, s := range d.Publishers {
+		if !strings.Contains(s.TrackID, TRANS_DERIVED_MARK) {
+			n++
+		}
+	}
+	return
+}
+
 // ---------------------------------------------------------------------------------
 func (d *Channel) addSubscriber(s *Session) {
 	d.Lock()
@@ -403,6 +535,40 @@ func (d *Channel) findSourceTrackByLabel(slabel, tlabel string) (s *Source, t *T
 }
 
 func (d *Channel) addSourceTrackByLabel(slabel, tlabel string) (s *Source, t *Track, err error) {
+	return d.addSourceTrackBySize(slabel, tlabel, BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+}
+
+// add the source and track with the buffer size given by the query option of publisher
+//   - buf_cap: number of slots to allocate, buf_len: number of slots to use
+//   - parallel: number of parallel buffers in the track, dual buffers if not given
+func (d *Channel) addSourceTrackByOption(qo QueryOption) (s *Source, t *Track, err error) {
+	ncap, nuse := BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS
+	if qo.Buffer.Cap > 0 {
+		if qo.Buffer.Cap >= BUFFER_MIN_SLOTS && qo.Buffer.Cap <= BUFFER_MAX_CAPS {
+			ncap = qo.Buffer.Cap
+		} else {
+			log.Println("invalid buf_cap:", qo.Buffer.Cap)
+		}
+	}
+	if qo.Buffer.Len >= BUFFER_MIN_SLOTS && qo.Buffer.Len <= ncap {
+		nuse = qo.Buffer.Len
+	}
+	if nuse > ncap {
+		nuse = ncap
+	}
+	nring := 0
+	if qo.Track.Parallel > 0 {
+		if qo.Track.Parallel >= TRACK_MIN_BUFFERS && qo.Track.Parallel <= TRACK_MAX_BUFFERS {
+			nring = qo.Track.Parallel
+		} else {
+			err = fmt.Errorf("invalid parallel: %d (%d-%d)", qo.Track.Parallel, TRACK_MIN_BUFFERS, TRACK_MAX_BUFFERS)
+			return
+		}
+	}
+	return d.addSourceTrackBySize(qo.Source.Label, qo.Track.Label, ncap, nuse, nring)
+}
+
+func (d *Channel) addSourceTrackBySize(slabel, tlabel string, ncap, nuse, nring int) (s *Source, t *Track, err error) {
 	d.Lock()
 	defer d.Unlock()
 
@@ -416,11 +582,13 @@ func (d *Channel) addSourceTrackByLabel(slabel, tlabel string) (s *Source, t *Tr
 	}
 	t = s.Tracks[tlabel] // already the track is allocated?
 	if t == nil {
-		t = s.addTrackByLabel(tlabel, BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS) // add the track if not exist
+		t = s.addTrackByLabel(tlabel, ncap, nuse, nring) // add the track if not exist
 		if t == nil {
 			err = fmt.Errorf("add track %s error", tlabel)
 			return
 		}
+	} else if nring > t.countRings() { // made by a subscriber before the publisher
+		t.expandTrackBuffers(ncap, nuse, nring)
 	}
 	return
 }
@@ -457,6 +625,7 @@ func (d *Channel) purgeAllSourceTracks() (err error) {
 	// remove sources and their tracks of channel
 	for _, s := range d.Sources {
 		for _, t := range s.Tracks {
+			t.closeDVR()
 			s.deleteTrackByLabel(t.Label)
 		}
 		d.deleteSourceByLabel(s.Label)
@@ -477,6 +646,10 @@ func (d *Channel) resetAllSourceTracks() (err error) {
 		for _, t := range s.Tracks {
 			t.Style = "mono"
 			t.Mime = ""
+			t.Codec = CodecInfo{}
+			for _, r := range t.Rings {
+				r.Mime = ""
+			}
 		}
 	}
 	return
@@ -486,7 +659,9 @@ func (d *Channel) ListResources(cs, ct string) (str string) {
 	d.Lock()
 	defer d.Unlock()
 
-	str += fmt.Sprintf("[channel] %s, %s, %s (%d,%d)\n", d.ID, d.Name, d.State, d.InBytes, d.OutBytes)
+	in, out := d.getBytes()
+	m := d.getRates()
+	str += fmt.Sprintf("[channel] %s, %s, %s (%d,%d) bps:%.0f/%.0f\n", d.ID, d.Name, d.State, in, out, m.BPS, m.OutBPS)
 	for _, s := range d.Sources {
 		if cs != "" {
 			if !strings.Contains(s.Label, cs) {
@@ -500,12 +675,21 @@ func (d *Channel) ListResources(cs, ct string) (str string) {
 					continue
 				}
 			}
+			in, out := t.getBytes()
 			str += fmt.Sprintf("\t\t[track] %s, %s: %s,%s, %d (%d,%d) %s\n",
-				t.ID, t.Label, t.Mode, t.Style, t.Num, t.InBytes, t.OutBytes, t.ProcName)
+				t.ID, t.Label, t.Mode, t.Style, t.Num, in, out, t.ProcName)
+			m := t.getRates()
+			str += fmt.Sprintf("\t\t\tRate: %.0f bps, %.2f fps, out %.0f bps, peak %.0f bps\n", m.BPS, m.FPS, m.OutBPS, m.PeakBPS)
+			t.RLock()
 			str += fmt.Sprintf("\t\t\tMIME: %s\n", t.Mime)
+			if t.Codec.Name != "" {
+				str += fmt.Sprintf("\t\t\tCodec: %s\n", t.Codec)
+			}
+			t.RUnlock()
 			for i, b := range t.Rings {
-				str += fmt.Sprintf("\t\t\t[%d] %s, N:%d/%2d W:%d,R:%d\tmime:%s\n",
-					i, b.Label, b.SizeLen, b.SizeCap, b.PosRead, b.PosWrite, b.Mime)
+				rpos, wpos := b.getPositions()
+				str += fmt.Sprintf("\t\t\t[%d] %s, N:%d/%2d W:%d,R:%d S:%d\tmime:%s\n",
+					i, b.Label, b.getSizeLen(), b.SizeCap, wpos, rpos, b.getWriteSeq(), t.getRingMime(b))
 			}
 			str += "\n"
 		}
@@ -519,6 +703,13 @@ func (d *Channel) pushEvent(name, data, path, reqid string) {
 
 	em := EventMessage{Type: "event", ID: GetXidString(),
 		Name: name, Data: data, Path: path, RequestID: reqid, AtCreated: time.Now()}
+	if name == "pub-in" { // start the workers if RecordAuto, ShootAuto, TransAuto, ProcsAuto, RelayAuto
+		d.startRecorder()
+		d.startShooter()
+		d.startTranscoder()
+		d.startProcessor() // always, for the auto processors by mime
+		d.startRelayer()
+	}
 	if d.isEventState(Using) { // don't send when channel event handler is not ready
 		d.eventChan <- em
 	}
@@ -530,6 +721,22 @@ func (d *Channel) pushEvent(name, data, path, reqid string) {
 	}
 }
 
+// tryPushEvent pushes the event without blocking, called in the path of slots,
+// returning false if dropped by the busy handler
+func (d *Channel) tryPushEvent(name, data, path, reqid string) bool {
+	if !d.isEventState(Using) {
+		return true
+	}
+	em := EventMessage{Type: "event", ID: GetXidString(),
+		Name: name, Data: data, Path: path, RequestID: reqid, AtCreated: time.Now()}
+	select {
+	case d.eventChan <- em:
+		return true
+	default:
+		return false
+	}
+}
+
 func (d *Channel) addEventer(id string, ws *websocket.Conn) {
 	d.Lock()
 	defer d.Unlock()
@@ -0,0 +1,84 @@
+// =================================================================================
+// Filename: data-base_test.go
+// Function: Test functions for data-base.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"testing"
+
+	"github.com/fasthttp/websocket"
+)
+
+// ---------------------------------------------------------------------------------
+func TestSessionFilter(t *testing.T) {
+	s := NewSessionPointerWithName("/pang/ws/meb")
+	s.GroupID = "red"
+
+	own := &Slot{Head: s.ID, Group: "red"}
+	mate := &Slot{Head: "mate", Group: "red"}
+	other := &Slot{Head: "other", Group: "blue"}
+
+	tests := []struct {
+		filter string
+		want   [3]bool // own, mate, other
+	}{
+		{"echo", [3]bool{true, false, false}},
+		{"self", [3]bool{false, true, true}},
+		{"group", [3]bool{false, true, false}},
+		{"all", [3]bool{true, true, false}},
+		{"", [3]bool{false, true, false}},
+	}
+	for _, tt := range tests {
+		s.Filter = tt.filter
+		got := [3]bool{s.isDeliverable(own), s.isDeliverable(mate), s.isDeliverable(other)}
+		if got != tt.want {
+			t.Error("invalid filter:", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestSessionDirected(t *testing.T) {
+	trk := NewTrackDualBuffers("cmd", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS)
+	dev := NewSessionPointerWithNameRequest("/pang/ws/meb", "dev-req")
+	dev.trk = trk
+	peer := NewSessionPointerWithName("/pang/ws/meb")
+	peer.trk = trk
+	trk.setCard(dev.ID, `{"name": "drone-1", "version": "1.0"}`)
+
+	to, body := StripDestination(AddressData("drone-1", []byte("land")))
+	if to != "drone-1" || string(body) != "land" {
+		t.Fatal("invalid destination:", to, string(body))
+	}
+
+	for _, to := range []string{dev.ID, "dev-req", "drone-1"} {
+		bs := &Slot{Head: "ctrl", To: to}
+		if !dev.isDeliverable(bs) || peer.isDeliverable(bs) {
+			t.Error("invalid directed delivery:", to)
+		}
+	}
+
+	trk.deleteCard(dev.ID)
+	if dev.isDeliverable(&Slot{Head: "ctrl", To: "drone-1"}) {
+		t.Error("deleted card is addressed")
+	}
+	if !peer.isDeliverable(&Slot{Head: "ctrl"}) {
+		t.Error("broadcast is not delivered")
+	}
+
+	// the destination prefix is taken only from the publisher of direct=on
+	b := trk.getRingByOrder(BUFFER_NUM_FORE)
+	peer.chn = NewChannelPointer()
+	for _, direct := range []bool{false, true} {
+		peer.Direct = direct
+		peer.tossMessageToBuffer(b, websocket.BinaryMessage, AddressData("drone-1", []byte("land")))
+		bs := b.readSlotBySeq(b.getWriteSeq())
+		if direct != (bs.To == "drone-1" && string(bs.Data) == "land") {
+			t.Error("invalid direct option:", direct, bs.To)
+		}
+	}
+}
+
+//=================================================================================
@@ -7,9 +7,12 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fasthttp/websocket"
@@ -17,15 +20,22 @@ import (
 
 // ---------------------------------------------------------------------------------
 const (
-	TRACK_MIN_BUFFERS = 2  // min number of buffers (pipes), 2(default)
-	TRACK_MAX_BUFFERS = 10 // max number of buffers (pipes)
-	BUFFER_MIN_SLOTS  = 2  // min number of slots, 2(default)
-	BUFFER_MAX_SLOTS  = 30 // max number of slots, 30(default)
-	BUFFER_LEN_SLOTS  = 20 // 2 - 30, 15(default)
-	BUFFER_CAP_SLOTS  = 30 // 30fps, 1sec
-	BUFFER_GAP_SLOTS  = 2  // minimum distansce between rpos and wpos
-	BUFFER_NUM_FORE   = 0  // forward buffer index
-	BUFFER_NUM_BACK   = 1  // backward buffer index
+	TRACK_MIN_BUFFERS = 2                // min number of buffers (pipes), 2(default)
+	TRACK_MAX_BUFFERS = 10               // max number of buffers (pipes)
+	BUFFER_MIN_SLOTS  = 2                // min number of slots, 2(default)
+	BUFFER_MAX_SLOTS  = 30               // max number of slots, 30(default)
+	BUFFER_LEN_SLOTS  = 20               // 2 - 30, 15(default)
+	BUFFER_CAP_SLOTS  = 30               // 30fps, 1sec
+	BUFFER_MAX_CAPS   = 300              // max number of slots to allocate by buf_cap
+	BUFFER_GAP_SLOTS  = 2                // minimum distansce between rpos and wpos
+	BUFFER_BLOCK_WAIT = time.Second      // max time for a writer to wait blocking readers
+	BUFFER_NUM_FORE   = 0                // forward buffer index
+	BUFFER_NUM_BACK   = 1                // backward buffer index
+	BUFFER_ORDER_NONE = -1               // buf_order not given, default buffer
+	BUFFER_ORDER_ALL  = -2               // buf_order=all, all buffers interleaved
+	GOP_MAX_SLOTS     = 300              // max number of slots in a gop cache, 10sec at 30fps
+	GOP_MAX_BYTES     = 16 * 1024 * 1024 // max bytes of slots in a gop cache
+	GOP_MAX_PARAMS    = 4                // max number of parameter set slots before a key frame
 )
 
 // ---------------------------------------------------------------------------------
@@ -56,9 +66,14 @@ func (d *Head) String() (str string) {
 type Slot struct {
 	FrameType int         `json:"frame_type,omitempty"` // frameType : binary, text
 	Head      interface{} `json:"head,omitempty"`       // multipart mime header (internal)
-	To        string      `json:"to,omitempty"`         // mime type of Data
+	To        string      `json:"to,omitempty"`         // destination: session id, request id or card name
+	Group     string      `json:"group,omitempty"`      // group id of the sender
 	Mime      string      `json:"mime,omitempty"`       // mime type of Data
+	Seq       uint64      `json:"seq,omitempty"`        // sequence number in the buffer
+	Key       bool        `json:"key,omitempty"`        // key frame of video
+	Param     bool        `json:"param,omitempty"`      // parameter sets of video
 	Time      time.Time   `json:"time,omitempty"`       // buffering time
+	Capture   time.Time   `json:"capture,omitempty"`    // capture time given by publisher
 	Length    int         `json:"length,omitempty"`     // size of Data
 	Mark      string      `json:"mark,omitempty"`       // mark of Data
 	Data      []byte      `json:"data,omitempty"`       // binary data, itself
@@ -96,65 +111,371 @@ type Pipe struct {
 
 // ---------------------------------------------------------------------------------
 // Buffer is a kind of circular buffer with slots
+//   - single producer (or writers serialized by the lock) and multiple consumers
+//   - each slot is published atomically with its sequence number, so readers never
+//     see a torn slot and can detect the slots overwritten before they read them
+//
 // ---------------------------------------------------------------------------------
 type Buffer struct {
-	ID       string `json:"id"`        // from: xid
-	Label    string `json:"label"`     // -> data mime?
-	Mime     string `json:"mime"`      // mime type of stream, not used now!
-	PosRead  int    `json:"pos_read"`  // read position
-	PosWrite int    `json:"pos_write"` // write position
-	SizeLen  int    `json:"size_len"`  // number of slots currently used
-	SizeCap  int    `json:"size_cap"`  // number of slots allocated
-	Slots    []Slot `json:"-"`         // slots to record buffer data
+	ID      string `json:"id"`       // from: xid
+	Label   string `json:"label"`    // -> data mime?
+	Mime    string `json:"mime"`     // mime type of stream in the buffer (pipe)
+	SizeCap int    `json:"size_cap"` // number of slots allocated
 	// --- internal variables
+	sizeLen  atomic.Int64           // number of slots currently used
+	seqWrite atomic.Uint64          // sequence number of the last written slot
+	seqKey   atomic.Uint64          // sequence number of the last key frame slot
+	slots    []atomic.Pointer[Slot] // slots to record buffer data
+	gop      GopCache               // slots from the last key frame
+	readers  map[*Session]bool      // sessions reading the buffer, true if blocking
+	nblock   atomic.Int32           // number of blocking readers
+	rlock    sync.Mutex             // lock for readers
 	sync.RWMutex
 }
 
 func (d *Buffer) String() (str string) {
-	str += fmt.Sprintf("%s Num: %d/%d, Pos: %d,%d", d.Label, d.SizeLen, d.SizeCap, d.PosRead, d.PosWrite)
+	rpos, wpos := d.getPositions()
+	str += fmt.Sprintf("%s Num: %d/%d, Pos: %d,%d", d.Label, d.getSizeLen(), d.SizeCap, rpos, wpos)
 	return
 }
 
+// custom json marshal to show the current positions of the buffer
+func (d *Buffer) MarshalJSON() ([]byte, error) {
+	rpos, wpos := d.getPositions()
+
+	type Alias Buffer
+	return json.Marshal(&struct {
+		*Alias
+		PosRead  int    `json:"pos_read"`
+		PosWrite int    `json:"pos_write"`
+		SizeLen  int    `json:"size_len"`
+		SeqWrite uint64 `json:"seq_write"`
+	}{
+		Alias:    (*Alias)(d),
+		PosRead:  rpos,
+		PosWrite: wpos,
+		SizeLen:  d.getSizeLen(),
+		SeqWrite: d.getWriteSeq(),
+	})
+}
+
 func NewBuffer(label string, n, m int) (b *Buffer) {
 	b = &Buffer{
 		ID:      GetXidString(),
 		Label:   label,
 		SizeCap: n,
-		SizeLen: m,
-		Slots:   make([]Slot, n),
+		slots:   make([]atomic.Pointer[Slot], n),
 	}
+	b.sizeLen.Store(int64(m))
 	return
 }
 
+func (d *Buffer) getSizeLen() int {
+	return int(d.sizeLen.Load())
+}
+
 func (d *Buffer) setBufferSizeLen(sz int) {
 	if sz > 1 && sz <= d.SizeCap {
-		d.SizeLen = sz
+		d.sizeLen.Store(int64(sz))
+	}
+}
+
+func (d *Buffer) getWriteSeq() uint64 {
+	return d.seqWrite.Load()
+}
+
+func (d *Buffer) getKeySeq() uint64 {
+	return d.seqKey.Load()
+}
+
+// positions of the last written slot and the next slot to write
+func (d *Buffer) getPositions() (rpos, wpos int) {
+	n := uint64(d.getSizeLen())
+	if n == 0 {
+		return
 	}
+	seq := d.getWriteSeq()
+	rpos = int(seq % n)
+	wpos = int((seq + 1) % n)
+	return
 }
 
-func (d *Buffer) readSlotByPos(pos int) (b Slot) {
-	b = d.Slots[pos]
-	// log.Println(d.PosRead, d.PosWrite, b.Header)
+// the slot of the sequence number, nil if not written yet or already overwritten
+func (d *Buffer) readSlotBySeq(seq uint64) (bs *Slot) {
+	if seq == 0 || seq > d.getWriteSeq() {
+		return nil
+	}
+	bs = d.slots[seq%uint64(d.getSizeLen())].Load()
+	if bs == nil || bs.Seq != seq {
+		return nil
+	}
 	return
 }
 
-func (d *Buffer) writeSlot(b Slot, flock bool) {
+// readSlotNext returns the slot after lseq and the sequence number of it (nseq).
+//   - (nil, lseq, 0) : no more slot to read
+//   - (nil, nseq, n) : n slots are lost by overwriting, read again from nseq
+//   - if gap > 0 and the reader is behind more than gap slots, jump to the latest
+func (d *Buffer) readSlotNext(lseq uint64, gap int) (bs *Slot, nseq uint64, lost int) {
+	wseq := d.getWriteSeq()
+	if lseq >= wseq {
+		return nil, lseq, 0
+	}
+
+	nseq = lseq + 1
+	if size := uint64(d.getSizeLen()); wseq-lseq > size {
+		nseq = wseq - size + 1 // lapped by the writer, oldest slot in the buffer
+	}
+	if gap > 0 && wseq-lseq > uint64(gap) {
+		nseq = wseq // too slow to follow, go to the latest slot
+	}
+
+	bs = d.readSlotBySeq(nseq)
+	if bs == nil { // overwritten while reading, skip it
+		return nil, nseq, int(nseq - lseq)
+	}
+	lost = int(nseq - lseq - 1)
+	return
+}
+
+// writeSlot publishes the slot and returns its sequence number
+func (d *Buffer) writeSlot(b Slot, flock bool) (seq uint64) {
 	if flock { // multi-use case of buffer such as meb
 		d.Lock()
 		defer d.Unlock()
 	}
-	d.Slots[d.PosWrite] = b
-	d.PosRead = d.PosWrite
-	d.PosWrite = (d.PosWrite + 1) % d.SizeLen
-	// log.Println(d.PosRead, d.PosWrite, b.Header)
+	seq = d.getWriteSeq() + 1
+	if d.nblock.Load() > 0 {
+		d.waitBlockingReaders(seq)
+	}
+	b.Seq = seq
+	d.slots[seq%uint64(d.getSizeLen())].Store(&b)
+	d.seqWrite.Store(seq)
+	if b.Key {
+		d.seqKey.Store(seq)
+	}
+	// log.Println(seq, b.Head)
+	return
 }
 
-func (d *Buffer) setReadPos(lpos int) (rpos int) {
-	if Abs(d.PosWrite-lpos) > BUFFER_GAP_SLOTS {
-		rpos = d.PosWrite
-	} else {
-		rpos = (lpos + 1) % d.SizeLen // CAUTION: lpos != trk.RPos
+// ---------------------------------------------------------------------------------
+// readers of the buffer and their backpressure policies
+// ---------------------------------------------------------------------------------
+func (d *Buffer) addReader(s *Session, lseq uint64) {
+	d.rlock.Lock()
+	defer d.rlock.Unlock()
+	if d.readers == nil {
+		d.readers = make(map[*Session]bool)
+	}
+	fblock := (s.Policy == "block")
+	s.seqRead.Store(lseq)
+	d.readers[s] = fblock
+	if fblock {
+		d.nblock.Add(1)
+	}
+}
+
+func (d *Buffer) deleteReader(s *Session) {
+	d.rlock.Lock()
+	defer d.rlock.Unlock()
+	fblock, ok := d.readers[s]
+	if !ok {
+		return
+	}
+	delete(d.readers, s)
+	if fblock {
+		d.nblock.Add(-1)
+	}
+}
+
+func (d *Buffer) countReaders() int {
+	d.rlock.Lock()
+	defer d.rlock.Unlock()
+	return len(d.readers)
+}
+
+// waitBlockingReaders waits the blocking readers to read the slot to be overwritten by seq
+func (d *Buffer) waitBlockingReaders(seq uint64) {
+	size := uint64(d.getSizeLen())
+	if seq <= size {
+		return
+	}
+	oldest := seq - size // slot to be overwritten
+
+	d.rlock.Lock()
+	var blockers []*Session
+	for s, fblock := range d.readers {
+		if fblock {
+			blockers = append(blockers, s)
+		}
+	}
+	d.rlock.Unlock()
+
+	etime := time.Now().Add(BUFFER_BLOCK_WAIT)
+	for _, s := range blockers {
+		for s.isState(Using) && s.seqRead.Load() < oldest {
+			if time.Now().After(etime) {
+				log.Println("blocking reader timeout:", s.ID, s.seqRead.Load(), oldest)
+				d.downgradeReader(s) // not to stall the writer at every slot
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// downgradeReader makes the blocking reader lose the slots as others, reported by sub-lag
+func (d *Buffer) downgradeReader(s *Session) {
+	d.rlock.Lock()
+	defer d.rlock.Unlock()
+	if fblock, ok := d.readers[s]; ok && fblock {
+		d.readers[s] = false
+		d.nblock.Add(-1)
+	}
+}
+
+// readSlotByPolicy reads the next slot for the session with its backpressure policy
+//   - latest(default) : jump to the latest slot if behind more than gap slots
+//   - keyframe : jump to the last key frame, or wait the next key frame after the loss,
+//     the same as latest while the track has no key frame (text, data)
+//   - block : the writer waits the reader not to lose any slot (reliable data),
+//     downgraded to lose the slots if it is too slow to read in BUFFER_BLOCK_WAIT
+//   - disconnect : return an error to close the slow consumer
+func (d *Buffer) readSlotByPolicy(s *Session, lseq uint64, gap int) (bs *Slot, nseq uint64, err error) {
+	skip := 0
+	policy := s.Policy
+	if policy == "keyframe" && d.getKeySeq() == 0 {
+		policy = "latest"
+	}
+	switch policy {
+	case "keyframe":
+		wseq := d.getWriteSeq()
+		if wseq > lseq+uint64(BUFFER_GAP_SLOTS) {
+			if kseq := d.getKeySeq(); kseq > lseq+1 && d.readSlotBySeq(kseq) != nil {
+				skip = int(kseq - lseq - 1)
+				lseq = kseq - 1
+			}
+		}
+		gap = 0
+	case "block", "disconnect":
+		gap = 0
+	}
+
+	bs, nseq, lost := d.readSlotNext(lseq, gap)
+	lost += skip
+	s.seqRead.Store(nseq)
+
+	if policy == "keyframe" {
+		if lost > skip {
+			s.waitKey = true
+		}
+		if bs != nil && s.waitKey {
+			if bs.Key || bs.Param { // the parameter sets come before the key frame
+				s.waitKey = false
+			} else {
+				bs = nil // drop it until the next key frame
+				lost++
+			}
+		}
+	}
+	if lost > 0 {
+		s.reportLag(lost, d.getWriteSeq()-nseq)
+		if s.Policy == "disconnect" {
+			err = fmt.Errorf("slow consumer: %s lost %d slots", s.ID, lost)
+			return nil, nseq, err
+		}
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// GopCache keeps the slots from the last key frame for late subscribers
+// ---------------------------------------------------------------------------------
+type GopCache struct {
+	Slots []*Slot // slots from the last key frame
+	Bytes int     // total bytes of the slots
+	// --- internal variables
+	params []*Slot // parameter sets waiting for the key frame
+	sync.Mutex
+}
+
+// cacheGopSlot adds the written slot of seq into the gop cache
+//   - the cache restarts at every key frame and is dropped if it grows too big
+//   - the parameter sets in the slots right before the key frame are kept with it
+func (d *Buffer) cacheGopSlot(seq uint64, fkey bool) {
+	bs := d.readSlotBySeq(seq)
+	if bs == nil {
+		return
+	}
+
+	g := &d.gop
+	g.Lock()
+	defer g.Unlock()
+	if bs.Param && !fkey {
+		if len(g.params) >= GOP_MAX_PARAMS {
+			g.params = g.params[1:]
+		}
+		g.params = append(g.params, bs)
+	}
+	params := g.params
+	if !bs.Param || fkey {
+		g.params = nil
+	}
+	if fkey {
+		g.Slots, g.Bytes = append(g.Slots[:0], params...), 0
+		for _, ps := range params {
+			g.Bytes += ps.Length
+		}
+	} else if len(g.Slots) == 0 {
+		return // wait for the next key frame
+	}
+	if len(g.Slots) >= GOP_MAX_SLOTS || g.Bytes+bs.Length > GOP_MAX_BYTES {
+		log.Println("gop cache overflow:", d.Label, len(g.Slots), g.Bytes)
+		g.Slots, g.Bytes = nil, 0
+		return
+	}
+	g.Slots = append(g.Slots, bs)
+	g.Bytes += bs.Length
+}
+
+func (d *Buffer) getGopSlots() (slots []*Slot) {
+	d.gop.Lock()
+	defer d.gop.Unlock()
+	return append(slots, d.gop.Slots...)
+}
+
+func (d *Buffer) resetGopCache() {
+	d.gop.Lock()
+	defer d.gop.Unlock()
+	d.gop.Slots, d.gop.Bytes, d.gop.params = nil, 0, nil
+}
+
+// burstGopSlots sends the cached gop slots to a new subscriber before the live slots
+//   - returns the sequence number to continue reading the live slots
+func (d *Buffer) burstGopSlots(s *Session, send func(bs *Slot) error) (lseq uint64, err error) {
+	lseq = d.getWriteSeq()
+	if !s.GopCache {
+		return
 	}
+
+	slots := d.getGopSlots()
+	if len(slots) == 0 {
+		return
+	}
+	log.Println("gop burst:", s.ID, len(slots))
+
+	for _, bs := range slots {
+		if !s.isDeliverable(bs) { // skip by the filter of session
+			continue
+		}
+		err = send(bs)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		s.countOutSlot(bs.Length)
+	}
+	lseq = slots[len(slots)-1].Seq
 	return
 }
 
@@ -165,31 +486,49 @@ type Track struct {
 	ID       string                      `json:"id"`              // track id
 	Label    string                      `json:"label"`           // track label assigned by user
 	Mime     string                      `json:"mime"`            // mime type of stream
-	Mode     string                      `json:"mode"`            // single(default), bundle
+	Mode     string                      `json:"mode"`            // single(default), bundle, secure
 	Style    string                      `json:"style"`           // mono(default), multi
 	Num      int                         `json:"num"`             // number of buffers
+	Parallel int                         `json:"parallel"`        // number of parallel buffers, 0 if dual
 	Rings    []*Buffer                   `json:"rings,omitempty"` // forward[0], backward[1], ...
 	Hands    []*websocket.Conn           `json:"-"`               // Not used
 	Zebs     map[*websocket.Conn]*Buffer `json:"-"`               // testing ...
 	Cards    map[string]string           `json:"cards,omitempty"` // agent card information
-	InBytes  int                         `json:"in_bytes,omitempty"`
-	OutBytes int                         `json:"out_bytes,omitempty"`
 	ProcName string                      `json:"proc_name,omitempty"`
+	Compress string                      `json:"compress,omitempty"` // zstd, brotli for text or json
+	Codec    CodecInfo                   `json:"codec,omitempty"`    // parsed from the bitstream
+	Vehicles []MavVehicle                `json:"vehicles,omitempty"` // telemetry decoded from mavlink
 	Metric   `json:"metric"`
 	// --- internal variables
+	cardNames map[string]string // card name by session id, for the directed delivery
+	dvr       *DVR              // disk ring for time-shift, if the channel enables it
+	comp      *Compressor       // compressor of slots, kept with the dictionaries
 	sync.RWMutex
 }
 
 func (d *Track) String() (str string) {
 	d.Lock()
 	defer d.Unlock()
-	str += fmt.Sprintf("[%s] %s:%s,%s %d (%d,%d)", d.ID, d.Label, d.Mime, d.Mode, d.Num, d.InBytes, d.OutBytes)
+	in, out := d.getBytes()
+	str += fmt.Sprintf("[%s] %s:%s,%s %d (%d,%d)", d.ID, d.Label, d.Mime, d.Mode, d.Num, in, out)
 	for _, r := range d.Rings {
 		str += r.String() + "\t"
 	}
 	return
 }
 
+// custom json marshal to take the snapshot of metric
+func (d *Track) MarshalJSON() ([]byte, error) {
+	type Alias Track
+	return json.Marshal(&struct {
+		*Alias
+		Metric MetricRates `json:"metric"`
+	}{
+		Alias:  (*Alias)(d),
+		Metric: d.getRates(),
+	})
+}
+
 // make a new track for bundle use of bi-directional communication
 func NewTrackDualBuffers(tlabel string, n, m int) (t *Track) {
 	t = &Track{ID: GetXidString(), Label: tlabel, Num: 2}
@@ -207,14 +546,196 @@ func NewTrackMultipleBuffers(tlabel string, n, m, k int) (t *Track) {
 		t.Rings = append(t.Rings, NewBuffer(blabel, n, m))
 		t.Hands = append(t.Hands, nil)
 	}
+	t.Parallel = k
 	// t.Chain = make(chan Slot, 1)
 	return
 }
 
+// expand the track to k parallel buffers, when the track was made by a subscriber before
+func (d *Track) expandTrackBuffers(n, m, k int) {
+	d.Lock()
+	defer d.Unlock()
+	for i := len(d.Rings); i < k; i++ {
+		blabel := fmt.Sprintf("no%02d", i)
+		d.Rings = append(d.Rings, NewBuffer(blabel, n, m))
+		d.Hands = append(d.Hands, nil)
+	}
+	d.Num = len(d.Rings)
+	d.Parallel = d.Num
+	log.Println("expand track:", d.Label, d.Num)
+}
+
+// set the mime of the ring (pipe), the mime of forward ring is the track mime
+func (d *Track) setRingMime(b *Buffer, mime string) {
+	fore := b == d.getRingByOrder(BUFFER_NUM_FORE)
+	d.Lock()
+	b.Mime = mime
+	if fore {
+		d.Mime = mime
+	}
+	d.Unlock()
+	log.Println("mime:", d.Label, b.Label, mime)
+}
+
+func (d *Track) getRingMime(b *Buffer) string {
+	d.RLock()
+	defer d.RUnlock()
+	return b.Mime
+}
+
+// get the ring by its order number, nil if invalid
+func (d *Track) getRingByOrder(order int) (b *Buffer) {
+	d.RLock()
+	defer d.RUnlock()
+	if order < 0 || order >= len(d.Rings) {
+		return nil
+	}
+	return d.Rings[order]
+}
+
+// setBufferByQuery sets the length and mime of the ring selected by buf_order, for set_buffer
+func (d *Track) setBufferByQuery(qo *QueryOption) (buf *Buffer, err error) {
+	buf = d.getRingByOrder(qo.Buffer.Order)
+	if buf == nil {
+		err = fmt.Errorf("invalid buffer order: %d/%d", qo.Buffer.Order, d.countRings())
+		return
+	}
+	if qo.Buffer.Len > 0 {
+		if qo.Buffer.Len < BUFFER_MIN_SLOTS || qo.Buffer.Len > buf.SizeCap {
+			err = fmt.Errorf("invalid buffer length: %d (%d-%d)", qo.Buffer.Len, BUFFER_MIN_SLOTS, buf.SizeCap)
+			return
+		}
+		buf.setBufferSizeLen(qo.Buffer.Len)
+	}
+	if qo.Buffer.Mime != "" {
+		d.setRingMime(buf, qo.Buffer.Mime)
+	}
+	return
+}
+
+// get the ring selected by buf_order of the session, or the default ring if not given
+func (d *Track) getRingBySession(s *Session, order int) (b *Buffer, err error) {
+	if s.Order >= 0 {
+		order = s.Order
+	}
+	b = d.getRingByOrder(order)
+	if b == nil {
+		err = fmt.Errorf("invalid buffer order: %d/%d", order, d.countRings())
+	}
+	return
+}
+
+// set the agent card of the session, its name is used as a destination of slot
+func (d *Track) setCard(id, card string) {
+	d.Lock()
+	defer d.Unlock()
+	if d.Cards == nil {
+		d.Cards = make(map[string]string)
+		d.cardNames = make(map[string]string)
+	}
+	d.Cards[id] = card
+	d.cardNames[id] = GetCardName(card)
+}
+
+func (d *Track) deleteCard(id string) {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.Cards, id)
+	delete(d.cardNames, id)
+}
+
+func (d *Track) getCardName(id string) string {
+	d.RLock()
+	defer d.RUnlock()
+	return d.cardNames[id]
+}
+
+// get the name of agent card, "name" of json or the card itself
+func GetCardName(card string) string {
+	var ac struct {
+		Name string `json:"name"`
+	}
+	if json.Unmarshal([]byte(card), &ac) == nil && ac.Name != "" {
+		return ac.Name
+	}
+	return strings.TrimSpace(card)
+}
+
+// get the snapshot of all rings
+func (d *Track) getRings() (rings []*Buffer) {
+	d.RLock()
+	defer d.RUnlock()
+	return append(rings, d.Rings...)
+}
+
+func (d *Track) countRings() int {
+	d.RLock()
+	defer d.RUnlock()
+	return len(d.Rings)
+}
+
+// sendTrackRings sends the slots of all rings interleaved, for buf_order=all of subscriber
+//   - send is called with the order of ring to tag the data
+func (d *Track) sendTrackRings(s *Session, fout bool, send func(order int, bs *Slot) error) (err error) {
+	rings := d.getRings()
+	lseqs := make([]uint64, len(rings))
+	for i, b := range rings {
+		lseqs[i], err = b.burstStartSlots(s, func(bs *Slot) error { return send(i, bs) })
+		if err != nil {
+			return
+		}
+		b.addReader(s, lseqs[i])
+		defer b.deleteReader(s)
+	}
+	etime := time.Now().Add(s.TimeOver)
+
+	// send slots in the rings by round robin while the session and channel are using
+	for s.isState(Using) && s.chn.isState(Using) {
+		err = s.sendQueuedAcks(func(bs *Slot) error { return send(BUFFER_NUM_FORE, bs) })
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		idle := true
+		for i, b := range rings {
+			var bs *Slot
+			var nseq uint64
+			bs, nseq, err = b.readSlotByPolicy(s, lseqs[i], BUFFER_GAP_SLOTS)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			if nseq != lseqs[i] {
+				idle = false
+			}
+			lseqs[i] = nseq
+			if bs == nil || !s.isDeliverable(bs) { // skip by the filter of session
+				continue
+			}
+			etime = time.Now().Add(s.TimeOver)
+
+			err = send(i, bs)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			s.countOutSlot(bs.Length)
+		}
+		if idle { // no more slot to send in all rings
+			if fout && time.Now().After(etime) {
+				log.Println("timeout:", s.TimeOver, s.TimeUnit)
+				return
+			}
+			time.Sleep(s.TimeUnit)
+		}
+	}
+	return
+}
+
 func (d *Track) setTrackBufferSize(blen int) {
 	d.Lock()
 	defer d.Unlock()
-	if blen < BUFFER_MIN_SLOTS || blen > BUFFER_MAX_SLOTS {
+	if blen < BUFFER_MIN_SLOTS || blen > BUFFER_MAX_CAPS {
 		log.Println("invalid buffer size:", blen)
 		return
 	}
@@ -229,6 +750,46 @@ func (d *Track) resetTrackInfo() {
 	d.Mode = ""
 	d.Label = ""
 	d.Mime = ""
+	d.Codec = CodecInfo{}
+	for _, r := range d.Rings {
+		r.Mime = ""
+	}
+}
+
+// inspectCodec checks the key frame of the slot in the ring b
+//   - the codec info of the track is updated only by the forward ring
+func (d *Track) inspectCodec(bs *Slot, b *Buffer) (fkey bool) {
+	if bs.FrameType != websocket.BinaryMessage || IsSecureData(bs.Data) {
+		return
+	}
+	codec := GetCodecFromMime(d.getRingMime(b))
+	if codec == "" {
+		return
+	}
+	fkey = IsKeyFrame(codec, bs.Data)
+	bs.Param = IsParamSet(codec, bs.Data)
+	if !(fkey || bs.Param) || b != d.getRingByOrder(BUFFER_NUM_FORE) {
+		return
+	}
+	ci, ok := ParseCodecInfo(codec, bs.Data)
+	if !ok {
+		return
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	prev := d.Codec
+	d.Codec.merge(ci)
+	if d.Codec != prev {
+		log.Println("codec:", d.Label, d.Codec)
+	}
+	return
+}
+
+func (d *Track) getCodec() CodecInfo {
+	d.RLock()
+	defer d.RUnlock()
+	return d.Codec
 }
 
 // ---------------------------------------------------------------------------------
@@ -299,7 +860,8 @@ func NewSource(label string) (s *Source) {
 // 	return d.Tracks[tlabel]
 // }
 
-func (d *Source) addTrackByLabel(tlabel string, ncap, nuse int) (t *Track) {
+// add the track with dual buffers, or with nring parallel buffers if nring > 0
+func (d *Source) addTrackByLabel(tlabel string, ncap, nuse, nring int) (t *Track) {
 	d.Lock()
 	defer d.Unlock()
 	t = d.Tracks[tlabel]
@@ -309,7 +871,12 @@ func (d *Source) addTrackByLabel(tlabel string, ncap, nuse int) (t *Track) {
 	}
 
 	// t = NewTrackDualBuffers(tlabel, BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS)
-	t = NewTrackDualBuffers(tlabel, ncap, nuse)
+	if nring > 0 {
+		t = NewTrackMultipleBuffers(tlabel, ncap, nuse, nring)
+	} else {
+		t = NewTrackDualBuffers(tlabel, ncap, nuse)
+	}
+	log.Println("add track:", tlabel, ncap, nuse, nring)
 	d.Tracks[tlabel] = t
 	d.Num = len(d.Tracks)
 	return
@@ -0,0 +1,523 @@
+// =================================================================================
+// Filename: data-buffer_test.go
+// Function: Test and benchmark functions for data-buffer.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// ---------------------------------------------------------------------------------
+// legacyBuffer is the previous mutex based implementation, kept for benchmarking
+// ---------------------------------------------------------------------------------
+type legacyBuffer struct {
+	PosRead  int
+	PosWrite int
+	SizeLen  int
+	Slots    []Slot
+	sync.RWMutex
+}
+
+func newLegacyBuffer(n, m int) *legacyBuffer {
+	return &legacyBuffer{SizeLen: m, Slots: make([]Slot, n)}
+}
+
+func (d *legacyBuffer) writeSlot(b Slot) {
+	d.Lock()
+	defer d.Unlock()
+	d.Slots[d.PosWrite] = b
+	d.PosRead = d.PosWrite
+	d.PosWrite = (d.PosWrite + 1) % d.SizeLen
+}
+
+func (d *legacyBuffer) readSlotByPos(pos int) (b Slot, wpos int) {
+	d.RLock()
+	defer d.RUnlock()
+	return d.Slots[pos], d.PosWrite
+}
+
+// ---------------------------------------------------------------------------------
+func newSeqSlot(seq uint64) Slot {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, seq)
+	return Slot{Head: "test", Data: data, Length: len(data)}
+}
+
+// ---------------------------------------------------------------------------------
+func TestBufferReadSequential(t *testing.T) {
+	b := NewBuffer("test", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS)
+
+	lseq := b.getWriteSeq()
+	if bs, nseq, _ := b.readSlotNext(lseq, 0); bs != nil || nseq != lseq {
+		t.Fatal("read from the empty buffer:", bs, nseq)
+	}
+
+	for i := uint64(1); i <= 5; i++ {
+		if seq := b.writeSlot(newSeqSlot(i), false); seq != i {
+			t.Fatal("invalid write seq:", seq, i)
+		}
+	}
+
+	for i := uint64(1); i <= 5; i++ {
+		bs, nseq, lost := b.readSlotNext(lseq, 0)
+		if bs == nil || nseq != i || lost != 0 {
+			t.Fatal("invalid read:", bs, nseq, lost)
+		}
+		if binary.BigEndian.Uint64(bs.Data) != i {
+			t.Fatal("invalid data:", bs.Data, i)
+		}
+		lseq = nseq
+	}
+}
+
+func TestBufferReadOverwritten(t *testing.T) {
+	b := NewBuffer("test", 4, 4)
+
+	for i := uint64(1); i <= 10; i++ {
+		b.writeSlot(newSeqSlot(i), false)
+	}
+
+	// the slots 1..6 were overwritten, the oldest one in the buffer is 7
+	if bs := b.readSlotBySeq(3); bs != nil {
+		t.Fatal("overwritten slot is read:", bs.Seq)
+	}
+	bs, nseq, lost := b.readSlotNext(0, 0)
+	if bs == nil || nseq != 7 || lost != 6 {
+		t.Fatal("invalid read:", bs, nseq, lost)
+	}
+
+	// the reader behind more than the gap jumps to the latest slot
+	bs, nseq, _ = b.readSlotNext(7, 2)
+	if bs == nil || nseq != 10 {
+		t.Fatal("invalid gap read:", bs, nseq)
+	}
+}
+
+func TestBufferConcurrentReaders(t *testing.T) {
+	b := NewBuffer("test", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS)
+	const total = 20000
+
+	var done atomic.Bool
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var lseq uint64
+			for !done.Load() || lseq < b.getWriteSeq() {
+				bs, nseq, _ := b.readSlotNext(lseq, 0)
+				lseq = nseq
+				if bs == nil {
+					continue
+				}
+				if binary.BigEndian.Uint64(bs.Data) != bs.Seq {
+					t.Error("torn slot:", bs.Seq, bs.Data)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := uint64(1); i <= total; i++ {
+		b.writeSlot(newSeqSlot(i), false)
+		if i%1000 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	done.Store(true)
+	wg.Wait()
+}
+
+func TestBufferGopCache(t *testing.T) {
+	b := NewBuffer("test", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS)
+	key := []byte{0, 0, 0, 1, 0x65, 0x88}
+	delta := []byte{0, 0, 1, 0x41, 0x9a}
+
+	sps, pps := []byte{0, 0, 0, 1, 0x67, 0x42}, []byte{0, 0, 0, 1, 0x68, 0xce}
+	write := func(data []byte) {
+		seq := b.writeSlot(Slot{Head: "pub", FrameType: websocket.BinaryMessage, Data: data, Length: len(data),
+			Param: IsParamSet("h264", data)}, false)
+		b.cacheGopSlot(seq, IsKeyFrame("h264", data))
+	}
+
+	write(delta) // ignored before the first key frame
+	write(key)
+	write(delta)
+	write(delta)
+	if slots := b.getGopSlots(); len(slots) != 3 || slots[0].Seq != 2 {
+		t.Fatal("invalid gop cache:", len(slots))
+	}
+
+	write(key) // restart at the next key frame
+	write(delta)
+	slots := b.getGopSlots()
+	if len(slots) != 2 || slots[0].Seq != 5 || slots[1].Seq != 6 {
+		t.Fatal("invalid gop cache:", len(slots))
+	}
+
+	// the parameter sets in their own slots are kept before the key frame
+	write(sps)
+	write(pps)
+	if slots = b.getGopSlots(); len(slots) != 4 {
+		t.Fatal("gop is restarted by the parameter sets:", len(slots))
+	}
+	write(key)
+	slots = b.getGopSlots()
+	if len(slots) != 3 || slots[0].Seq != 7 || slots[2].Seq != 9 || !slots[0].Param {
+		t.Fatal("parameter sets are not kept:", len(slots))
+	}
+	write(sps)
+	write(delta)
+	write(key) // not right before the key frame
+	if slots = b.getGopSlots(); len(slots) != 1 || slots[0].Seq != 12 {
+		t.Fatal("stale parameter sets are kept:", len(slots))
+	}
+	write(delta)
+
+	s := &Session{GopCache: true, trk: &Track{}, chn: &Channel{}}
+	s.ID = "sub"
+	n := 0
+	lseq, err := b.burstGopSlots(s, func(bs *Slot) error { n++; return nil })
+	if err != nil || n != 2 || lseq != 13 {
+		t.Fatal("invalid gop burst:", n, lseq, err)
+	}
+}
+
+func TestBufferReadPolicy(t *testing.T) {
+	newSession := func(policy string) *Session {
+		s := NewSessionPointerWithName("/pang/ws/sub")
+		s.Policy = policy
+		return s
+	}
+
+	// disconnect the slow consumer
+	b := NewBuffer("test", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS)
+	for i := uint64(1); i <= 40; i++ {
+		b.writeSlot(newSeqSlot(i), false)
+	}
+	s := newSession("disconnect")
+	if _, _, err := b.readSlotByPolicy(s, 0, BUFFER_GAP_SLOTS); err == nil {
+		t.Fatal("slow consumer is not disconnected")
+	}
+
+	// skip to the last key frame
+	b = NewBuffer("test", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS)
+	for i := uint64(1); i <= 30; i++ {
+		bs := newSeqSlot(i)
+		bs.Key = (i == 25)
+		b.writeSlot(bs, false)
+	}
+	s = newSession("keyframe")
+	bs, nseq, err := b.readSlotByPolicy(s, 10, BUFFER_GAP_SLOTS)
+	if err != nil || bs == nil || nseq != 25 || s.getRates().Drops != 14 {
+		t.Fatal("invalid keyframe skip:", bs, nseq, s.getRates().Drops, err)
+	}
+
+	// block the writer not to lose any slot
+	b = NewBuffer("test", 4, 4)
+	s = newSession("block")
+	b.addReader(s, 0)
+	defer b.deleteReader(s)
+	go func() {
+		for i := uint64(1); i <= 20; i++ {
+			b.writeSlot(newSeqSlot(i), false)
+		}
+	}()
+	var lseq uint64
+	for lseq < 20 {
+		bs, nseq, err := b.readSlotByPolicy(s, lseq, BUFFER_GAP_SLOTS)
+		if err != nil || (bs == nil && nseq != lseq) {
+			t.Fatal("slot is lost in block policy:", lseq, nseq, err)
+		}
+		lseq = nseq
+		time.Sleep(time.Millisecond)
+	}
+	if s.getRates().Drops != 0 {
+		t.Fatal("invalid drops:", s.getRates().Drops)
+	}
+
+	// the blocking reader too slow is downgraded after a timeout, not stalling every write
+	b = NewBuffer("test", 4, 4)
+	s = newSession("block")
+	b.addReader(s, 0)
+	defer b.deleteReader(s)
+	start := time.Now()
+	for i := uint64(1); i <= 12; i++ {
+		b.writeSlot(newSeqSlot(i), false)
+	}
+	if d := time.Since(start); d < BUFFER_BLOCK_WAIT || d > 2*BUFFER_BLOCK_WAIT || b.nblock.Load() != 0 {
+		t.Fatal("blocking reader is not downgraded:", d, b.nblock.Load())
+	}
+
+	// keyframe policy of the track without key frame is the same as latest
+	b = NewBuffer("test", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS)
+	for i := uint64(1); i <= 30; i++ {
+		b.writeSlot(newSeqSlot(i), false)
+	}
+	s = newSession("keyframe")
+	if bs, _, _ := b.readSlotByPolicy(s, 10, BUFFER_GAP_SLOTS); bs == nil || s.waitKey {
+		t.Fatal("slots are dropped waiting no key frame")
+	}
+}
+
+func TestSessionReportLag(t *testing.T) {
+	s := NewSessionPointerWithName("/pang/ws/sub")
+	s.Policy = `latest"`
+	s.chn = NewChannelPointer()
+	s.chn.setEventState(Using)
+	for i := 0; i < cap(s.chn.eventChan); i++ { // the handler is busy
+		s.chn.pushEvent("test", "", "", "")
+	}
+
+	// dropped without blocking the reader
+	done := make(chan struct{})
+	go func() {
+		s.reportLag(3, 10)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reader is blocked by the event")
+	}
+
+	// the event of json escaped
+	for len(s.chn.eventChan) > 0 {
+		<-s.chn.eventChan
+	}
+	s.lagTime = time.Time{}
+	s.reportLag(1, 20)
+	em := <-s.chn.eventChan
+	var v struct {
+		Policy string `json:"policy"`
+		Drops  int    `json:"drops"`
+		Lag    uint64 `json:"lag"`
+	}
+	if err := json.Unmarshal([]byte(em.Data), &v); err != nil || em.Name != "sub-lag" || v.Policy != s.Policy || v.Drops != 4 || v.Lag != 20 {
+		t.Fatal("invalid sub-lag event:", em.Data, err)
+	}
+}
+
+func TestTrackParallelRings(t *testing.T) {
+	trk := NewTrackMultipleBuffers("tile", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 3)
+	trk.expandTrackBuffers(BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 4)
+	if trk.countRings() != 4 || trk.Parallel != 4 {
+		t.Fatal("invalid parallel track:", trk.countRings(), trk.Parallel)
+	}
+
+	s := NewSessionPointerWithName("/pang/ws/sub")
+	s.trk = trk
+	s.chn = NewChannelPointer()
+	s.chn.setState(Using)
+	s.setTimeoutInUnit(1, "milli")
+	s.Order = BUFFER_ORDER_ALL
+	s.Policy = "block" // not to skip the slots written at once
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		for i := uint64(1); i <= 3; i++ {
+			trk.getRingByOrder(0).writeSlot(newSeqSlot(i), false)
+			trk.getRingByOrder(2).writeSlot(newSeqSlot(i), false)
+		}
+	}()
+
+	counts := make(map[int]int)
+	err := trk.sendTrackRings(s, false, func(order int, bs *Slot) error {
+		n, body := UntagRingData(TagRingData(order, bs.Data))
+		if n != order || binary.BigEndian.Uint64(body) != bs.Seq {
+			t.Error("invalid ring tag:", n, order)
+		}
+		counts[order]++
+		if counts[0]+counts[2] == 6 {
+			s.setState(Idle)
+		}
+		return nil
+	})
+	if err != nil || counts[0] != 3 || counts[2] != 3 || len(counts) != 2 {
+		t.Fatal("invalid interleaved read:", counts, err)
+	}
+}
+
+func TestTrackSetBuffer(t *testing.T) {
+	trk := NewTrackMultipleBuffers("video", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 2)
+	tests := []struct {
+		order int
+		len   int
+		mime  string
+		fok   bool
+	}{
+		{0, 10, "video/h264", true},
+		{1, 0, "video/jpeg", true}, // mime only
+		{1, BUFFER_CAP_SLOTS, "", true},
+		{2, 10, "", false},                    // no such ring
+		{BUFFER_ORDER_NONE, 10, "", false},    // not given
+		{0, BUFFER_MIN_SLOTS - 1, "", false},  // too short
+		{0, BUFFER_CAP_SLOTS + 1, "", false},  // over the capacity
+		{1, BUFFER_MAX_CAPS, "text/x", false}, // not applied partially
+	}
+	for i, tt := range tests {
+		qo := &QueryOption{}
+		qo.Buffer.Order, qo.Buffer.Len, qo.Buffer.Mime = tt.order, tt.len, tt.mime
+		buf, err := trk.setBufferByQuery(qo)
+		if (err == nil) != tt.fok {
+			t.Fatal("invalid result:", i, err)
+		}
+		if !tt.fok {
+			continue
+		}
+		if tt.len > 0 && buf.getSizeLen() != tt.len {
+			t.Error("invalid length:", i, buf.getSizeLen())
+		}
+		if tt.mime != "" && trk.getRingMime(buf) != tt.mime {
+			t.Error("invalid mime:", i, trk.getRingMime(buf))
+		}
+	}
+	if trk.getRingMime(trk.getRingByOrder(1)) != "video/jpeg" {
+		t.Fatal("mime is changed by the failed setting")
+	}
+}
+
+func TestTrackRingMimeConcurrent(t *testing.T) {
+	s := NewSessionPointerWithName("/pang/tcp/sub")
+	s.chn = NewChannelPointer()
+	s.trk = NewTrackDualBuffers("video", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS)
+	b := s.trk.getRingByOrder(BUFFER_NUM_FORE)
+
+	// the publisher changes the mime while the subscribers and manager read it, checked by -race
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			s.trk.setRingMime(b, []string{MIME_VIDEO_H264, MIME_VIDEO_JPEG}[i%2])
+		}
+	}()
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	bs := Slot{FrameType: websocket.BinaryMessage, Data: []byte{0, 0, 0, 1, 0x65}}
+	for i := 0; i < 100; i++ {
+		b.sendTrackBufferInTCPMessage(conn, s, true) // returned at once, the channel is not using
+		s.trk.inspectCodec(&bs, b)
+		_ = s.chn.ListResources("", "")
+	}
+	wg.Wait()
+}
+
+func TestQueryBufferOptions(t *testing.T) {
+	tests := []struct {
+		query string
+		order int
+		cap   int
+		len   int
+	}{
+		{"", BUFFER_ORDER_NONE, 0, 0},
+		{"buf_order=all", BUFFER_ORDER_ALL, 0, 0},
+		{"buf_order=2&buf_cap=60&buf_len=40", 2, 60, 40},
+		{"buf_order=x&buf_cap=y&buf_len=z", BUFFER_ORDER_NONE, 0, 0}, // ignored if invalid
+	}
+	for _, tt := range tests {
+		qo, err := GetQueryOptionFromString("ws", "/pang/ws/sub", "channel=test-buffer&"+tt.query)
+		if err != nil || qo.Buffer.Order != tt.order || qo.Buffer.Cap != tt.cap || qo.Buffer.Len != tt.len {
+			t.Fatal("invalid buffer options:", tt.query, qo.Buffer.Order, qo.Buffer.Cap, qo.Buffer.Len, err)
+		}
+	}
+
+	// the ring selected by buf_order of the session
+	trk := NewTrackMultipleBuffers("video", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 2)
+	s := NewSessionPointerWithName("/pang/ws/sub")
+	for _, tt := range []struct{ order, def, want int }{{BUFFER_ORDER_NONE, 1, 1}, {0, 1, 0}, {2, 0, -1}} {
+		s.Order = tt.order
+		b, err := trk.getRingBySession(s, tt.def)
+		if (tt.want < 0) != (err != nil) || (err == nil && b != trk.getRingByOrder(tt.want)) {
+			t.Fatal("invalid ring by session:", tt, err)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------------
+func BenchmarkBufferWrite(b *testing.B) {
+	r := NewBuffer("test", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS)
+	s := newSeqSlot(0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.writeSlot(s, false)
+	}
+}
+
+func BenchmarkLegacyBufferWrite(b *testing.B) {
+	r := newLegacyBuffer(BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS)
+	s := newSeqSlot(0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.writeSlot(s)
+	}
+}
+
+func BenchmarkBufferWriteWithReaders(b *testing.B) {
+	r := NewBuffer("test", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS)
+	s := newSeqSlot(0)
+
+	var done atomic.Bool
+	var wg sync.WaitGroup
+	for k := 0; k < 4; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var lseq uint64
+			for !done.Load() {
+				_, lseq, _ = r.readSlotNext(lseq, BUFFER_GAP_SLOTS)
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.writeSlot(s, false)
+	}
+	b.StopTimer()
+	done.Store(true)
+	wg.Wait()
+}
+
+func BenchmarkLegacyBufferWriteWithReaders(b *testing.B) {
+	r := newLegacyBuffer(BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS)
+	s := newSeqSlot(0)
+
+	var done atomic.Bool
+	var wg sync.WaitGroup
+	for k := 0; k < 4; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lpos := 0
+			for !done.Load() {
+				_, wpos := r.readSlotByPos(lpos)
+				if lpos != wpos {
+					lpos = (lpos + 1) % r.SizeLen
+				}
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.writeSlot(s)
+	}
+	b.StopTimer()
+	done.Store(true)
+	wg.Wait()
+}
+
+//=================================================================================
@@ -0,0 +1,314 @@
+// =================================================================================
+// Filename: data-compress.go
+// Function: Per-track payload compression of text and json slots
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/fasthttp/websocket"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ---------------------------------------------------------------------------------
+// Compressed frame: "RCMP" + algorithm(1) + dictionary id(4) + compressed data
+//   - algorithm: 'z' zstd, 'b' brotli
+//   - dictionary id: 0 if not used, got by the "get_dict" control message
+//
+// In compress=zstd|brotli of publisher, the server compresses the binary slots of
+// text or json mime once on ingest. Subscribers of accept=zstd,brotli receive them
+// as they are, and the others receive the decompressed data.
+//   - the text frames are not compressed, they are the mime of ring, internal control
+//     or directed messages. Telemetry is to be sent as binary slots of json mime.
+//   - the dvr keeps the compressed frames, decompressed by the track on replay, but
+//     the recorder writes the original data since the dictionaries are not in the file.
+//
+// ---------------------------------------------------------------------------------
+const (
+	COMPRESS_MARK         = "RCMP"
+	COMPRESS_HEAD_LEN     = 9
+	COMPRESS_MIN_SIZE     = 32               // smaller slots are not compressed
+	COMPRESS_MAX_SIZE     = 16 * 1024 * 1024 // max size to decompress
+	COMPRESS_DICT_SLOTS   = 32               // number of slots to train the dictionary
+	COMPRESS_DICT_MAX     = 16 * 1024        // max size of the dictionary
+	COMPRESS_BROTLI_LEVEL = 5
+)
+
+func IsCompressedData(data []byte) bool {
+	return len(data) > COMPRESS_HEAD_LEN && string(data[:4]) == COMPRESS_MARK
+}
+
+// check if the mime is compressed, text or json
+func IsCompressibleMime(mime string) bool {
+	mime, _, _ = strings.Cut(mime, ";")
+	mime = strings.TrimSpace(mime)
+	return strings.HasPrefix(mime, "text/") || mime == "application/json" ||
+		strings.HasSuffix(mime, "+json") || mime == "application/x-ndjson"
+}
+
+func GetCompressAlgo(data []byte) string {
+	if !IsCompressedData(data) {
+		return ""
+	}
+	switch data[4] {
+	case 'z':
+		return "zstd"
+	case 'b':
+		return "brotli"
+	}
+	return ""
+}
+
+// ---------------------------------------------------------------------------------
+// Compressor of track, keeping the dictionaries to decompress the slots in rings
+// ---------------------------------------------------------------------------------
+type Compressor struct {
+	Algo   string `json:"algo"`              // zstd, brotli
+	Dict   bool   `json:"dict"`              // train the dictionary, zstd only
+	DictID uint32 `json:"dict_id,omitempty"` // current dictionary
+	// --- internal variables
+	samples  [][]byte
+	dicts    map[uint32][]byte
+	encoder  *zstd.Encoder
+	decoders map[uint32]*zstd.Decoder
+	sync.Mutex
+}
+
+func NewCompressor(algo string, dict bool) (d *Compressor, err error) {
+	if algo != "zstd" && algo != "brotli" {
+		err = fmt.Errorf("invalid compress: %s [zstd|brotli]", algo)
+		return
+	}
+	d = &Compressor{Algo: algo, Dict: dict && algo == "zstd"}
+	d.dicts = make(map[uint32][]byte)
+	d.decoders = make(map[uint32]*zstd.Decoder)
+	return
+}
+
+// setCompress sets the compression of track by the publisher, kept if the same
+func (d *Track) setCompress(algo string, dict bool) (err error) {
+	d.Lock()
+	defer d.Unlock()
+	if algo == "" || algo == "off" {
+		d.Compress = ""
+		return
+	}
+	if d.comp != nil && d.comp.Algo == algo && d.comp.Dict == dict {
+		d.Compress = algo
+		return
+	}
+	comp, err := NewCompressor(algo, dict)
+	if err != nil {
+		return
+	}
+	if d.comp != nil { // to decompress the slots left in rings
+		comp.dicts = d.comp.dicts
+	}
+	d.comp = comp
+	d.Compress = algo
+	return
+}
+
+func (d *Track) getCompressor() *Compressor {
+	d.RLock()
+	defer d.RUnlock()
+	return d.comp
+}
+
+// compressSlot compresses the binary slot of text or json in the ring b,
+// kept as it is if not smaller or already compressed by the client
+func (d *Track) compressSlot(bs *Slot, b *Buffer) {
+	d.RLock()
+	comp := d.comp
+	fon := d.Compress != ""
+	d.RUnlock()
+	if !fon || bs.FrameType != websocket.BinaryMessage || len(bs.Data) < COMPRESS_MIN_SIZE ||
+		IsCompressedData(bs.Data) || IsSecureData(bs.Data) || !IsCompressibleMime(d.getRingMime(b)) {
+		return
+	}
+	data, err := comp.compress(bs.Data)
+	if err != nil {
+		log.Println("compress:", err)
+		return
+	}
+	if len(data) < len(bs.Data) {
+		bs.Data = data
+		bs.Length = len(data)
+	}
+}
+
+// decompressData returns the original data of the compressed frame
+func (d *Track) decompressData(data []byte) ([]byte, error) {
+	comp := d.getCompressor()
+	if comp == nil {
+		return nil, fmt.Errorf("no compressor of track: %s", d.Label)
+	}
+	return comp.decompress(data)
+}
+
+// decompressSlot returns the copy of slot having the original data if compressed,
+// the slot itself if not or failed
+func (d *Track) decompressSlot(bs *Slot) *Slot {
+	if !IsCompressedData(bs.Data) {
+		return bs
+	}
+	data, err := d.decompressData(bs.Data)
+	if err != nil {
+		log.Println("decompress:", err)
+		return bs
+	}
+	ds := *bs
+	ds.Data, ds.Length = data, len(data)
+	return &ds
+}
+
+// getDict returns the dictionary of id, the current one if 0
+func (d *Track) getDict(id uint32) (did uint32, dict []byte, err error) {
+	comp := d.getCompressor()
+	if comp == nil {
+		err = fmt.Errorf("no compressor of track: %s", d.Label)
+		return
+	}
+	comp.Lock()
+	defer comp.Unlock()
+	did = id
+	if did == 0 {
+		did = comp.DictID
+	}
+	dict = comp.dicts[did]
+	if dict == nil {
+		err = fmt.Errorf("not found dictionary: %d", id)
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+func (d *Compressor) compress(data []byte) (frame []byte, err error) {
+	d.Lock()
+	defer d.Unlock()
+
+	frame = make([]byte, COMPRESS_HEAD_LEN, COMPRESS_HEAD_LEN+len(data)/2)
+	copy(frame, COMPRESS_MARK)
+	switch d.Algo {
+	case "zstd":
+		frame[4] = 'z'
+		if d.Dict && d.DictID == 0 {
+			d.trainDict(data)
+		}
+		if d.encoder == nil {
+			d.encoder, err = d.newEncoder()
+			if err != nil {
+				return
+			}
+		}
+		binary.BigEndian.PutUint32(frame[5:], d.DictID)
+		frame = d.encoder.EncodeAll(data, frame)
+	case "brotli":
+		frame[4] = 'b'
+		buf := bytes.NewBuffer(frame)
+		w := brotli.NewWriterLevel(buf, COMPRESS_BROTLI_LEVEL)
+		_, err = w.Write(data)
+		if err == nil {
+			err = w.Close()
+		}
+		frame = buf.Bytes()
+	}
+	return
+}
+
+// collect the slots and make the dictionary of their contents, the latest at the end
+func (d *Compressor) trainDict(data []byte) {
+	d.samples = append(d.samples, append([]byte(nil), data...))
+	if len(d.samples) < COMPRESS_DICT_SLOTS {
+		return
+	}
+	var dict []byte
+	for i := len(d.samples) - 1; i >= 0 && len(dict) < COMPRESS_DICT_MAX; i-- {
+		dict = append(append([]byte(nil), d.samples[i]...), dict...)
+	}
+	if len(dict) > COMPRESS_DICT_MAX {
+		dict = dict[len(dict)-COMPRESS_DICT_MAX:]
+	}
+	d.samples = nil
+	d.DictID = crc32.ChecksumIEEE(dict) | 1 // not 0
+	d.dicts[d.DictID] = dict
+	d.encoder = nil // renewed with the dictionary
+	log.Println("compress dict:", d.DictID, len(dict))
+}
+
+func (d *Compressor) newEncoder() (*zstd.Encoder, error) {
+	if d.DictID != 0 {
+		return zstd.NewWriter(nil, zstd.WithEncoderDictRaw(d.DictID, d.dicts[d.DictID]))
+	}
+	return zstd.NewWriter(nil)
+}
+
+func (d *Compressor) decompress(frame []byte) (data []byte, err error) {
+	if !IsCompressedData(frame) {
+		err = fmt.Errorf("not compressed frame")
+		return
+	}
+	body := frame[COMPRESS_HEAD_LEN:]
+	switch frame[4] {
+	case 'z':
+		var dec *zstd.Decoder
+		dec, err = d.getDecoder(binary.BigEndian.Uint32(frame[5:]))
+		if err != nil {
+			return
+		}
+		data, err = dec.DecodeAll(body, nil)
+	case 'b':
+		data, err = io.ReadAll(io.LimitReader(brotli.NewReader(bytes.NewReader(body)), COMPRESS_MAX_SIZE))
+	default:
+		err = fmt.Errorf("unknown compress: %c", frame[4])
+	}
+	return
+}
+
+func (d *Compressor) getDecoder(id uint32) (dec *zstd.Decoder, err error) {
+	d.Lock()
+	defer d.Unlock()
+	if dec = d.decoders[id]; dec != nil {
+		return
+	}
+	opts := []zstd.DOption{zstd.WithDecoderMaxMemory(COMPRESS_MAX_SIZE), zstd.WithDecoderConcurrency(1)}
+	if id != 0 {
+		dict := d.dicts[id]
+		if dict == nil {
+			err = fmt.Errorf("not found dictionary: %d", id)
+			return
+		}
+		opts = append(opts, zstd.WithDecoderDictRaw(id, dict))
+	}
+	dec, err = zstd.NewReader(nil, opts...)
+	if err != nil {
+		return
+	}
+	d.decoders[id] = dec
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// check if the session accepts the compressed frame
+func (d *Session) isAcceptable(data []byte) bool {
+	algo := GetCompressAlgo(data)
+	for _, a := range strings.Split(d.Accept, ",") {
+		if strings.TrimSpace(a) == algo {
+			return true
+		}
+	}
+	return false
+}
+
+//=================================================================================
@@ -0,0 +1,106 @@
+// =================================================================================
+// Filename: data-compress_test.go
+// Function: Test functions for data-compress.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/fasthttp/websocket"
+)
+
+// ---------------------------------------------------------------------------------
+func TestCompressSlot(t *testing.T) {
+	chn := NewChannelPointer()
+	_, trk, _ := chn.addSourceTrackBySize("base", "data", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+	b := trk.getRingByOrder(BUFFER_NUM_FORE)
+	trk.setRingMime(b, "application/json")
+
+	if err := trk.setCompress("gzip", false); err == nil {
+		t.Fatal("invalid compress is accepted")
+	}
+	if err := trk.setCompress("zstd", true); err != nil {
+		t.Fatal(err)
+	}
+
+	// the dictionary is trained by the first slots, too small to compress without it
+	var last Slot
+	for i := 0; i <= COMPRESS_DICT_SLOTS; i++ {
+		plain := []byte(fmt.Sprintf(`{"sensor":"temperature","unit":"celsius","seq":%d,"value":%d.5}`, i, 20+i%5))
+		last = Slot{FrameType: websocket.BinaryMessage, Data: plain, Length: len(plain)}
+		trk.compressSlot(&last, b)
+		if last.Length != len(last.Data) {
+			t.Fatal("invalid length:", i, last.Length)
+		}
+		if !IsCompressedData(last.Data) {
+			continue
+		}
+		data, err := trk.decompressData(last.Data)
+		if err != nil || !bytes.Equal(data, plain) {
+			t.Fatal("invalid decompression:", i, err)
+		}
+	}
+	if !IsCompressedData(last.Data) {
+		t.Fatal("not compressed by the dictionary:", string(last.Data))
+	}
+	did := binary.BigEndian.Uint32(last.Data[5:])
+	id, dict, err := trk.getDict(0)
+	if err != nil || did == 0 || id != did || len(dict) == 0 {
+		t.Fatal("invalid dictionary:", did, id, err)
+	}
+
+	// decompressed for the subscriber not accepting it
+	s := NewSessionPointerWithName("/pang/ws/sub")
+	s.trk = trk
+	if data := s.getSlotData(&last, false); IsCompressedData(data) {
+		t.Fatal("not decompressed:", string(data))
+	}
+	s.Accept = "brotli,zstd"
+	if data := s.getSlotData(&last, false); !bytes.Equal(data, last.Data) {
+		t.Fatal("not passed through:", string(data))
+	}
+
+	// brotli, and the small or binary slots are kept as they are
+	if err = trk.setCompress("brotli", false); err != nil {
+		t.Fatal(err)
+	}
+	plain := bytes.Repeat([]byte(`{"key":"value"}`), 10)
+	bs := Slot{FrameType: websocket.BinaryMessage, Data: plain, Length: len(plain)}
+	trk.compressSlot(&bs, b)
+	if GetCompressAlgo(bs.Data) != "brotli" {
+		t.Fatal("not compressed by brotli:", string(bs.Data))
+	}
+	if data, err := trk.decompressData(bs.Data); err != nil || !bytes.Equal(data, plain) {
+		t.Fatal("invalid decompression:", err)
+	}
+	if data, err := trk.decompressData(last.Data); err != nil || IsCompressedData(data) {
+		t.Fatal("old dictionary is lost:", err)
+	}
+	if ds := trk.decompressSlot(&bs); !bytes.Equal(ds.Data, plain) || ds.Length != len(plain) || !IsCompressedData(bs.Data) {
+		t.Fatal("invalid decompressed slot:", ds.Length)
+	}
+	text := Slot{FrameType: websocket.TextMessage, Data: plain, Length: len(plain)}
+	trk.compressSlot(&text, b)
+	if !bytes.Equal(text.Data, plain) {
+		t.Fatal("text frame is compressed")
+	}
+	small := Slot{FrameType: websocket.BinaryMessage, Data: []byte(`{"a":1}`), Length: 7}
+	trk.compressSlot(&small, b)
+	if IsCompressedData(small.Data) {
+		t.Fatal("small slot is compressed")
+	}
+	trk.setRingMime(b, "video/h264")
+	bs = Slot{FrameType: websocket.BinaryMessage, Data: plain, Length: len(plain)}
+	trk.compressSlot(&bs, b)
+	if !bytes.Equal(bs.Data, plain) {
+		t.Fatal("binary mime is compressed")
+	}
+}
+
+//=================================================================================
@@ -0,0 +1,489 @@
+// =================================================================================
+// Filename: data-dvr.go
+// Function: Disk-backed time-shift (DVR) ring of a track for late subscribers
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------------
+const (
+	DVR_MARK_SLOT     = "RDVR"           // mark of a slot record in the segment file
+	DVR_SEG_DURATION  = 10 * time.Second // duration of a segment, rotated at a key frame
+	DVR_INDEX_PERIOD  = time.Second      // max period of index entries for non key frames
+	DVR_INDEX_SIZE    = 16               // time(8) + offset(4) + flags(4)
+	DVR_MAX_WINDOW    = 3600             // max window in seconds
+	DVR_FLAG_KEY      = 1                // index flag for a key frame
+	DVR_RECORD_HEADER = 4 + 8 + 8 + 8 + 1 + 1 + 4 + 1 + 1 + 4
+)
+
+// replay of the recorded slots, faster than live to catch up with it
+const (
+	DVR_MAX_WAIT     = 100 * time.Millisecond // max sleep to pace the replay, checking the stop of session
+	DVR_REPLAY_SPEED = 4                      // times of the recorded speed
+)
+
+// segment file (.dat) of slot records and its index file (.idx)
+type dvrSegment struct {
+	Start time.Time `json:"start"`
+	Name  string    `json:"name"` // path without the extension
+}
+
+// index entry to seek the slot record in the segment by time
+type dvrIndex struct {
+	Time   int64  // ingest time in unix nano
+	Offset uint32 // offset of the record in the segment file
+	Flags  uint32 // DVR_FLAG_KEY, ...
+}
+
+// ---------------------------------------------------------------------------------
+// DVR : disk ring of segments for the time window
+//   - record header: "RDVR" + seq(8) + time(8) + capture(8) + frame type(1) + key(1) +
+//     mark(4) + head len(1) + group len(1) + data len(4), in big endian
+//   - record body: head + group + data
+//
+// ---------------------------------------------------------------------------------
+type DVR struct {
+	Dir    string        `json:"dir"`
+	Window time.Duration `json:"window"`
+	Segs   []dvrSegment  `json:"segs"`
+	// --- internal variables
+	file   *os.File
+	index  *os.File
+	offset int64
+	tindex time.Time // time of the last index entry
+	sync.Mutex
+}
+
+// open the dvr in the directory, the previous segments are removed
+func OpenDVR(dir string, window time.Duration) (d *DVR, err error) {
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return
+	}
+	d = &DVR{Dir: dir, Window: window}
+	log.Println("open dvr:", dir, window)
+	return
+}
+
+// close the dvr and remove its segments
+func (d *DVR) Close() (err error) {
+	d.Lock()
+	defer d.Unlock()
+	d.closeSegment()
+	d.Segs = nil
+	log.Println("close dvr:", d.Dir)
+	return os.RemoveAll(d.Dir)
+}
+
+func (d *DVR) setWindow(window time.Duration) {
+	d.Lock()
+	defer d.Unlock()
+	d.Window = window
+}
+
+func (d *DVR) closeSegment() {
+	if d.file != nil {
+		d.file.Close()
+		d.index.Close()
+		d.file, d.index = nil, nil
+	}
+}
+
+// start a new segment, and remove the segments out of the window
+func (d *DVR) rotateSegment(now time.Time) (err error) {
+	d.closeSegment()
+
+	name := filepath.Join(d.Dir, fmt.Sprintf("%019d", now.UnixNano()))
+	d.file, err = os.OpenFile(name+".dat", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	d.index, err = os.OpenFile(name+".idx", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		d.file.Close()
+		d.file = nil
+		return
+	}
+	d.offset = 0
+	d.tindex = time.Time{}
+	d.Segs = append(d.Segs, dvrSegment{Start: now, Name: name})
+
+	// the segment is expired when the next one started before the window
+	for len(d.Segs) > 1 && d.Segs[1].Start.Before(now.Add(-d.Window)) {
+		os.Remove(d.Segs[0].Name + ".dat")
+		os.Remove(d.Segs[0].Name + ".idx")
+		d.Segs = d.Segs[1:]
+	}
+	return
+}
+
+// writeSlot appends the slot to the current segment
+func (d *DVR) writeSlot(bs *Slot) (err error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.file == nil || bs.Time.Sub(d.Segs[len(d.Segs)-1].Start) >= DVR_SEG_DURATION &&
+		(bs.Key || bs.Time.Sub(d.Segs[len(d.Segs)-1].Start) >= 2*DVR_SEG_DURATION) {
+		err = d.rotateSegment(bs.Time)
+		if err != nil {
+			return
+		}
+	}
+
+	if bs.Key || d.offset == 0 || bs.Time.Sub(d.tindex) >= DVR_INDEX_PERIOD {
+		var flags uint32
+		if bs.Key {
+			flags |= DVR_FLAG_KEY
+		}
+		entry := make([]byte, DVR_INDEX_SIZE)
+		binary.BigEndian.PutUint64(entry[0:8], uint64(bs.Time.UnixNano()))
+		binary.BigEndian.PutUint32(entry[8:12], uint32(d.offset))
+		binary.BigEndian.PutUint32(entry[12:16], flags)
+		_, err = d.index.Write(entry)
+		if err != nil {
+			return
+		}
+		d.tindex = bs.Time
+	}
+
+	data := EncodeDVRSlot(bs)
+	_, err = d.file.Write(data) // a record in a write for the concurrent readers
+	if err != nil {
+		return
+	}
+	d.offset += int64(len(data))
+	return
+}
+
+// ---------------------------------------------------------------------------------
+func EncodeDVRSlot(bs *Slot) (data []byte) {
+	head, _ := bs.Head.(string)
+	head = head[:min(len(head), 255)]
+	group := bs.Group[:min(len(bs.Group), 255)]
+	var ctime int64
+	if !bs.Capture.IsZero() {
+		ctime = bs.Capture.UnixNano()
+	}
+
+	data = make([]byte, DVR_RECORD_HEADER, DVR_RECORD_HEADER+len(head)+len(group)+len(bs.Data))
+	copy(data[0:4], DVR_MARK_SLOT)
+	binary.BigEndian.PutUint64(data[4:12], bs.Seq)
+	binary.BigEndian.PutUint64(data[12:20], uint64(bs.Time.UnixNano()))
+	binary.BigEndian.PutUint64(data[20:28], uint64(ctime))
+	data[28] = byte(bs.FrameType)
+	if bs.Key {
+		data[29] = 1
+	}
+	copy(data[30:34], bs.Mark)
+	data[34] = byte(len(head))
+	data[35] = byte(len(group))
+	binary.BigEndian.PutUint32(data[36:40], uint32(len(bs.Data)))
+	data = append(data, head...)
+	data = append(data, group...)
+	return append(data, bs.Data...)
+}
+
+// read a slot record, io.EOF or io.ErrUnexpectedEOF at the end of segment
+func ReadDVRSlot(r io.Reader) (bs *Slot, err error) {
+	head := make([]byte, DVR_RECORD_HEADER)
+	_, err = io.ReadFull(r, head)
+	if err != nil {
+		return
+	}
+	if string(head[0:4]) != DVR_MARK_SLOT {
+		err = fmt.Errorf("invalid dvr record: %q", head[0:4])
+		return
+	}
+	nhead, ngroup := int(head[34]), int(head[35])
+	body := make([]byte, nhead+ngroup+int(binary.BigEndian.Uint32(head[36:40])))
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return
+	}
+
+	bs = &Slot{
+		Seq:       binary.BigEndian.Uint64(head[4:12]),
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(head[12:20]))),
+		FrameType: int(head[28]),
+		Key:       head[29] == 1,
+		Mark:      string(head[30:34]),
+		Head:      string(body[:nhead]),
+		Group:     string(body[nhead : nhead+ngroup]),
+		Data:      body[nhead+ngroup:],
+	}
+	if ctime := int64(binary.BigEndian.Uint64(head[20:28])); ctime != 0 {
+		bs.Capture = time.Unix(0, ctime)
+	}
+	bs.Length = len(bs.Data)
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// seekSlots finds the segment and offset to start reading at the time t
+//   - binary search of segments by the start time, and of the index entries in the segment
+//   - the offset is of the last key frame at or before t, if the segment has key frames
+//
+// ---------------------------------------------------------------------------------
+func (d *DVR) seekSlots(t time.Time) (segs []dvrSegment, offset int64, err error) {
+	d.Lock()
+	segs = append(segs, d.Segs...)
+	d.Unlock()
+	if len(segs) == 0 {
+		err = fmt.Errorf("no dvr segment")
+		return
+	}
+
+	n := sort.Search(len(segs), func(i int) bool { return segs[i].Start.After(t) })
+	if n > 0 {
+		n-- // the last segment started at or before t
+	}
+	segs = segs[n:]
+
+	data, err := os.ReadFile(segs[0].Name + ".idx")
+	if err != nil {
+		return
+	}
+	entries := make([]dvrIndex, len(data)/DVR_INDEX_SIZE)
+	for i := range entries {
+		e := data[i*DVR_INDEX_SIZE:]
+		entries[i] = dvrIndex{
+			Time:   int64(binary.BigEndian.Uint64(e[0:8])),
+			Offset: binary.BigEndian.Uint32(e[8:12]),
+			Flags:  binary.BigEndian.Uint32(e[12:16]),
+		}
+	}
+	k := sort.Search(len(entries), func(i int) bool { return entries[i].Time > t.UnixNano() }) - 1
+	if k < 0 {
+		return // before the first entry, from the start of segment
+	}
+	// go back to the key frame if the segment has any
+	for i := k; i >= 0; i-- {
+		if entries[i].Flags&DVR_FLAG_KEY != 0 {
+			k = i
+			break
+		}
+	}
+	offset = int64(entries[k].Offset)
+	return
+}
+
+// get the segments started after the time, added while reading
+func (d *DVR) getSegsAfter(t time.Time) (segs []dvrSegment) {
+	d.Lock()
+	defer d.Unlock()
+	for _, seg := range d.Segs {
+		if seg.Start.After(t) {
+			segs = append(segs, seg)
+		}
+	}
+	return
+}
+
+// readSlots reads the slots from the time t to the end of recorded ones,
+// following the segments added while reading
+//   - returns the sequence number of the last slot read, to continue the live slots
+func (d *DVR) readSlots(t time.Time, fn func(bs *Slot) error) (lseq uint64, err error) {
+	segs, offset, err := d.seekSlots(t)
+	if err != nil {
+		return
+	}
+
+	for len(segs) > 0 {
+		seg := segs[0]
+		err = readSegment(seg, offset, fn, &lseq)
+		if err != nil {
+			return
+		}
+		offset = 0
+		if segs = segs[1:]; len(segs) == 0 {
+			segs = d.getSegsAfter(seg.Start)
+		}
+	}
+	return
+}
+
+// read the slots of segment from the offset, skipped if removed by the writer
+func readSegment(seg dvrSegment, offset int64, fn func(bs *Slot) error, lseq *uint64) (err error) {
+	f, err := os.Open(seg.Name + ".dat")
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer f.Close()
+	if offset > 0 {
+		f.Seek(offset, io.SeekStart)
+	}
+	for {
+		var bs *Slot
+		bs, err = ReadDVRSlot(f)
+		if err == io.EOF || err == io.ErrUnexpectedEOF { // end of the segment
+			return nil
+		}
+		if err != nil {
+			return
+		}
+		err = fn(bs)
+		if err != nil {
+			return
+		}
+		*lseq = bs.Seq
+	}
+}
+
+// ---------------------------------------------------------------------------------
+// ParseFromTime gets the time to start reading of the dvr
+//   - relative: -30s, -5m, absolute: RFC3339 or unix time in seconds
+//
+// ---------------------------------------------------------------------------------
+func ParseFromTime(str string, now time.Time) (t time.Time, err error) {
+	if strings.HasPrefix(str, "-") {
+		var dur time.Duration
+		dur, err = time.ParseDuration(str)
+		if err != nil {
+			return
+		}
+		return now.Add(dur), nil
+	}
+	if n, e := strconv.ParseInt(str, 10, 64); e == nil {
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, str)
+}
+
+// ---------------------------------------------------------------------------------
+// set the dvr window of channel in seconds, 0 to close the dvr of all tracks
+func (d *Channel) setDVRWindow(secs int) (err error) {
+	if secs < 0 || secs > DVR_MAX_WINDOW {
+		err = fmt.Errorf("invalid dvr window: %d (0-%d)", secs, DVR_MAX_WINDOW)
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.DVRWindow = secs
+	for _, src := range d.Sources {
+		for _, t := range src.Tracks {
+			if secs == 0 {
+				t.closeDVR()
+			} else if dvr := t.getDVR(); dvr != nil {
+				dvr.setWindow(time.Duration(secs) * time.Second)
+			}
+		}
+	}
+	log.Println("dvr window:", d.ID, secs)
+	return
+}
+
+func (d *Channel) getDVRWindow() int {
+	d.Lock()
+	defer d.Unlock()
+	return d.DVRWindow
+}
+
+func (d *Track) getDVR() *DVR {
+	d.RLock()
+	defer d.RUnlock()
+	return d.dvr
+}
+
+func (d *Track) closeDVR() {
+	d.Lock()
+	dvr := d.dvr
+	d.dvr = nil
+	d.Unlock()
+	if dvr != nil {
+		dvr.Close()
+	}
+}
+
+// recordDVRSlot writes the slot of the forward ring to the dvr of track if the channel enables it
+//   - the directed slot is not recorded
+func (d *Session) recordDVRSlot(b *Buffer, bs *Slot) {
+	window := d.chn.getDVRWindow()
+	if window == 0 || bs.To != "" || b != d.trk.getRingByOrder(BUFFER_NUM_FORE) {
+		return
+	}
+
+	d.trk.Lock()
+	if d.trk.dvr == nil { // by the ids, not the labels given by clients
+		dir := filepath.Join(mConfig.DirRecord, "dvr", d.chn.ID, d.trk.ID)
+		dvr, err := OpenDVR(dir, time.Duration(window)*time.Second)
+		if err != nil {
+			d.trk.Unlock()
+			log.Println(err)
+			return
+		}
+		d.trk.dvr = dvr
+	}
+	dvr := d.trk.dvr
+	d.trk.Unlock()
+
+	err := dvr.writeSlot(bs)
+	if err != nil {
+		log.Println("dvr:", err)
+	}
+}
+
+// burstStartSlots sends the slots recorded from the time given by from= of subscriber,
+// or the cached gop if not given, before the live slots
+//   - the recorded slots are paced at DVR_REPLAY_SPEED times of their time, to catch up
+//     with the live ones and continue from the live ring
+func (d *Buffer) burstStartSlots(s *Session, send func(bs *Slot) error) (lseq uint64, err error) {
+	dvr := s.trk.getDVR()
+	if s.From.IsZero() || dvr == nil || d != s.trk.getRingByOrder(BUFFER_NUM_FORE) {
+		return d.burstGopSlots(s, send)
+	}
+	log.Println("dvr replay:", s.ID, s.From)
+
+	var tfirst, tstart time.Time
+	lseq, err = dvr.readSlots(s.From, func(bs *Slot) error {
+		if !s.isDeliverable(bs) {
+			return nil
+		}
+		if tfirst.IsZero() {
+			tfirst, tstart = bs.Time, time.Now()
+		}
+		due := tstart.Add(bs.Time.Sub(tfirst) / DVR_REPLAY_SPEED)
+		for wait := time.Until(due); wait > 0; wait = time.Until(due) {
+			if !s.isState(Using) {
+				return fmt.Errorf("dvr replay stopped: %s", s.ID)
+			}
+			time.Sleep(min(wait, DVR_MAX_WAIT))
+		}
+		err := send(bs)
+		if err == nil {
+			s.countOutSlot(bs.Length)
+		}
+		return err
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if lseq == 0 { // nothing recorded from the time
+		lseq = d.getWriteSeq()
+	}
+	return
+}
+
+//=================================================================================
@@ -0,0 +1,119 @@
+// =================================================================================
+// Filename: data-dvr_test.go
+// Function: Test functions for data-dvr.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------------------------------------
+func TestDVRSeekAndRead(t *testing.T) {
+	dvr, err := OpenDVR(filepath.Join(t.TempDir(), "dvr"), 15*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dvr.Close()
+
+	// 10 slots per second for 40 seconds, a key frame every 2 seconds
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 400; i++ {
+		bs := newSeqSlot(uint64(i + 1))
+		bs.Seq = uint64(i + 1)
+		bs.Time = start.Add(time.Duration(i) * 100 * time.Millisecond)
+		bs.Key = (i%20 == 0)
+		if err = dvr.writeSlot(&bs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(dvr.Segs) > 3 {
+		t.Fatal("expired segments are not removed:", len(dvr.Segs))
+	}
+
+	// from 35.5s, start at the key frame of 34s (seq 341) and read to the end
+	var first, count uint64
+	lseq, err := dvr.readSlots(start.Add(35500*time.Millisecond), func(bs *Slot) error {
+		if first == 0 {
+			first = bs.Seq
+			if !bs.Key {
+				t.Error("not started at the key frame:", bs.Seq)
+			}
+		}
+		count++
+		return nil
+	})
+	if err != nil || first != 341 || lseq != 400 || count != 60 {
+		t.Fatal("invalid dvr read:", first, lseq, count, err)
+	}
+
+	// before the window, from the oldest one
+	first = 0
+	_, err = dvr.readSlots(start, func(bs *Slot) error {
+		if first == 0 {
+			first = bs.Seq
+		}
+		return nil
+	})
+	if err != nil || first != uint64(dvr.Segs[0].Start.Sub(start).Milliseconds()/100+1) {
+		t.Fatal("invalid oldest read:", first, err)
+	}
+}
+
+func TestDVRReplayPaced(t *testing.T) {
+	dvr, err := OpenDVR(filepath.Join(t.TempDir(), "dvr"), 15*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dvr.Close()
+	b := NewBuffer("test", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS)
+	trk := &Track{Rings: []*Buffer{b}, dvr: dvr}
+
+	// 5 slots of 100ms apart are replayed in 100ms, not at once nor in 400ms of live
+	start := time.Now().Add(-time.Minute)
+	for i := 0; i < 5; i++ {
+		bs := newSeqSlot(uint64(i + 1))
+		bs.Seq, bs.Key = uint64(i+1), (i == 0)
+		bs.Time = start.Add(time.Duration(i) * 100 * time.Millisecond)
+		dvr.writeSlot(&bs)
+	}
+	s := NewSessionPointerWithName("/pang/ws/sub")
+	s.trk, s.From, s.Filter, s.State = trk, start, "all", Using
+
+	at := time.Now()
+	n := 0
+	lseq, err := b.burstStartSlots(s, func(bs *Slot) error { n++; return nil })
+	if elapsed := time.Since(at); err != nil || n != 5 || lseq != 5 || elapsed < 80*time.Millisecond || elapsed > 350*time.Millisecond {
+		t.Fatal("invalid paced replay:", n, lseq, elapsed, err)
+	}
+
+	// stopped by the session while waiting
+	s.State = Idle
+	n = 0
+	if _, err = b.burstStartSlots(s, func(bs *Slot) error { n++; return nil }); err == nil || n != 1 {
+		t.Fatal("replay is not stopped:", n, err)
+	}
+}
+
+func TestParseFromTime(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	for str, want := range map[string]time.Time{
+		"-30s":                 now.Add(-30 * time.Second),
+		"-2m":                  now.Add(-2 * time.Minute),
+		"1699999990":           time.Unix(1699999990, 0),
+		"2023-11-14T22:13:20Z": now,
+	} {
+		if got, err := ParseFromTime(str, now); err != nil || !got.Equal(want) {
+			t.Error("invalid from time:", str, got, err)
+		}
+	}
+	if _, err := ParseFromTime("yesterday", now); err == nil {
+		t.Error("invalid from time is parsed")
+	}
+}
+
+//=================================================================================
@@ -10,6 +10,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
 	"time"
 
 	"github.com/fasthttp/websocket"
@@ -94,9 +95,20 @@ func (s *Session) procControlMessage(ws *websocket.Conn, rm *WSMessage) (err err
 		sm.Data = string(data)
 	case "set_buffer":
 		sm.Type = "buffer"
-		// TBD: set_buffer
-		// buf = trk[qo.Buffer.Order]
-		// buf.Len = qo.Buffer.Len
+		_, trk, _ := s.chn.findSourceTrackByLabel(qo.Source.Label, qo.Track.Label)
+		if trk == nil {
+			err = fmt.Errorf("not found source/track: %s/%s", qo.Source.Label, qo.Track.Label)
+			return
+		}
+		buf, err := trk.setBufferByQuery(qo)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(buf)
+		if err != nil {
+			return err
+		}
+		sm.Data = string(data)
 	case "set_channel":
 		sm.Type = "channel"
 		if qo.Channel.Key != "" {
@@ -107,19 +119,51 @@ func (s *Session) procControlMessage(ws *websocket.Conn, rm *WSMessage) (err err
 			}
 		}
 		if qo.Channel.Record != "" {
-			if qo.Channel.Record == "off" {
-				s.chn.RecordAuto = false
+			switch qo.Channel.Record {
+			case "off":
+				s.chn.setRecordAuto(false, "")
+			case "on":
+				s.chn.setRecordAuto(true, "")
+			case "rssp", "mp4":
+				s.chn.setRecordAuto(true, qo.Channel.Record)
 			}
-			if qo.Channel.Record == "on" {
-				s.chn.RecordAuto = true
+		}
+		if qo.Channel.Shoot != "" {
+			switch qo.Channel.Shoot {
+			case "off":
+				err = s.chn.setShootAuto(false, 0, "")
+			case "on":
+				err = s.chn.setShootAuto(true, 0, "")
+			default:
+				var secs int
+				secs, err = strconv.Atoi(qo.Channel.Shoot)
+				if err == nil {
+					err = s.chn.setShootAuto(true, secs, "")
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+		if qo.Channel.DVR != "" {
+			secs := 0
+			if qo.Channel.DVR != "off" {
+				secs, err = strconv.Atoi(qo.Channel.DVR)
+				if err != nil {
+					return
+				}
+			}
+			err = s.chn.setDVRWindow(secs)
+			if err != nil {
+				return
 			}
 		}
 		if qo.Channel.Trans != "" {
 			if qo.Channel.Trans == "off" {
-				s.chn.TransAuto = false
+				s.chn.setTransAuto(false, "")
 			}
 			if qo.Channel.Trans == "on" {
-				s.chn.TransAuto = true
+				s.chn.setTransAuto(true, "")
 			}
 		}
 		data, err := json.Marshal(s.chn)
@@ -127,6 +171,51 @@ func (s *Session) procControlMessage(ws *websocket.Conn, rm *WSMessage) (err err
 			return err
 		}
 		sm.Data = string(data)
+	case "get_key": // key exchange of secure track
+		sm.Type = "key"
+		kr := struct {
+			Pub   string `json:"pub"`              // public key of client (P-256), base64
+			KeyID string `json:"key_id,omitempty"` // key id of the frames, the current key if empty
+		}{}
+		err = json.Unmarshal([]byte(rm.Data), &kr)
+		if err != nil {
+			return
+		}
+		var sk SecureKey
+		sk, err = s.getSecureKey(qo.Source.Label, qo.Track.Label, qo.Channel.Key, kr.Pub, kr.KeyID)
+		if err != nil {
+			return
+		}
+		data, err := json.Marshal(sk)
+		if err != nil {
+			return err
+		}
+		sm.Data = string(data)
+	case "get_dict": // dictionary of compressed track
+		sm.Type = "dict"
+		dr := struct {
+			ID uint32 `json:"id"` // dictionary id in the frame, 0 for the current
+		}{}
+		err = json.Unmarshal([]byte(rm.Data), &dr)
+		if err != nil {
+			return
+		}
+		_, trk, _ := s.chn.findSourceTrackByLabel(qo.Source.Label, qo.Track.Label)
+		if trk == nil {
+			err = fmt.Errorf("not found source/track: %s/%s", qo.Source.Label, qo.Track.Label)
+			return
+		}
+		var did uint32
+		var dict []byte
+		did, dict, err = trk.getDict(dr.ID)
+		if err != nil {
+			return
+		}
+		data, err := json.Marshal(map[string]interface{}{"id": did, "dict": dict}) // dict in base64
+		if err != nil {
+			return err
+		}
+		sm.Data = string(data)
 	case "close_channel":
 		sm.Type = "channel"
 		if !IsXidString(qo.Channel.ID) {
@@ -0,0 +1,212 @@
+// =================================================================================
+// Filename: data-metric.go
+// Function: Sliding window rate metrics for sessions, tracks and channels
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ---------------------------------------------------------------------------------
+const (
+	METRIC_SAMPLE_INTERVAL = time.Second // interval to sample the counters
+	METRIC_WINDOW_SAMPLES  = 5           // number of samples in the sliding window, 5sec
+)
+
+// counters at the sampling time
+type metricSample struct {
+	at        time.Time
+	inBytes   int64
+	outBytes  int64
+	inFrames  int64
+	outFrames int64
+}
+
+// ---------------------------------------------------------------------------------
+// Metric : counters updated atomically by routines and rates computed by the sampler
+//   - the rates are read by getRates() under the lock, also in the json of owners
+//
+// ---------------------------------------------------------------------------------
+type Metric struct {
+	MetricRates
+	// --- internal variables
+	inBytes   atomic.Int64
+	outBytes  atomic.Int64
+	inFrames  atomic.Int64
+	outFrames atomic.Int64
+	drops     atomic.Int64
+	samples   []metricSample
+	mu        sync.Mutex
+}
+
+// MetricRates is the snapshot of metric computed by the sampler
+type MetricRates struct {
+	BPS        float64 `json:"bps,omitempty"`          // incoming bits per second in the window
+	FPS        float64 `json:"fps,omitempty"`          // incoming frames (slots) per second in the window
+	OutBPS     float64 `json:"out_bps,omitempty"`      // outgoing bits per second in the window
+	OutFPS     float64 `json:"out_fps,omitempty"`      // outgoing frames (slots) per second in the window
+	PeakBPS    float64 `json:"peak_bps,omitempty"`     // max of incoming bps
+	PeakFPS    float64 `json:"peak_fps,omitempty"`     // max of incoming fps
+	PeakOutBPS float64 `json:"peak_out_bps,omitempty"` // max of outgoing bps
+	InBytes    int64   `json:"in_bytes,omitempty"`     // total incoming bytes at the last sample
+	OutBytes   int64   `json:"out_bytes,omitempty"`    // total outgoing bytes at the last sample
+	Drops      int     `json:"drops,omitempty"`        // number of dropped slots
+	Interval   int     `json:"interval,omitempty"`     // window in Seconds
+}
+
+func (d *Metric) String() (str string) {
+	in, out := d.getBytes()
+	m := d.getRates()
+	return fmt.Sprintf("since: %v, total: (%d,%d), drops: %d, bps:%.2f/%.2f, fps:%.2f/%.2f, peak:%.2f/%.2f",
+		m.Interval, in, out, m.Drops, m.BPS, m.OutBPS, m.FPS, m.OutFPS, m.PeakBPS, m.PeakOutBPS)
+}
+
+// getRates returns the snapshot of rates with the current drops, safe with the sampler
+func (d *Metric) getRates() (m MetricRates) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	m = d.MetricRates
+	m.Drops = int(d.drops.Load())
+	return
+}
+
+// addDrops counts the dropped slots and returns the total
+func (d *Metric) addDrops(n int) int {
+	return int(d.drops.Add(int64(n)))
+}
+
+func (d *Metric) addIn(n int) {
+	d.inBytes.Add(int64(n))
+	d.inFrames.Add(1)
+}
+
+func (d *Metric) addOut(n int) {
+	d.outBytes.Add(int64(n))
+	d.outFrames.Add(1)
+}
+
+// get the current total bytes, not waiting for the sampler
+func (d *Metric) getBytes() (in, out int64) {
+	return d.inBytes.Load(), d.outBytes.Load()
+}
+
+// sampleMetric takes the counters and computes the rates over the sliding window
+func (d *Metric) sampleMetric(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ms := metricSample{
+		at:        now,
+		inBytes:   d.inBytes.Load(),
+		outBytes:  d.outBytes.Load(),
+		inFrames:  d.inFrames.Load(),
+		outFrames: d.outFrames.Load(),
+	}
+	d.samples = append(d.samples, ms)
+	if len(d.samples) > METRIC_WINDOW_SAMPLES+1 {
+		d.samples = d.samples[len(d.samples)-METRIC_WINDOW_SAMPLES-1:]
+	}
+	d.InBytes, d.OutBytes = ms.inBytes, ms.outBytes
+
+	first := d.samples[0]
+	secs := now.Sub(first.at).Seconds()
+	if secs <= 0 {
+		return
+	}
+	d.BPS = float64(ms.inBytes-first.inBytes) * 8 / secs
+	d.FPS = float64(ms.inFrames-first.inFrames) / secs
+	d.OutBPS = float64(ms.outBytes-first.outBytes) * 8 / secs
+	d.OutFPS = float64(ms.outFrames-first.outFrames) / secs
+	d.PeakBPS = max(d.PeakBPS, d.BPS)
+	d.PeakFPS = max(d.PeakFPS, d.FPS)
+	d.PeakOutBPS = max(d.PeakOutBPS, d.OutBPS)
+	d.Interval = int(secs + 0.5)
+}
+
+func (d *Metric) resetMetric() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inBytes.Store(0)
+	d.outBytes.Store(0)
+	d.inFrames.Store(0)
+	d.outFrames.Store(0)
+	d.drops.Store(0)
+	d.samples = nil
+	d.MetricRates = MetricRates{}
+}
+
+// ---------------------------------------------------------------------------------
+// count the incoming slot to the session, its track and channel
+func (d *Session) countInSlot(n int) {
+	d.addIn(n)
+	if d.trk != nil {
+		d.trk.addIn(n)
+	}
+	if d.chn != nil {
+		d.chn.addIn(n)
+	}
+}
+
+// count the outgoing slot from the session, its track and channel
+func (d *Session) countOutSlot(n int) {
+	d.addOut(n)
+	if d.trk != nil {
+		d.trk.addOut(n)
+	}
+	if d.chn != nil {
+		d.chn.addOut(n)
+	}
+}
+
+// ---------------------------------------------------------------------------------
+// sample the metrics of all sessions, channels and their tracks
+func (d *Studio) sampleMetrics(now time.Time) {
+	d.SessionGate.RLock()
+	for _, s := range d.Sessions {
+		s.sampleMetric(now)
+	}
+	d.SessionGate.RUnlock()
+
+	d.ChannelGate.RLock()
+	defer d.ChannelGate.RUnlock()
+	for _, c := range d.Channels {
+		c.sampleMetric(now)
+		c.Lock()
+		for _, src := range c.Sources {
+			src.RLock()
+			for _, t := range src.Tracks {
+				t.sampleMetric(now)
+			}
+			src.RUnlock()
+		}
+		c.Unlock()
+	}
+}
+
+// ---------------------------------------------------------------------------------
+// RunMetricSampler samples the metrics periodically by its own ticker
+// ---------------------------------------------------------------------------------
+func RunMetricSampler(pst *Studio) {
+	log.Println("i.RunMetricSampler:", METRIC_SAMPLE_INTERVAL, METRIC_WINDOW_SAMPLES)
+	defer log.Println("o.RunMetricSampler")
+
+	w := pst.addNewWorkerWithParams("/checker/moth/metric", pst.ID, "system")
+	defer pst.deleteWorker(w)
+
+	ticker := time.NewTicker(METRIC_SAMPLE_INTERVAL)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		pst.sampleMetrics(now)
+		w.AtUsed = now
+	}
+}
+
+//=================================================================================
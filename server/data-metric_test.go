@@ -0,0 +1,104 @@
+// =================================================================================
+// Filename: data-metric_test.go
+// Function: Test functions for data-metric.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------------------------------------
+func TestMetricSlidingWindow(t *testing.T) {
+	var m Metric
+	now := time.Now()
+	m.sampleMetric(now)
+
+	// 30 frames of 1000 bytes per second for 2 seconds
+	for sec := 1; sec <= 2; sec++ {
+		for i := 0; i < 30; i++ {
+			m.addIn(1000)
+		}
+		m.sampleMetric(now.Add(time.Duration(sec) * time.Second))
+	}
+	if m.BPS != 240000 || m.FPS != 30 || m.InBytes != 60000 || m.Interval != 2 {
+		t.Fatal("invalid rate:", m.String())
+	}
+
+	// idle for the whole window, the rate goes down but the peak remains
+	for sec := 3; sec <= 3+METRIC_WINDOW_SAMPLES; sec++ {
+		m.sampleMetric(now.Add(time.Duration(sec) * time.Second))
+	}
+	if m.BPS != 0 || m.FPS != 0 || m.PeakBPS != 240000 || m.Interval != METRIC_WINDOW_SAMPLES {
+		t.Fatal("invalid idle rate:", m.String())
+	}
+}
+
+func TestMetricConcurrentCount(t *testing.T) {
+	s := NewSessionPointerWithName("/pang/ws/meb")
+	s.trk = NewTrackDualBuffers("test", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS)
+	s.chn = NewChannelPointer()
+
+	var wg sync.WaitGroup
+	for k := 0; k < 4; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				s.countInSlot(10)
+				s.countOutSlot(20)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, m := range []*Metric{&s.Metric, &s.trk.Metric, &s.chn.Metric} {
+		if in, out := m.getBytes(); in != 40000 || out != 80000 {
+			t.Fatal("invalid count:", in, out)
+		}
+	}
+}
+
+func TestMetricConcurrentRead(t *testing.T) {
+	s := NewSessionPointerWithName("/pang/ws/sub")
+	s.trk = NewTrackDualBuffers("test", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS)
+	s.chn = NewChannelPointer()
+
+	// the sampler and drops race with the readers of manager, checked by -race
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		now := time.Now()
+		for i := 0; i < 100; i++ {
+			s.countInSlot(100)
+			s.reportLag(1, 0)
+			now = now.Add(time.Second)
+			s.sampleMetric(now)
+			s.trk.sampleMetric(now)
+			s.chn.sampleMetric(now)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		json.Marshal(s)
+		json.Marshal(s.trk)
+		json.Marshal(s.chn)
+		_ = s.String() + s.chn.ListResources("", "")
+	}
+	wg.Wait()
+
+	var v struct {
+		Metric MetricRates `json:"metric"`
+	}
+	data, _ := json.Marshal(s)
+	if err := json.Unmarshal(data, &v); err != nil || v.Metric.Drops != 100 || v.Metric.BPS == 0 {
+		t.Fatal("invalid metric in json:", v.Metric, err)
+	}
+}
+
+//=================================================================================
@@ -0,0 +1,281 @@
+// =================================================================================
+// Filename: data-secure.go
+// Function: Secure track mode encrypting slots by AES-256-GCM
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fasthttp/websocket"
+)
+
+// ---------------------------------------------------------------------------------
+// Secure frame: "RSEC" + flags(1) + length(1) + key id + nonce(12) + ciphertext + tag(16)
+//   - the header from "RSEC" to key id is authenticated as the additional data
+//   - flags: bit 0 for the key frame, to be cached without decryption
+//
+// In mode=secure, the server encrypts the binary slots of publisher by the key of
+// track derived from the channel secret, and passes through the frames already
+// encrypted by the client. Subscribers get the key by the "get_key" control message.
+//
+// The channel secret is derived from the master key and the channel id, so the keys
+// are the same after a restart to decrypt the recordings and DVR of secure tracks.
+// The master keys are in the file of secure_file (DirData/secure.key in default),
+// a hex string per line, made at the first use if not exist.
+//   - rotation: add a new key at the first line, it is used for the new frames,
+//     and keep the old ones below to get their keys by the key id of frames
+//
+// ---------------------------------------------------------------------------------
+const (
+	SECURE_MARK       = "RSEC"
+	SECURE_FLAG_KEY   = 0x01
+	SECURE_SECRET_LEN = 32
+	SECURE_ID_LEN     = 16 // hex chars of key id derived by the server
+	SECURE_FILE_NAME  = "secure.key"
+)
+
+// master keys loaded from the file, the first is the current one
+var secureMasters struct {
+	fname string
+	keys  [][]byte
+	sync.Mutex
+}
+
+func IsSecureData(data []byte) bool {
+	return len(data) > 6 && string(data[:4]) == SECURE_MARK
+}
+
+// EncodeSecureData encrypts the data into the secure frame
+func EncodeSecureData(keyid string, key []byte, fkey bool, data []byte) (frame []byte, err error) {
+	if len(keyid) == 0 || len(keyid) > 255 {
+		err = fmt.Errorf("invalid key id length: %d", len(keyid))
+		return
+	}
+	var flags byte
+	if fkey {
+		flags |= SECURE_FLAG_KEY
+	}
+	head := append([]byte(SECURE_MARK), flags, byte(len(keyid)))
+	head = append(head, keyid...)
+	ciphertext, err := AES256GSMEncrypt(key, head, data)
+	if err != nil {
+		return
+	}
+	frame = append(head, ciphertext...)
+	return
+}
+
+// DecodeSecureHeader parses the header of secure frame
+func DecodeSecureHeader(frame []byte) (keyid string, fkey bool, hlen int, err error) {
+	if !IsSecureData(frame) {
+		err = fmt.Errorf("not secure frame")
+		return
+	}
+	hlen = 6 + int(frame[5])
+	if len(frame) < hlen+12+16 {
+		err = fmt.Errorf("invalid secure frame length: %d", len(frame))
+		return
+	}
+	keyid = string(frame[6:hlen])
+	fkey = frame[4]&SECURE_FLAG_KEY != 0
+	return
+}
+
+// DecodeSecureData decrypts the secure frame by the key
+func DecodeSecureData(key, frame []byte) (keyid string, data []byte, err error) {
+	keyid, _, hlen, err := DecodeSecureHeader(frame)
+	if err != nil {
+		return
+	}
+	data, err = AES256GSMDecrypt(key, frame[hlen:], frame[:hlen])
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// GetSecureMasters returns the master keys in the file, made with a new key if not exist
+func GetSecureMasters() (keys [][]byte, err error) {
+	fname := mConfig.SecureFile
+	if fname == "" {
+		fname = filepath.Join(mConfig.DirData, SECURE_FILE_NAME)
+	}
+	secureMasters.Lock()
+	defer secureMasters.Unlock()
+	if secureMasters.fname == fname && len(secureMasters.keys) > 0 {
+		return secureMasters.keys, nil
+	}
+
+	data, err := os.ReadFile(fname)
+	if os.IsNotExist(err) {
+		key := make([]byte, SECURE_SECRET_LEN)
+		if _, err = io.ReadFull(rand.Reader, key); err != nil {
+			return
+		}
+		data = []byte(hex.EncodeToString(key) + "\n")
+		os.MkdirAll(filepath.Dir(fname), os.ModePerm)
+		if err = os.WriteFile(fname, data, 0600); err != nil {
+			return
+		}
+		log.Println("secure master key is made:", fname)
+	}
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := hex.DecodeString(line)
+		if err != nil || len(key) < SECURE_SECRET_LEN {
+			return nil, fmt.Errorf("invalid master key in %s: not hex of %d bytes", fname, SECURE_SECRET_LEN)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no master key in %s", fname)
+	}
+	secureMasters.fname, secureMasters.keys = fname, keys
+	return
+}
+
+// derive the key of source/track from the secret of channel by the master key
+func deriveTrackKey(master []byte, chid, source, track string) (keyid string, key []byte) {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte("channel/" + chid))
+	secret := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, secret)
+	mac.Write([]byte(chid + "/" + source + "/" + track))
+	key = mac.Sum(nil)
+	sum := sha256.Sum256(key)
+	keyid = hex.EncodeToString(sum[:])[:SECURE_ID_LEN]
+	return
+}
+
+// getTrackKey derives the key of source/track by the current master key
+func (d *Channel) getTrackKey(source, track string) (keyid string, key []byte, err error) {
+	masters, err := GetSecureMasters()
+	if err != nil {
+		return
+	}
+	keyid, key = deriveTrackKey(masters[0], d.ID, source, track)
+	return
+}
+
+// getTrackKeyByID finds the key of source/track by the key id, made by the current or old master keys
+func (d *Channel) getTrackKeyByID(source, track, keyid string) (key []byte, err error) {
+	masters, err := GetSecureMasters()
+	if err != nil {
+		return
+	}
+	for _, master := range masters {
+		if id, k := deriveTrackKey(master, d.ID, source, track); id == keyid {
+			return k, nil
+		}
+	}
+	err = fmt.Errorf("key not found: %s", keyid)
+	return
+}
+
+// secureSlot encrypts the binary slot in the secure mode of track,
+// the frame encrypted by the client keeps its key id and key flag
+func (s *Session) secureSlot(bs *Slot) (err error) {
+	if s.trk.Mode != "secure" || bs.FrameType != websocket.BinaryMessage {
+		return
+	}
+	if IsSecureData(bs.Data) {
+		_, bs.Key, _, err = DecodeSecureHeader(bs.Data)
+		return
+	}
+	keyid, key, err := s.chn.getTrackKey(s.SourceID, s.TrackID)
+	if err != nil {
+		return
+	}
+	bs.Data, err = EncodeSecureData(keyid, key, bs.Key, bs.Data)
+	bs.Length = len(bs.Data)
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// SecureKey is the track key wrapped for the subscriber by ECDH (P-256)
+type SecureKey struct {
+	Source string `json:"source"`
+	Track  string `json:"track"`
+	KeyID  string `json:"key_id"`
+	Pub    string `json:"pub"` // ephemeral public key of server, base64
+	Key    string `json:"key"` // AES-256-GCM(sha256(shared secret), key id) of track key, base64
+}
+
+// WrapSecureKey wraps the key by the shared secret with the public key of client
+func WrapSecureKey(cpub string, keyid string, key []byte) (sk SecureKey, err error) {
+	data, err := base64.StdEncoding.DecodeString(cpub)
+	if err != nil {
+		return
+	}
+	pub, err := ecdh.P256().NewPublicKey(data)
+	if err != nil {
+		return
+	}
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return
+	}
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return
+	}
+	kek := sha256.Sum256(shared)
+	wrapped, err := AES256GSMEncrypt(kek[:], []byte(keyid), key)
+	if err != nil {
+		return
+	}
+	sk.KeyID = keyid
+	sk.Pub = base64.StdEncoding.EncodeToString(priv.PublicKey().Bytes())
+	sk.Key = base64.StdEncoding.EncodeToString(wrapped)
+	return
+}
+
+// getSecureKey returns the wrapped key of track to the subscriber having the stream key
+//   - the current key, or the old one by keyid of the frames to decrypt
+func (s *Session) getSecureKey(source, track, skey, cpub, keyid string) (sk SecureKey, err error) {
+	if s.chn.StreamKey == "" || !s.chn.isValidStreamKey(skey) {
+		err = fmt.Errorf("not allowed to get the key: %s", s.chn.ID)
+		return
+	}
+	_, trk, err := s.chn.findSourceTrackByLabel(source, track)
+	if err != nil {
+		return
+	}
+	if trk.Mode != "secure" {
+		err = fmt.Errorf("not secure track: %s/%s", source, track)
+		return
+	}
+	var key []byte
+	if keyid == "" {
+		keyid, key, err = s.chn.getTrackKey(source, track)
+	} else {
+		key, err = s.chn.getTrackKeyByID(source, track, keyid)
+	}
+	if err != nil {
+		return
+	}
+	sk, err = WrapSecureKey(cpub, keyid, key)
+	sk.Source, sk.Track = source, track
+	return
+}
+
+//=================================================================================
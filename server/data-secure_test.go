@@ -0,0 +1,132 @@
+// =================================================================================
+// Filename: data-secure_test.go
+// Function: Test functions for data-secure.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fasthttp/websocket"
+)
+
+// ---------------------------------------------------------------------------------
+func TestSecureSlotAndKey(t *testing.T) {
+	dir := mConfig.DirData
+	mConfig.DirData = t.TempDir()
+	defer func() { mConfig.DirData = dir }()
+
+	chn := NewChannelPointer()
+	chn.StreamKey = "secret"
+	src, trk, _ := chn.addSourceTrackBySize("base", "video", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+	trk.Mode = "secure"
+	s := NewSessionPointerWithName("/pang/ws/pub")
+	s.chn, s.src, s.trk = chn, src, trk
+	s.SourceID, s.TrackID = "base", "video"
+
+	// encrypted by the server with the key flag
+	plain := []byte("key frame data")
+	bs := Slot{FrameType: websocket.BinaryMessage, Data: plain, Key: true}
+	if err := s.secureSlot(&bs); err != nil {
+		t.Fatal(err)
+	}
+	if !IsSecureData(bs.Data) || bs.Length != len(bs.Data) || bytes.Contains(bs.Data, plain) {
+		t.Fatal("not encrypted:", bs.Data)
+	}
+	keyid, key, _ := chn.getTrackKey("base", "video")
+	id, data, err := DecodeSecureData(key, bs.Data)
+	if err != nil || id != keyid || !bytes.Equal(data, plain) {
+		t.Fatal("invalid decryption:", id, string(data), err)
+	}
+	bad := append([]byte(nil), bs.Data...)
+	bad[4] ^= SECURE_FLAG_KEY // the header is authenticated
+	if _, _, err = DecodeSecureData(key, bad); err == nil {
+		t.Fatal("tampered header is accepted")
+	}
+
+	// passed through if encrypted by the client
+	ckey := sha256.Sum256([]byte("client key"))
+	frame, _ := EncodeSecureData("client-1", ckey[:], true, plain)
+	bs = Slot{FrameType: websocket.BinaryMessage, Data: frame}
+	if err = s.secureSlot(&bs); err != nil || !bs.Key || !bytes.Equal(bs.Data, frame) {
+		t.Fatal("not passed through:", bs.Key, err)
+	}
+
+	// key exchange by ECDH for the subscriber having the stream key
+	priv, _ := ecdh.P256().GenerateKey(rand.Reader)
+	cpub := base64.StdEncoding.EncodeToString(priv.PublicKey().Bytes())
+	if _, err = s.getSecureKey("base", "video", "wrong", cpub, ""); err == nil {
+		t.Fatal("key is given without the stream key")
+	}
+	sk, err := s.getSecureKey("base", "video", "secret", cpub, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spubData, _ := base64.StdEncoding.DecodeString(sk.Pub)
+	spub, _ := ecdh.P256().NewPublicKey(spubData)
+	shared, _ := priv.ECDH(spub)
+	kek := sha256.Sum256(shared)
+	wrapped, _ := base64.StdEncoding.DecodeString(sk.Key)
+	ukey, err := AES256GSMDecrypt(kek[:], wrapped, []byte(sk.KeyID))
+	if err != nil || sk.KeyID != keyid || !bytes.Equal(ukey, key) {
+		t.Fatal("invalid key exchange:", sk.KeyID, err)
+	}
+}
+
+func TestSecureMasterKeys(t *testing.T) {
+	file := mConfig.SecureFile
+	mConfig.SecureFile = filepath.Join(t.TempDir(), "keys", SECURE_FILE_NAME)
+	defer func() { mConfig.SecureFile = file }()
+
+	// made at the first use, and the same key after a restart
+	chn := NewChannelPointer()
+	keyid, key, err := chn.getTrackKey("base", "video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(mConfig.SecureFile); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatal("master key file is not made:", err)
+	}
+	secureMasters.keys = nil
+	if id, k, _ := chn.getTrackKey("base", "video"); id != keyid || !bytes.Equal(k, key) {
+		t.Fatal("key is changed after restart:", id, keyid)
+	}
+	if id, _, _ := chn.getTrackKey("base", "audio"); id == keyid {
+		t.Fatal("same key for other track")
+	}
+
+	// rotated by a new key at the first line, the old key found by its key id
+	old, _ := os.ReadFile(mConfig.SecureFile)
+	nkey := sha256.Sum256([]byte("new master"))
+	os.WriteFile(mConfig.SecureFile, []byte(hex.EncodeToString(nkey[:])+"\n"+string(old)), 0600)
+	secureMasters.keys = nil
+	nid, _, _ := chn.getTrackKey("base", "video")
+	if nid == keyid {
+		t.Fatal("key is not rotated")
+	}
+	if k, err := chn.getTrackKeyByID("base", "video", keyid); err != nil || !bytes.Equal(k, key) {
+		t.Fatal("old key is not found:", err)
+	}
+	if _, err = chn.getTrackKeyByID("base", "video", "unknown"); err == nil {
+		t.Fatal("unknown key id is found")
+	}
+
+	os.WriteFile(mConfig.SecureFile, []byte("not hex\n"), 0600)
+	secureMasters.keys = nil
+	if _, _, err = chn.getTrackKey("base", "video"); err == nil {
+		t.Fatal("invalid master key is used")
+	}
+	secureMasters.keys = nil
+}
+
+//=================================================================================
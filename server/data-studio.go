@@ -416,6 +416,9 @@ func (d *Studio) addChannelInNameStyleOptions(name, style, key, record, trans st
 	switch record {
 	case "on":
 		p.RecordAuto = true
+	case "rssp", "mp4":
+		p.RecordAuto = true
+		p.RecordFormat = record
 	case "off":
 		p.RecordAuto = false
 	}
@@ -5,16 +5,18 @@ go 1.22
 toolchain go1.22.11
 
 require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/denisbrodbeck/machineid v1.0.1
 	github.com/fasthttp/websocket v1.5.12
+	github.com/klauspost/compress v1.17.11
 	github.com/lestrrat/go-file-rotatelogs v0.0.0-20180223000712-d3151e2a480f
 	github.com/rs/cors v1.8.3
 	github.com/rs/xid v1.4.0
 	golang.org/x/crypto v0.31.0
+	gopkg.in/corvus-ch/zbase32.v1 v1.0.0
 )
 
 require (
-	github.com/andybalholm/brotli v1.1.1 // indirect
-	github.com/denisbrodbeck/machineid v1.0.1 // indirect
 	github.com/df-mc/atomic v1.10.0 // indirect
 	github.com/fastly/go-utils v0.0.0-20180712184237-d95a45783239 // indirect
 	github.com/go-ole/go-ole v1.2.6 // indirect
@@ -22,7 +24,6 @@ require (
 	github.com/google/pprof v0.0.0-20230821062121-407c9e7a662f // indirect
 	github.com/jehiah/go-strftime v0.0.0-20171201141054-1d33003b3869 // indirect
 	github.com/jonboulle/clockwork v0.4.0 // indirect
-	github.com/klauspost/compress v1.17.11 // indirect
 	github.com/klauspost/cpuid/v2 v2.2.6 // indirect
 	github.com/klauspost/reedsolomon v1.12.0 // indirect
 	github.com/lestrrat/go-envload v0.0.0-20180220120943-6ed08b54a570 // indirect
@@ -51,5 +52,4 @@ require (
 	golang.org/x/sys v0.28.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
 	golang.org/x/tools v0.22.0 // indirect
-	gopkg.in/corvus-ch/zbase32.v1 v1.0.0 // indirect
 )
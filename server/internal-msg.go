@@ -6,7 +6,16 @@
 // =================================================================================
 package main
 
-import "log"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/fasthttp/websocket"
+)
 
 // ---------------------------------------------------------------------------------
 const (
@@ -43,18 +52,204 @@ const (
 )
 
 // ---------------------------------------------------------------------------------
+// IMessage is the internal control message in the text slot of moth/json
+//   - {"type":"moth/json","version":1,"seq":1,"action":"request-keyframe","args":{...}}
+//   - acknowledged by {"type":"moth/json","version":1,"seq":1,"action":"ack","ack":"request-keyframe","result":"ok"}
+//
+// The message of hornet/json or spider/json is for other servers, passed through.
+// ---------------------------------------------------------------------------------
+const (
+	IMESSAGE_VERSION = 1
+	// actions forwarded to the other side of track, to the publisher from viewers
+	IACTION_REQUEST_KEYFRAME = "request-keyframe"
+	IACTION_SET_BITRATE      = "set-bitrate" // args: bitrate in bps
+	// actions done by the server, only for the publisher
+	IACTION_START_RECORD   = "start-record" // args: format, rssp or mp4
+	IACTION_STOP_RECORD    = "stop-record"
+	IACTION_START_SNAPSHOT = "start-snapshot" // args: interval in seconds
+	IACTION_STOP_SNAPSHOT  = "stop-snapshot"
+	IACTION_ACK            = "ack"
+	// results of ack
+	IRESULT_OK        = "ok"
+	IRESULT_FORWARDED = "forwarded"
+	IRESULT_ERROR     = "error"
+)
+
+// acks queued to the sender routine of subscriber, not to write the ring of publisher
+const IMESSAGE_ACK_SLOTS = 8
+
 type IMessage struct {
-	Seq    int    `json:"seq,omitempty"`
-	Action string `json:"action,omitempty"`
+	Type    string            `json:"type"`    // moth/json, hornet/json, spider/json
+	Version int               `json:"version"` // IMESSAGE_VERSION
+	Seq     int               `json:"seq,omitempty"`
+	Action  string            `json:"action,omitempty"`
+	Args    map[string]string `json:"args,omitempty"`
+	Ack     string            `json:"ack,omitempty"`    // action acknowledged
+	Result  string            `json:"result,omitempty"` // ok, forwarded, error
+	Error   string            `json:"error,omitempty"`
+}
+
+// check if the text is an internal control message, json object of the internal type
+func IsInternalJSONMessage(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	if len(data) < 2 || data[0] != '{' {
+		return false
+	}
+	im := IMessage{}
+	if json.Unmarshal(data, &im) != nil {
+		return false
+	}
+	return im.Type == MIME_MOTH_JSON || im.Type == MIME_HORNET_JSON || im.Type == MIME_SPIDER_JSON
 }
 
 // ---------------------------------------------------------------------------------
-func ProcInternalJSONMessage(data []byte) (err error) {
-	log.Println("i.ProcInternalMessage:")
+// ProcInternalJSONMessage processes the internal message in the slot sent by the session
+// to the ring b, returning true if the slot is to be written into the ring
+func ProcInternalJSONMessage(s *Session, b *Buffer, bs *Slot) (fpass bool) {
+	im := IMessage{}
+	err := json.Unmarshal(bytes.TrimSpace(bs.Data), &im)
+	if err != nil {
+		log.Println("ProcInternalMessage:", err)
+		return
+	}
+	if im.Type != MIME_MOTH_JSON {
+		return true // for other servers
+	}
+	log.Println("ProcInternalMessage:", s.ID, im.Seq, im.Action, im.Args)
+	if im.Action != IACTION_ACK && !s.readsInternalAck(b) {
+		log.Println("ProcInternalMessage: rejected, no ring to ack outside bundle mode:", s.ID, im.Action)
+		return
+	}
+
+	result, err := s.doInternalAction(im)
+	fpass = result == IRESULT_FORWARDED
+	if im.Action == IACTION_ACK {
+		return true // to the requester
+	}
+	ack := IMessage{Type: MIME_MOTH_JSON, Version: IMESSAGE_VERSION, Seq: im.Seq,
+		Action: IACTION_ACK, Ack: im.Action, Result: result}
+	if err != nil {
+		ack.Result, ack.Error = IRESULT_ERROR, err.Error()
+	}
+	s.sendInternalMessage(b, ack)
+	return
+}
+
+// doInternalAction does the action of message, or checks it to be forwarded
+func (s *Session) doInternalAction(im IMessage) (result string, err error) {
+	if im.Version != IMESSAGE_VERSION {
+		err = fmt.Errorf("unsupported version: %d", im.Version)
+		return
+	}
+	result = IRESULT_OK
+	switch im.Action {
+	case IACTION_ACK:
+		result = IRESULT_FORWARDED
+	case IACTION_REQUEST_KEYFRAME:
+		result = IRESULT_FORWARDED
+	case IACTION_SET_BITRATE:
+		var bitrate int
+		bitrate, err = strconv.Atoi(im.Args["bitrate"])
+		if err != nil || bitrate <= 0 {
+			err = fmt.Errorf("invalid bitrate: %s", im.Args["bitrate"])
+			return
+		}
+		result = IRESULT_FORWARDED
+	case IACTION_START_RECORD, IACTION_STOP_RECORD, IACTION_START_SNAPSHOT, IACTION_STOP_SNAPSHOT:
+		if !strings.HasSuffix(s.Name, "/pub") {
+			err = fmt.Errorf("not allowed to %s: %s", im.Action, s.Name)
+			return
+		}
+		err = s.doChannelAction(im)
+	default:
+		err = fmt.Errorf("unknown action: %s", im.Action)
+	}
+	return
+}
 
-	// TBD
-	log.Println(string(data))
+// doChannelAction does the recording or snapshot of channel requested by the publisher
+func (s *Session) doChannelAction(im IMessage) (err error) {
+	switch im.Action {
+	case IACTION_START_RECORD:
+		format := im.Args["format"]
+		if format != "" && format != "rssp" && format != "mp4" {
+			err = fmt.Errorf("invalid record format: %s [rssp|mp4]", format)
+			return
+		}
+		s.chn.setRecordAuto(true, format)
+	case IACTION_STOP_RECORD:
+		s.chn.setRecordAuto(false, "")
+	case IACTION_START_SNAPSHOT:
+		interval := 0
+		if im.Args["interval"] != "" {
+			interval, err = strconv.Atoi(im.Args["interval"])
+			if err != nil {
+				return
+			}
+		}
+		err = s.chn.setShootAuto(true, interval, "")
+	case IACTION_STOP_SNAPSHOT:
+		err = s.chn.setShootAuto(false, 0, "")
+	}
 	return
 }
 
+// readsInternalAck checks if the session reads the ring of ack for the message to b,
+// the publisher reads the backward ring only in bundle mode
+func (s *Session) readsInternalAck(b *Buffer) bool {
+	if s.Name == "/pang/ws/meb" || b != s.trk.getRingByOrder(BUFFER_NUM_FORE) {
+		return true
+	}
+	return s.trk.Mode == "bundle" && s.trk.Parallel == 0
+}
+
+// sendInternalMessage delivers the message directed to the session
+//   - to the publisher, written into the backward ring shared by the subscribers with the lock,
+//     or b itself if shared (meb)
+//   - to the subscriber, queued to its sender routine, since the forward ring has only one
+//     producer, the publisher writing it without the lock
+func (s *Session) sendInternalMessage(b *Buffer, im IMessage) {
+	data, err := json.Marshal(im)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	bs := Slot{Head: s.ID, To: s.ID, FrameType: websocket.TextMessage, Mark: RSSP_MARK_RTXT, Data: data}
+	bs.getLengthTime()
+
+	rb := b
+	if s.Name != "/pang/ws/meb" {
+		if b != s.trk.getRingByOrder(BUFFER_NUM_FORE) { // from the subscriber
+			select {
+			case s.acks <- bs:
+			default:
+				log.Println("ack dropped, the queue is full:", s.ID, string(data))
+			}
+			return
+		}
+		rb = s.trk.getRingByOrder(BUFFER_NUM_BACK)
+	}
+	if rb == nil {
+		log.Println("no ring to send:", s.trk.Label, string(data))
+		return
+	}
+	rb.writeSlot(bs, true)
+}
+
+// sendQueuedAcks sends the acks queued to the session, called by its sender routine
+func (s *Session) sendQueuedAcks(send func(bs *Slot) error) (err error) {
+	for {
+		select {
+		case bs := <-s.acks:
+			err = send(&bs)
+			if err != nil {
+				return
+			}
+			s.countOutSlot(bs.Length)
+		default:
+			return
+		}
+	}
+}
+
 //=================================================================================
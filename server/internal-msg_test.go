@@ -0,0 +1,164 @@
+// =================================================================================
+// Filename: internal-msg_test.go
+// Function: Test functions for internal-msg.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/fasthttp/websocket"
+)
+
+// ---------------------------------------------------------------------------------
+func readInternalAck(t *testing.T, b *Buffer, to string) (ack IMessage) {
+	bs := b.readSlotBySeq(b.getWriteSeq())
+	if bs == nil || bs.To != to || bs.FrameType != websocket.TextMessage {
+		t.Fatal("no ack for:", to, bs)
+	}
+	if err := json.Unmarshal(bs.Data, &ack); err != nil || ack.Action != IACTION_ACK {
+		t.Fatal("invalid ack:", string(bs.Data), err)
+	}
+	return
+}
+
+// read the ack queued to the subscriber
+func readQueuedAck(t *testing.T, s *Session) (ack IMessage) {
+	var bs *Slot
+	s.sendQueuedAcks(func(qs *Slot) error { bs = qs; return nil })
+	if bs == nil || bs.To != s.ID || bs.FrameType != websocket.TextMessage {
+		t.Fatal("no ack for:", s.ID, bs)
+	}
+	if err := json.Unmarshal(bs.Data, &ack); err != nil || ack.Action != IACTION_ACK {
+		t.Fatal("invalid ack:", string(bs.Data), err)
+	}
+	return
+}
+
+func TestInternalJSONMessage(t *testing.T) {
+	chn := NewChannelPointer()
+	_, trk, _ := chn.addSourceTrackBySize("base", "video", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+	fore, back := trk.getRingByOrder(BUFFER_NUM_FORE), trk.getRingByOrder(BUFFER_NUM_BACK)
+	viewer := NewSessionPointerWithName("/pang/ws/sub")
+	viewer.chn, viewer.trk = chn, trk
+
+	if IsInternalJSONMessage([]byte("video/h264")) || IsInternalJSONMessage([]byte(`{"type":"text/json"}`)) {
+		t.Fatal("mime or json is internal")
+	}
+
+	// request-keyframe of viewer is forwarded to the publisher, acked to the viewer
+	data := []byte(`{"type":"moth/json","version":1,"seq":7,"action":"request-keyframe"}`)
+	bs := Slot{FrameType: websocket.TextMessage, Data: data}
+	if !IsInternalJSONMessage(data) || !ProcInternalJSONMessage(viewer, back, &bs) {
+		t.Fatal("request-keyframe is not forwarded")
+	}
+	if ack := readQueuedAck(t, viewer); ack.Seq != 7 || ack.Ack != IACTION_REQUEST_KEYFRAME || ack.Result != IRESULT_FORWARDED {
+		t.Fatal("invalid ack:", ack)
+	}
+
+	// channel actions are only for the publisher
+	bs.Data = []byte(`{"type":"moth/json","version":1,"seq":8,"action":"start-record","args":{"format":"mp4"}}`)
+	if ProcInternalJSONMessage(viewer, back, &bs) || chn.RecordAuto {
+		t.Fatal("recording is started by the viewer")
+	}
+	if ack := readQueuedAck(t, viewer); ack.Result != IRESULT_ERROR {
+		t.Fatal("invalid ack:", ack)
+	}
+
+	// rejected without ack if the publisher does not read the backward ring
+	pub := NewSessionPointerWithName("/pang/ws/pub")
+	pub.chn, pub.trk = chn, trk
+	wseq := back.getWriteSeq()
+	if ProcInternalJSONMessage(pub, fore, &bs) || chn.RecordAuto || back.getWriteSeq() != wseq {
+		t.Fatal("message is processed in single mode")
+	}
+
+	// done by the server, or error acked
+	trk.Mode = "bundle"
+	if ProcInternalJSONMessage(pub, fore, &bs) || !chn.RecordAuto || chn.RecordFormat != "mp4" {
+		t.Fatal("recording is not started:", chn.RecordAuto, chn.RecordFormat)
+	}
+	if ack := readInternalAck(t, back, pub.ID); ack.Seq != 8 || ack.Result != IRESULT_OK {
+		t.Fatal("invalid ack:", ack)
+	}
+	bs.Data = []byte(`{"type":"moth/json","version":1,"seq":9,"action":"set-bitrate","args":{"bitrate":"-1"}}`)
+	if ProcInternalJSONMessage(viewer, back, &bs) {
+		t.Fatal("invalid bitrate is forwarded")
+	}
+	if ack := readQueuedAck(t, viewer); ack.Seq != 9 || ack.Result != IRESULT_ERROR || ack.Error == "" {
+		t.Fatal("invalid ack:", ack)
+	}
+	bs.Data = []byte(`{"type":"moth/json","version":2,"seq":10,"action":"stop-record"}`)
+	if ProcInternalJSONMessage(pub, fore, &bs) || !chn.RecordAuto {
+		t.Fatal("unsupported version is processed")
+	}
+
+	// for other servers
+	bs.Data = []byte(`{"type":"spider/json","version":1,"action":"anything"}`)
+	if !ProcInternalJSONMessage(pub, fore, &bs) {
+		t.Fatal("spider message is not passed")
+	}
+}
+
+func TestInternalMessageProducers(t *testing.T) {
+	chn := NewChannelPointer()
+	_, trk, _ := chn.addSourceTrackBySize("base", "video", BUFFER_MAX_CAPS, BUFFER_MAX_CAPS, 0)
+	trk.Mode = "bundle"
+	fore, back := trk.getRingByOrder(BUFFER_NUM_FORE), trk.getRingByOrder(BUFFER_NUM_BACK)
+	pub := NewSessionPointerWithName("/pang/ws/pub")
+	sub := NewSessionPointerWithName("/pang/ws/sub")
+	pub.chn, pub.trk, sub.chn, sub.trk = chn, trk, chn, trk
+
+	// the publisher and the bundle subscriber write their rings and send control messages together
+	const n = 100
+	nsub := 0
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			fore.writeSlot(Slot{Head: pub.ID, FrameType: websocket.BinaryMessage, Data: []byte{byte(i)}}, false)
+			if i%2 == 0 {
+				bs := Slot{FrameType: websocket.TextMessage, Data: []byte(`{"type":"moth/json","version":1,"action":"set-bitrate","args":{"bitrate":"1000"}}`)}
+				ProcInternalJSONMessage(pub, fore, &bs)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			back.writeSlot(Slot{Head: sub.ID, FrameType: websocket.BinaryMessage, Data: []byte{byte(i)}}, true)
+			if i%2 == 0 {
+				bs := Slot{FrameType: websocket.TextMessage, Data: []byte(`{"type":"moth/json","version":1,"action":"request-keyframe"}`)}
+				ProcInternalJSONMessage(sub, back, &bs)
+				sub.sendQueuedAcks(func(bs *Slot) error { nsub++; return nil })
+			}
+		}
+	}()
+	wg.Wait()
+
+	// no slot of the publisher is overwritten by the acks, all acks of publisher are in the back ring
+	if fore.getWriteSeq() != n || back.getWriteSeq() != n+n/2 {
+		t.Fatal("invalid write seqs:", fore.getWriteSeq(), back.getWriteSeq())
+	}
+	for seq := uint64(1); seq <= n; seq++ {
+		if bs := fore.readSlotBySeq(seq); bs == nil || bs.Head != pub.ID || bs.Data[0] != byte(seq-1) {
+			t.Fatal("publisher slot is lost:", seq, bs)
+		}
+	}
+	nack := 0
+	for seq := uint64(1); seq <= n+n/2; seq++ {
+		if bs := back.readSlotBySeq(seq); bs != nil && bs.To == pub.ID {
+			nack++
+		}
+	}
+	if nack != n/2 || nsub != n/2 {
+		t.Fatal("acks are lost:", nack, nsub)
+	}
+}
+
+//=================================================================================
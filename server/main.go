@@ -133,6 +133,7 @@ func main() {
 	// pStudio.writeObjectFileInArray("bridge", "conf/bridges.json") // for checking
 
 	go StudioEventBroker(pStudio)
+	go RunMetricSampler(pStudio)
 	RunSelfChecker(pStudio)
 }
 
@@ -196,6 +197,9 @@ func (d *MothConfig) setupMuxServerAPIs() (handler http.Handler) {
 	mux.HandleFunc("/pang/ws/", PangWSHandler) // WebSocket(ws)
 	mux.HandleFunc("/cast/ws/", CastWSHandler)
 
+	// Recording APIs
+	mux.HandleFunc("/record/", RecordHTTPHandler)
+
 	// Signalling server APIs
 	mux.HandleFunc("/signal/ws/", SignalWSHandler)
 
@@ -336,6 +340,21 @@ func PangHTTPHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ---------------------------------------------------------------------------------
+func RecordHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("IN RecordHTTPHandler:", r.Method, r.URL, r.RemoteAddr)
+	defer log.Println("OUT RecordHTTPHandler:", r.URL)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	err := RecordMP4Download(w, r)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
 // ---------------------------------------------------------------------------------
 func PangWSHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("IN PangWSHandler:", r.Method, r.URL, r.RemoteAddr)
@@ -14,6 +14,7 @@ import (
 	"os"
 	"os/exec"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -33,7 +34,10 @@ type Command struct {
 	Opt    string `json:"opt,omitempty"`   // Option
 	Value  string `json:"value,omitempty"`
 	Format string `json:"format,omitempty"`
-	Key    string `json:"key,omitempty"` // Key for Manager
+	Key    string `json:"key,omitempty"`   // Key for Manager
+	Speed  string `json:"speed,omitempty"` // Speed for player
+	Seek   string `json:"seek,omitempty"`  // Seek for player
+	Loop   string `json:"loop,omitempty"`  // Loop for player
 	// Below are not used in CLI
 	Method string `json:"method,omitempty"`
 	Path   string `json:"path,omitempty"`
@@ -76,6 +80,9 @@ func (cmd *Command) parseQuery(r *http.Request) (err error) {
 	cmd.State = query.Get("state")   // idle, using, close, block
 	cmd.Style = query.Get("style")   // static, instant, dynamic
 	cmd.Format = query.Get("format") // text, json, base64, form, ...
+	cmd.Speed = query.Get("speed")   // 1.0, 0.5, 2, ... for player
+	cmd.Seek = query.Get("seek")     // 30s, 1m, ... for player
+	cmd.Loop = query.Get("loop")     // on, off for player
 	if cmd.Format == "" {
 		cmd.Format = "text"
 	}
@@ -125,6 +132,8 @@ func (cmd *Command) execManager() (str string, err error) {
 			} else {
 				str, err = cmd.infoWorker()
 			}
+		case "metric":
+			str, err = cmd.showMetrics()
 		case "studio":
 			str, err = cmd.showStudio()
 		case "config":
@@ -159,6 +168,8 @@ func (cmd *Command) execManager() (str string, err error) {
 			str, err = cmd.runBridge()
 		case "shell":
 			str, err = cmd.runShell()
+		case "player":
+			str, err = cmd.runPlayer()
 		default:
 			err = fmt.Errorf("invalid obj %s for %s", cmd.Obj, cmd.Op)
 			return
@@ -404,6 +415,57 @@ func (cmd *Command) showChannels() (str string, err error) {
 	return
 }
 
+// show the rate metrics of channels and their tracks, or of the channel given by id
+func (cmd *Command) showMetrics() (str string, err error) {
+	log.Println("i.showMetrics:", cmd.ID, cmd.Value)
+	pStudio.ChannelGate.RLock()
+	defer pStudio.ChannelGate.RUnlock()
+
+	type MetricItem struct {
+		Type   string      `json:"type"`
+		ID     string      `json:"id"`
+		Label  string      `json:"label"`
+		Metric MetricRates `json:"metric"`
+		m      *Metric
+	}
+	var chns []*Channel
+	for _, c := range pStudio.Channels {
+		if cmd.ID != "" && cmd.ID != c.ID {
+			continue
+		}
+		if cmd.Value != "" && !strings.Contains(c.Name, cmd.Value) {
+			continue
+		}
+		chns = append(chns, c)
+	}
+	sort.Slice(chns, func(i, j int) bool {
+		return chns[i].ID < chns[j].ID
+	})
+
+	var items []MetricItem
+	for _, c := range chns {
+		items = append(items, MetricItem{"channel", c.ID, c.Name, c.getRates(), &c.Metric})
+		c.Lock()
+		for _, src := range c.Sources {
+			for _, t := range src.Tracks {
+				items = append(items, MetricItem{"track", t.ID, src.Label + "/" + t.Label, t.getRates(), &t.Metric})
+			}
+		}
+		c.Unlock()
+	}
+
+	if cmd.Format == "json" {
+		data, _ := json.MarshalIndent(items, "", "   ")
+		str = string(data)
+		return
+	}
+	for _, v := range items {
+		str += fmt.Sprintf("[%s] %s, %s: %s\n", v.Type, v.ID, v.Label, v.m.String())
+	}
+	str += fmt.Sprintf("Total: %d", len(items))
+	return
+}
+
 func (cmd *Command) infoChannel() (str string, err error) {
 	log.Println("infoChannel:", cmd.ID)
 	p := pStudio.findChannelByID(cmd.ID)
@@ -455,6 +517,106 @@ func (cmd *Command) setChannel() (str string, err error) {
 		} else if cmd.Value != "" {
 			p.StreamKey = cmd.Value
 		}
+	case "record":
+		if cmd.Value != "" && cmd.Value != "rssp" && cmd.Value != "mp4" {
+			err = fmt.Errorf("invalid record format: %s [rssp|mp4]", cmd.Value)
+			return
+		}
+		if cmd.State == "on" {
+			p.setRecordAuto(true, cmd.Value)
+		} else if cmd.State == "off" {
+			p.setRecordAuto(false, "")
+		}
+	case "trans":
+		if cmd.State == "on" {
+			p.setTransAuto(true, cmd.Value)
+		} else if cmd.State == "off" {
+			p.setTransAuto(false, "")
+		}
+	case "procs":
+		if cmd.State == "on" {
+			p.setProcsAuto(true)
+		} else if cmd.State == "off" {
+			p.setProcsAuto(false)
+		}
+	case "proc":
+		label, spec, _ := strings.Cut(cmd.Value, ":")
+		err = p.setTrackProc(label, spec)
+		if err != nil {
+			return
+		}
+	case "relay":
+		switch cmd.State {
+		case "on":
+			p.setRelayAuto(true)
+		case "off":
+			p.setRelayAuto(false)
+		case "add": // url[,channel[,key]]
+			var rt *RelayTarget
+			rt, err = ParseRelayTarget(cmd.Value)
+			if err != nil {
+				return
+			}
+			p.addRelayTarget(rt)
+			p.setRelayAuto(true)
+		case "del": // url or all
+			err = p.deleteRelayTarget(cmd.Value)
+			if err != nil {
+				return
+			}
+		default:
+			err = fmt.Errorf("invalid relay state: %s [on|off|add|del]", cmd.State)
+			return
+		}
+	case "shoot":
+		interval := 0
+		if cmd.Value != "" {
+			interval, err = strconv.Atoi(cmd.Value)
+			if err != nil {
+				return
+			}
+		}
+		if cmd.State == "on" {
+			err = p.setShootAuto(true, interval, "")
+		} else if cmd.State == "off" {
+			err = p.setShootAuto(false, 0, "")
+		}
+		if err != nil {
+			return
+		}
+	case "shoot_keep":
+		var keep int
+		keep, err = strconv.Atoi(cmd.Value)
+		if err != nil || keep < 1 {
+			err = fmt.Errorf("invalid shoot keep: %s", cmd.Value)
+			return
+		}
+		p.Lock()
+		p.ShootKeep = keep
+		p.Unlock()
+	case "shoot_tracks":
+		if cmd.Value == "" {
+			err = fmt.Errorf("no tracks for shoot: %s [all|track,source/track,...]", cmd.ID)
+			return
+		}
+		p.Lock()
+		p.ShootTracks = cmd.Value
+		if cmd.Value == "all" {
+			p.ShootTracks = ""
+		}
+		p.Unlock()
+	case "dvr":
+		secs := 0
+		if cmd.State != "off" {
+			secs, err = strconv.Atoi(cmd.Value)
+			if err != nil {
+				return
+			}
+		}
+		err = p.setDVRWindow(secs)
+		if err != nil {
+			return
+		}
 	case "name":
 		if cmd.Value != "" {
 			p.Name = cmd.Value
@@ -718,6 +880,40 @@ func (cmd *Command) runBridge() (str string, err error) {
 	return
 }
 
+// --------------------------------------------------------------------------------
+// runPlayer starts to play the recording (value) into the channel (id), or stops the player (id)
+func (cmd *Command) runPlayer() (str string, err error) {
+	log.Println("i.runPlayer:", cmd.ID, cmd.State, cmd.Value)
+	switch cmd.State {
+	case "start":
+		p := NewPlayerPointer(cmd.Value, cmd.ID)
+		if cmd.Speed != "" {
+			p.Speed, err = strconv.ParseFloat(cmd.Speed, 64)
+			if err != nil {
+				return
+			}
+		}
+		if cmd.Seek != "" {
+			p.Seek, err = time.ParseDuration(cmd.Seek)
+			if err != nil {
+				return
+			}
+		}
+		p.Loop = (cmd.Loop == "on")
+		var w *Worker
+		w, err = pStudio.startPlayer(p)
+		if err != nil {
+			return
+		}
+		return FormatItem(w, cmd.Format)
+	case "stop":
+		err = pStudio.stopPlayer(cmd.ID)
+	default:
+		err = fmt.Errorf("command invalid action: %s [start|stop]", cmd.State)
+	}
+	return
+}
+
 func (cmd *Command) addBridge() (str string, err error) {
 	log.Println("i.addBridge:", cmd.ID, cmd.Value)
 	p := NewBridgePointer()
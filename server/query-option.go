@@ -43,6 +43,18 @@ type QueryOption struct {
 		Wait    string `json:"wait,omitempty"`  // allow wait a track on no pubs
 		Style   string `json:"style,omitempty"` // allow multiple pubs for the same track
 		ReqID   string `json:"req_id,omitempty"`
+		Gop     string `json:"gop,omitempty"`    // (on), off: send the cached gop to a new subscriber
+		Policy  string `json:"policy,omitempty"` // (latest), keyframe, block, disconnect for slow subscriber
+		Stamp   string `json:"stamp,omitempty"`  // on, (off): prefix seq, from and timestamps to the data
+		Group   string `json:"group,omitempty"`  // group id of the session for the group filter
+		From    string `json:"from,omitempty"`   // time to start reading from the dvr: -30s, RFC3339, unix
+		Accept  string `json:"accept,omitempty"` // compressed frames to receive: zstd, brotli
+		// rendition of jpeg track to receive
+		Scale   string `json:"scale,omitempty"`   // WxH
+		Quality string `json:"quality,omitempty"` // 1-100
+		// prefixes in the data of publisher
+		Capture string `json:"capture,omitempty"` // on, (off): capture time, "RCAP"
+		Direct  string `json:"direct,omitempty"`  // on, (off): destination of directed delivery, "RDST"
 	} `json:"session,omitempty"`
 	Channel struct {
 		ID     string `json:"id,omitempty"`
@@ -51,6 +63,8 @@ type QueryOption struct {
 		Key    string `json:"key,omitempty"`
 		Code   string `json:"code,omitempty"`
 		Record string `json:"record,omitempty"`
+		DVR    string `json:"dvr,omitempty"`   // time-shift window in seconds, off
+		Shoot  string `json:"shoot,omitempty"` // snapshot interval in seconds, on, off
 		Trans  string `json:"trans,omitempty"`
 		Period string `json:"period,omitempty"`
 	} `json:"channel,omitempty"`
@@ -61,9 +75,11 @@ type QueryOption struct {
 		Label    string `json:"label,omitempty"`
 		Mode     string `json:"mode,omitempty"`     // operation mode: single, (bundle), parallel, secure
 		Style    string `json:"style,omitempty"`    // style: (mono), multi
-		Filter   string `json:"filter,omitempty"`   // filter: echo, each, (group), all = echo + group
+		Filter   string `json:"filter,omitempty"`   // filter: echo, self, (group), all = echo + group
 		Codec    string `json:"codec,omitempty"`    // requiring codec name
 		Proc     string `json:"proc,omitempty"`     // function to process
+		Compress string `json:"compress,omitempty"` // compression of text or json: zstd, brotli
+		Dict     string `json:"dict,omitempty"`     // on, (off): train the dictionary of zstd
 		Bitrate  string `json:"bitrate,omitempty"`  // need?
 		Parallel int    `json:"parallel,omitempty"` // number of parallel streams within a track
 	} `json:"track,omitempty"`
@@ -73,6 +89,7 @@ type QueryOption struct {
 		Order int    `json:"order,omitempty"` // order number in buffers
 		Cap   int    `json:"cap,omitempty"`   // number of slots to allocate
 		Len   int    `json:"len,omitempty"`   // number of slots to use
+		Mime  string `json:"mime,omitempty"`  // mime type of the buffer
 	} `json:"buffer,omitempty"`
 }
 
@@ -142,6 +159,8 @@ func GetQueryOptionFromQuery(query url.Values) (qo QueryOption, err error) {
 	qo.Channel.Name = query.Get("name")     // Name
 	qo.Channel.Key = query.Get("key")       // StreamKey
 	qo.Channel.Record = query.Get("record") // Recording (on|off)
+	qo.Channel.DVR = query.Get("dvr")       // Time-shift window in seconds (N|off)
+	qo.Channel.Shoot = query.Get("shoot")   // Snapshot interval in seconds (N|on|off)
 	qo.Channel.Trans = query.Get("trans")   // Transcoding (on|off)
 	qo.Channel.Period = query.Get("period") // Time period in hour string
 
@@ -168,12 +187,22 @@ func GetQueryOptionFromQuery(query url.Values) (qo QueryOption, err error) {
 	if qo.Track.Style == "" {
 		qo.Track.Style = "mono" // (mono), multi
 	}
-	qo.Track.Filter = query.Get("filter") // mode type of a Track
-	if qo.Track.Filter == "" {
+	qo.Track.Filter = query.Get("filter") // delivery filter of a Track
+	switch qo.Track.Filter {
+	case "echo", "self", "group", "all":
+	default:
 		qo.Track.Filter = "group" // echo, self, (group), all
 	}
 	qo.Track.Codec = query.Get("codec") // jpeg, vp8, h264, aac, ...
 	qo.Track.Proc = query.Get("proc")   // motion, face, yolo, aes, ...
+	qo.Track.Compress = query.Get("compress")
+	switch qo.Track.Compress {
+	case "", "zstd", "brotli":
+	default:
+		err = fmt.Errorf("invalid compress: %s [zstd|brotli]", qo.Track.Compress)
+		return
+	}
+	qo.Track.Dict = query.Get("dict")
 	qo.Track.Bitrate = query.Get("bitrate")
 	// log.Println(qo.Track.Bitrate)
 	// qo.Track.Mime = query.Get("mime") // mime type of a Track content
@@ -192,6 +221,28 @@ func GetQueryOptionFromQuery(query url.Values) (qo QueryOption, err error) {
 	qo.Session.ReqID = query.Get("reqid") // unique info provided by client
 	qo.Session.ID = query.Get("session")
 	qo.Session.Wait = query.Get("wait")
+	qo.Session.Gop = query.Get("gop") // off for the low latency data
+	if qo.Session.Gop == "" {
+		qo.Session.Gop = "on" // (on), off
+	}
+	qo.Session.Stamp = query.Get("stamp")   // stamp header to the data of subscriber
+	qo.Session.Group = query.Get("group")   // group id for the group filter
+	qo.Session.From = query.Get("from")     // time-shift from the dvr of channel
+	qo.Session.Accept = query.Get("accept") // compressed frames of subscriber
+	qo.Session.Policy = query.Get("policy") // backpressure policy of subscriber
+	switch qo.Session.Policy {
+	case "latest", "keyframe", "block", "disconnect":
+	default:
+		qo.Session.Policy = "latest" // (latest), keyframe, block, disconnect
+	}
+	qo.Session.Scale = query.Get("scale") // jpeg rendition of subscriber
+	qo.Session.Quality = query.Get("quality")
+	_, _, _, err = ParseScaleOptions(qo.Session.Scale, qo.Session.Quality)
+	if err != nil {
+		return
+	}
+	qo.Session.Capture = query.Get("capture") // capture time prefix in the data of publisher
+	qo.Session.Direct = query.Get("direct")   // destination prefix in the data of publisher
 
 	qo.Session.Unit = query.Get("unit") // time unit for buffering check
 	if qo.Session.Unit == "" {
@@ -218,15 +269,18 @@ func GetQueryOptionFromQuery(query url.Values) (qo QueryOption, err error) {
 			qo.Buffer.Total = n
 			log.Println("buf_total:", qo.Buffer.Total)
 		}
-		order := query.Get("buf_order") // Buffer order number
-		if order != "" {
-			n, err := strconv.Atoi(order)
-			if err != nil {
-				log.Println(err)
-			} else {
-				qo.Buffer.Order = n
-				log.Println("buf_order:", qo.Buffer.Order)
-			}
+	}
+	qo.Buffer.Order = BUFFER_ORDER_NONE // not given, default buffer
+	order := query.Get("buf_order")     // Buffer order number, all
+	if order == "all" {
+		qo.Buffer.Order = BUFFER_ORDER_ALL
+	} else if order != "" {
+		n, err := strconv.Atoi(order)
+		if err != nil {
+			log.Println(err)
+		} else {
+			qo.Buffer.Order = n
+			log.Println("buf_order:", qo.Buffer.Order)
 		}
 	}
 	cap := query.Get("buf_cap") // track buffer capa = N of buffer slots to allocate
@@ -1,32 +1,827 @@
 // =================================================================================
 // Filename: util-codec.go
-// Function: Codec handling
+// Function: Codec handling, bitstream inspection for key frames and stream info
 // Author: Stoney Kang, sikang@teamgrit.kr
-// Copyright: TeamGRIT, 2023
+// Copyright: TeamGRIT, 2023, 2026
 // =================================================================================
 package main
 
-import "log"
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
 
 // ---------------------------------------------------------------------------------
-func IsKeyFrame(codec string, data []byte) (fkey bool) {
-	log.Println("i.IsKeyFrame:", codec)
+// CodecInfo is the stream information parsed from the parameter sets in the bitstream
+// ---------------------------------------------------------------------------------
+type CodecInfo struct {
+	Name    string  `json:"name,omitempty"`    // jpeg, h264, h265, vp8, vp9, av1
+	Format  string  `json:"format,omitempty"`  // annexb, avcc for h264/h265
+	Profile string  `json:"profile,omitempty"` // profile name
+	Level   string  `json:"level,omitempty"`   // level number
+	Width   int     `json:"width,omitempty"`   // picture width in pixels
+	Height  int     `json:"height,omitempty"`  // picture height in pixels
+	FPS     float64 `json:"fps,omitempty"`     // frame rate if signaled
+}
+
+func (d CodecInfo) String() (str string) {
+	str += fmt.Sprintf("%s(%s) %s@%s, %dx%d, %.2ffps", d.Name, d.Format, d.Profile, d.Level, d.Width, d.Height, d.FPS)
+	return
+}
+
+// merge the newly parsed info, fields not signaled in the frame are kept
+func (d *CodecInfo) merge(ci CodecInfo) {
+	if ci.Name != "" {
+		d.Name = ci.Name
+	}
+	if ci.Format != "" {
+		d.Format = ci.Format
+	}
+	if ci.Profile != "" {
+		d.Profile = ci.Profile
+	}
+	if ci.Level != "" {
+		d.Level = ci.Level
+	}
+	if ci.Width > 0 && ci.Height > 0 {
+		d.Width, d.Height = ci.Width, ci.Height
+	}
+	if ci.FPS > 0 {
+		d.FPS = ci.FPS
+	}
+}
 
+// ---------------------------------------------------------------------------------
+// get the codec name from the mime of the track
+//   - ex) image/jpeg, video/h264, video/webm; codecs="vp8", video/mp4; codecs="avc1.42E01E", audio/opus
+func GetCodecFromMime(mime string) (codec string) {
+	mime = strings.ToLower(mime)
+	switch {
+	case mime == "":
+		codec = ""
+	case strings.Contains(mime, "jpeg"), strings.Contains(mime, "jpg"):
+		codec = "jpeg"
+	case strings.Contains(mime, "h264"), strings.Contains(mime, "avc1"), strings.Contains(mime, "avc3"):
+		codec = "h264"
+	case strings.Contains(mime, "h265"), strings.Contains(mime, "hevc"),
+		strings.Contains(mime, "hvc1"), strings.Contains(mime, "hev1"):
+		codec = "h265"
+	case strings.Contains(mime, "vp8"):
+		codec = "vp8"
+	case strings.Contains(mime, "vp9"), strings.Contains(mime, "vp09"):
+		codec = "vp9"
+	case strings.Contains(mime, "av1"), strings.Contains(mime, "av01"):
+		codec = "av1"
+	case strings.Contains(mime, "opus"):
+		codec = "opus"
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// IsKeyFrame checks whether the frame can be decoded by itself (random access point)
+// ---------------------------------------------------------------------------------
+func IsKeyFrame(codec string, data []byte) (fkey bool) {
 	switch codec {
 	case "jpeg":
-		fkey = true
+		fkey = len(data) > 2 && data[0] == 0xff && data[1] == 0xd8
 	case "h264":
-		// 00 00 00 01 65(IDR), 67(SPS), 68(PPS)
-		if data[5] == 0x65 || data[5] == 0x67 || data[5] == 0x68 {
+		nals, _ := SplitNALUnits(data)
+		for _, nal := range nals {
+			if nal[0]&0x1f == 5 { // IDR
+				return true
+			}
+		}
+	case "h265":
+		nals, _ := SplitNALUnits(data)
+		for _, nal := range nals {
+			if nut := (nal[0] >> 1) & 0x3f; nut >= 16 && nut <= 23 { // IRAP
+				return true
+			}
+		}
+	case "vp8":
+		fkey = len(data) > 2 && data[0]&0x1 == 0
+	case "vp9":
+		fkey, _ = parseVP9Header(data)
+	case "av1":
+		fkey, _ = parseAV1Frame(data)
+	}
+	return
+}
+
+// IsParamSet checks whether the frame has the parameter sets (VPS, SPS, PPS) of h264/h265,
+// sent in the slots of their own before the key frame by some publishers
+func IsParamSet(codec string, data []byte) bool {
+	switch codec {
+	case "h264":
+		nals, _ := SplitNALUnits(data)
+		for _, nal := range nals {
+			if nut := nal[0] & 0x1f; nut == 7 || nut == 8 { // SPS, PPS
+				return true
+			}
+		}
+	case "h265":
+		nals, _ := SplitNALUnits(data)
+		for _, nal := range nals {
+			if nut := (nal[0] >> 1) & 0x3f; nut >= 32 && nut <= 34 { // VPS, SPS, PPS
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ---------------------------------------------------------------------------------
+// ParseCodecInfo extracts the stream info from the frame, ok is false if not found
+// ---------------------------------------------------------------------------------
+func ParseCodecInfo(codec string, data []byte) (ci CodecInfo, ok bool) {
+	var err error
+	switch codec {
+	case "jpeg":
+		ci, err = parseJPEGInfo(data)
+	case "h264", "h265":
+		nals, format := SplitNALUnits(data)
+		err = fmt.Errorf("no parameter set")
+		for _, nal := range nals {
+			var pi CodecInfo
+			var perr error
+			if codec == "h264" && nal[0]&0x1f == 7 {
+				pi, perr = parseH264SPS(nal)
+			} else if codec == "h265" && (nal[0]>>1)&0x3f == 33 {
+				pi, perr = parseH265SPS(nal)
+			} else if codec == "h265" && (nal[0]>>1)&0x3f == 32 {
+				pi, perr = parseH265VPS(nal)
+			} else {
+				continue
+			}
+			if perr != nil {
+				return ci, false
+			}
+			ci.merge(pi)
+			err = nil
+		}
+		ci.Format = format
+	case "vp8":
+		ci, err = parseVP8Info(data)
+	case "vp9":
+		var fkey bool
+		fkey, ci = parseVP9Header(data)
+		if !fkey {
+			err = fmt.Errorf("not key frame")
+		}
+	case "av1":
+		var fseq bool
+		fseq, ci = parseAV1Frame(data)
+		if !fseq || ci.Width == 0 {
+			err = fmt.Errorf("no sequence header")
+		}
+	default:
+		err = fmt.Errorf("unknown codec: %s", codec)
+	}
+	if err != nil {
+		return ci, false
+	}
+	ci.Name = codec
+	return ci, true
+}
+
+// ---------------------------------------------------------------------------------
+// SplitNALUnits splits the h264/h265 access unit in Annex-B or AVCC (length prefixed)
+//   - AVCC is taken only if the lengths cover the data exactly with valid NAL headers,
+//     since a length of 0x00000001 or 0x000001xx looks like a start code of Annex-B
+//
+// ---------------------------------------------------------------------------------
+func SplitNALUnits(data []byte) (nals [][]byte, format string) {
+	if nals = splitAVCC(data); nals != nil {
+		return nals, "avcc"
+	}
+	if len(data) > 3 && data[0] == 0 && data[1] == 0 && (data[2] == 1 || (data[2] == 0 && data[3] == 1)) {
+		return splitAnnexB(data), "annexb"
+	}
+	return nil, ""
+}
+
+func splitAnnexB(data []byte) (nals [][]byte) {
+	start := -1
+	for i := 0; i+2 < len(data); {
+		j := bytes.Index(data[i:], []byte{0, 0, 1})
+		if j < 0 {
+			break
+		}
+		j += i
+		if start >= 0 {
+			end := j
+			if end > start && data[end-1] == 0 { // 4 byte start code
+				end--
+			}
+			if end > start {
+				nals = append(nals, data[start:end])
+			}
+		}
+		start = j + 3
+		i = start
+	}
+	if start >= 0 && start < len(data) {
+		nals = append(nals, data[start:])
+	}
+	return
+}
+
+func splitAVCC(data []byte) (nals [][]byte) {
+	for len(data) > 0 {
+		if len(data) < 5 {
+			return nil
+		}
+		n := int(binary.BigEndian.Uint32(data))
+		if n < 2 || n > len(data)-4 || data[4]&0x80 != 0 { // header and payload, forbidden bit
+			return nil
+		}
+		nals = append(nals, data[4:4+n])
+		data = data[4+n:]
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// bitReader for the parameter sets, exp-golomb coded
+// ---------------------------------------------------------------------------------
+type bitReader struct {
+	data []byte
+	pos  int // in bits
+	err  error
+}
+
+// remove the emulation prevention bytes (00 00 03) from the NAL unit payload
+func unescapeRBSP(data []byte) (rbsp []byte) {
+	rbsp = make([]byte, 0, len(data))
+	zeros := 0
+	for _, c := range data {
+		if zeros >= 2 && c == 3 {
+			zeros = 0
+			continue
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, c)
+	}
+	return
+}
+
+func (d *bitReader) u(n int) (v uint32) {
+	for i := 0; i < n; i++ {
+		if d.pos >= len(d.data)*8 {
+			d.err = fmt.Errorf("short bitstream")
+			return 0
+		}
+		bit := (d.data[d.pos/8] >> (7 - uint(d.pos%8))) & 1
+		v = v<<1 | uint32(bit)
+		d.pos++
+	}
+	return
+}
+
+func (d *bitReader) flag() bool {
+	return d.u(1) == 1
+}
+
+func (d *bitReader) skip(n int) {
+	for n > 24 {
+		d.u(24)
+		n -= 24
+	}
+	d.u(n)
+}
+
+func (d *bitReader) ue() (v uint32) {
+	zeros := 0
+	for !d.flag() {
+		if d.err != nil || zeros > 31 {
+			d.err = fmt.Errorf("invalid exp-golomb code")
+			return 0
+		}
+		zeros++
+	}
+	return (1<<uint(zeros) - 1) + d.u(zeros)
+}
+
+func (d *bitReader) se() (v int32) {
+	k := d.ue()
+	if k&1 == 1 {
+		return int32((k + 1) / 2)
+	}
+	return -int32(k / 2)
+}
+
+// variable length code of AV1
+func (d *bitReader) uvlc() (v uint32) {
+	zeros := 0
+	for !d.flag() {
+		if d.err != nil || zeros >= 32 {
+			return 0
+		}
+		zeros++
+	}
+	return d.u(zeros) + (1<<uint(zeros) - 1)
+}
+
+// ---------------------------------------------------------------------------------
+// H.264 SPS (ITU-T H.264 7.3.2.1.1)
+// ---------------------------------------------------------------------------------
+func parseH264SPS(nal []byte) (ci CodecInfo, err error) {
+	r := &bitReader{data: unescapeRBSP(nal[1:])}
+
+	profile := r.u(8)
+	constraint := r.u(8)
+	level := r.u(8)
+	r.ue() // seq_parameter_set_id
+
+	chroma := uint32(1)
+	separate := false
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chroma = r.ue()
+		if chroma == 3 {
+			separate = r.flag()
+		}
+		r.ue()        // bit_depth_luma_minus8
+		r.ue()        // bit_depth_chroma_minus8
+		r.skip(1)     // qpprime_y_zero_transform_bypass_flag
+		if r.flag() { // seq_scaling_matrix_present_flag
+			n := 8
+			if chroma == 3 {
+				n = 12
+			}
+			for i := 0; i < n; i++ {
+				if !r.flag() {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := int32(8), int32(8)
+				for j := 0; j < size && r.err == nil; j++ {
+					if next != 0 {
+						next = (last + r.se() + 256) % 256
+					}
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+	r.ue()          // log2_max_frame_num_minus4
+	switch r.ue() { // pic_order_cnt_type
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.skip(1) // delta_pic_order_always_zero_flag
+		r.se()    // offset_for_non_ref_pic
+		r.se()    // offset_for_top_to_bottom_field
+		n := r.ue()
+		for i := uint32(0); i < n && r.err == nil; i++ {
+			r.se()
+		}
+	}
+	r.ue()    // max_num_ref_frames
+	r.skip(1) // gaps_in_frame_num_value_allowed_flag
+	wmbs := r.ue() + 1
+	hmaps := r.ue() + 1
+	frameMbsOnly := r.u(1)
+	if frameMbsOnly == 0 {
+		r.skip(1) // mb_adaptive_frame_field_flag
+	}
+	r.skip(1) // direct_8x8_inference_flag
+
+	var cl, cr, ct, cb uint32
+	if r.flag() { // frame_cropping_flag
+		cl, cr, ct, cb = r.ue(), r.ue(), r.ue(), r.ue()
+	}
+	if r.err != nil {
+		return ci, r.err
+	}
+
+	cropX, cropY := uint32(1), 2-frameMbsOnly
+	if chroma != 0 && !separate {
+		subW, subH := uint32(2), uint32(2) // 4:2:0
+		if chroma == 2 {
+			subH = 1
+		} else if chroma == 3 {
+			subW, subH = 1, 1
+		}
+		cropX, cropY = subW, subH*(2-frameMbsOnly)
+	}
+	ci.Width = int(wmbs*16 - cropX*(cl+cr))
+	ci.Height = int((2-frameMbsOnly)*hmaps*16 - cropY*(ct+cb))
+	ci.Profile = getH264ProfileName(profile, constraint)
+	ci.Level = fmt.Sprintf("%d.%d", level/10, level%10)
+
+	if r.flag() { // vui_parameters_present_flag
+		if r.flag() { // aspect_ratio_info_present_flag
+			if r.u(8) == 255 { // Extended_SAR
+				r.skip(32)
+			}
+		}
+		if r.flag() { // overscan_info_present_flag
+			r.skip(1)
+		}
+		if r.flag() { // video_signal_type_present_flag
+			r.skip(4)
+			if r.flag() { // colour_description_present_flag
+				r.skip(24)
+			}
+		}
+		if r.flag() { // chroma_loc_info_present_flag
+			r.ue()
+			r.ue()
+		}
+		if r.flag() { // timing_info_present_flag
+			tick := r.u(32)
+			scale := r.u(32)
+			if r.err == nil && tick > 0 {
+				ci.FPS = float64(scale) / float64(2*tick)
+			}
+		}
+	}
+	return ci, nil
+}
+
+func getH264ProfileName(profile, constraint uint32) (name string) {
+	switch profile {
+	case 66:
+		name = "Baseline"
+		if constraint&0x40 != 0 {
+			name = "Constrained Baseline"
+		}
+	case 77:
+		name = "Main"
+	case 88:
+		name = "Extended"
+	case 100:
+		name = "High"
+	case 110:
+		name = "High 10"
+	case 122:
+		name = "High 4:2:2"
+	case 244:
+		name = "High 4:4:4"
+	default:
+		name = fmt.Sprintf("%d", profile)
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// H.265 VPS/SPS (ITU-T H.265 7.3.2.1, 7.3.2.2)
+// ---------------------------------------------------------------------------------
+func parseH265ProfileTierLevel(r *bitReader, maxSubLayersMinus1 uint32) (profile, level uint32) {
+	r.skip(2) // general_profile_space
+	r.skip(1) // general_tier_flag
+	profile = r.u(5)
+	r.skip(32) // general_profile_compatibility_flag[32]
+	r.skip(48) // progressive, interlaced, non_packed, frame_only + 43 bits + 1 bit
+	level = r.u(8)
+
+	profilePresent := make([]bool, maxSubLayersMinus1)
+	levelPresent := make([]bool, maxSubLayersMinus1)
+	for i := range profilePresent {
+		profilePresent[i] = r.flag()
+		levelPresent[i] = r.flag()
+	}
+	if maxSubLayersMinus1 > 0 {
+		for i := maxSubLayersMinus1; i < 8; i++ {
+			r.skip(2) // reserved_zero_2bits
+		}
+	}
+	for i := range profilePresent {
+		if profilePresent[i] {
+			r.skip(88)
+		}
+		if levelPresent[i] {
+			r.skip(8)
+		}
+	}
+	return
+}
+
+func parseH265VPS(nal []byte) (ci CodecInfo, err error) {
+	if len(nal) < 3 {
+		return ci, fmt.Errorf("short vps")
+	}
+	r := &bitReader{data: unescapeRBSP(nal[2:])}
+
+	r.skip(4) // vps_video_parameter_set_id
+	r.skip(2) // vps_base_layer_internal_flag, vps_base_layer_available_flag
+	r.skip(6) // vps_max_layers_minus1
+	maxSub := r.u(3)
+	r.skip(1)  // vps_temporal_id_nesting_flag
+	r.skip(16) // vps_reserved_0xffff_16bits
+	parseH265ProfileTierLevel(r, maxSub)
+
+	ordering := r.flag() // vps_sub_layer_ordering_info_present_flag
+	i := maxSub
+	if ordering {
+		i = 0
+	}
+	for ; i <= maxSub && r.err == nil; i++ {
+		r.ue()
+		r.ue()
+		r.ue()
+	}
+	maxLayerID := r.u(6)
+	numSets := r.ue()
+	for i := uint32(1); i <= numSets && r.err == nil; i++ {
+		r.skip(int(maxLayerID) + 1) // layer_id_included_flag
+	}
+	if r.flag() { // vps_timing_info_present_flag
+		tick := r.u(32)
+		scale := r.u(32)
+		if r.err == nil && tick > 0 {
+			ci.FPS = float64(scale) / float64(tick)
+		}
+	}
+	return ci, r.err
+}
+
+func parseH265SPS(nal []byte) (ci CodecInfo, err error) {
+	if len(nal) < 3 {
+		return ci, fmt.Errorf("short sps")
+	}
+	r := &bitReader{data: unescapeRBSP(nal[2:])}
+
+	r.skip(4) // sps_video_parameter_set_id
+	maxSub := r.u(3)
+	r.skip(1) // sps_temporal_id_nesting_flag
+	profile, level := parseH265ProfileTierLevel(r, maxSub)
+
+	r.ue() // sps_seq_parameter_set_id
+	chroma := r.ue()
+	separate := false
+	if chroma == 3 {
+		separate = r.flag()
+	}
+	width := r.ue()
+	height := r.ue()
+	if r.flag() { // conformance_window_flag
+		subW, subH := uint32(1), uint32(1)
+		if !separate && (chroma == 1 || chroma == 2) {
+			subW = 2
+			if chroma == 1 {
+				subH = 2
+			}
+		}
+		cl, cr, ct, cb := r.ue(), r.ue(), r.ue(), r.ue()
+		width -= subW * (cl + cr)
+		height -= subH * (ct + cb)
+	}
+	if r.err != nil {
+		return ci, r.err
+	}
+
+	ci.Width, ci.Height = int(width), int(height)
+	switch profile {
+	case 1:
+		ci.Profile = "Main"
+	case 2:
+		ci.Profile = "Main 10"
+	case 3:
+		ci.Profile = "Main Still Picture"
+	case 4:
+		ci.Profile = "Range Extensions"
+	default:
+		ci.Profile = fmt.Sprintf("%d", profile)
+	}
+	ci.Level = fmt.Sprintf("%d.%d", level/30, (level%30)/3)
+	return ci, nil
+}
+
+// ---------------------------------------------------------------------------------
+// AV1 OBUs (AV1 bitstream spec 5.3, 5.5), fkey is true on a sequence header or key frame
+// ---------------------------------------------------------------------------------
+func parseAV1Frame(data []byte) (fkey bool, ci CodecInfo) {
+	for len(data) > 0 {
+		hdr := data[0]
+		otype := (hdr >> 3) & 0x0f
+		n := 1
+		if hdr&0x04 != 0 { // obu_extension_flag
+			n++
+		}
+		if n > len(data) {
+			return
+		}
+		size := len(data) - n
+		if hdr&0x02 != 0 { // obu_has_size_field, leb128
+			v, m := uint64(0), 0
+			for ; m < 8 && n+m < len(data); m++ {
+				v |= uint64(data[n+m]&0x7f) << (7 * uint(m))
+				if data[n+m]&0x80 == 0 {
+					break
+				}
+			}
+			n += m + 1
+			if n > len(data) || v > uint64(len(data)-n) {
+				return
+			}
+			size = int(v)
+		}
+		payload := data[n : n+size]
+		data = data[n+size:]
+
+		switch otype {
+		case 1: // OBU_SEQUENCE_HEADER
 			fkey = true
+			ci = parseAV1SequenceHeader(payload)
+		case 3, 6: // OBU_FRAME_HEADER, OBU_FRAME
+			if len(payload) > 0 && payload[0]&0x80 == 0 && (payload[0]>>5)&0x03 == 0 {
+				fkey = true // show_existing_frame == 0 && frame_type == KEY_FRAME
+			}
+			return
+		}
+	}
+	return
+}
+
+func parseAV1SequenceHeader(payload []byte) (ci CodecInfo) {
+	r := &bitReader{data: payload}
+
+	profile := r.u(3)
+	r.skip(1) // still_picture
+	reduced := r.flag()
+	level := uint32(0)
+	if reduced {
+		level = r.u(5)
+	} else {
+		decoderModel := false
+		bufferDelayLen := uint32(0)
+		if r.flag() { // timing_info_present_flag
+			tick := r.u(32)
+			scale := r.u(32)
+			if r.flag() { // equal_picture_interval
+				r.uvlc()
+			}
+			if tick > 0 {
+				ci.FPS = float64(scale) / float64(tick)
+			}
+			decoderModel = r.flag()
+			if decoderModel {
+				bufferDelayLen = r.u(5) + 1
+				r.skip(32) // num_units_in_decoding_tick
+				r.skip(10) // buffer_removal_time_length_minus_1, frame_presentation_time_length_minus_1
+			}
 		}
-	case "vp8", "vp9":
-		fkey = (data[0]&0x1 == 0)
+		initialDelay := r.flag()
+		cnt := r.u(5) + 1
+		for i := uint32(0); i < cnt && r.err == nil; i++ {
+			r.skip(12) // operating_point_idc
+			lv := r.u(5)
+			if i == 0 {
+				level = lv
+			}
+			if lv > 7 {
+				r.skip(1) // seq_tier
+			}
+			if decoderModel && r.flag() {
+				r.skip(int(2*bufferDelayLen) + 1)
+			}
+			if initialDelay && r.flag() {
+				r.skip(4)
+			}
+		}
+	}
+	wbits := int(r.u(4)) + 1
+	hbits := int(r.u(4)) + 1
+	width := r.u(wbits) + 1
+	height := r.u(hbits) + 1
+	if r.err != nil {
+		return CodecInfo{}
+	}
+
+	ci.Width, ci.Height = int(width), int(height)
+	switch profile {
+	case 0:
+		ci.Profile = "Main"
+	case 1:
+		ci.Profile = "High"
+	case 2:
+		ci.Profile = "Professional"
 	default:
-		log.Println("unknown codec:", codec)
-		fkey = false
+		ci.Profile = fmt.Sprintf("%d", profile)
+	}
+	ci.Level = fmt.Sprintf("%d.%d", 2+(level>>2), level&3)
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// VP8 frame header (RFC 6386 9.1)
+// ---------------------------------------------------------------------------------
+func parseVP8Info(data []byte) (ci CodecInfo, err error) {
+	if len(data) < 10 || data[0]&0x1 != 0 {
+		return ci, fmt.Errorf("not vp8 key frame")
+	}
+	if data[3] != 0x9d || data[4] != 0x01 || data[5] != 0x2a {
+		return ci, fmt.Errorf("invalid vp8 start code")
 	}
+	ci.Profile = fmt.Sprintf("%d", (data[0]>>1)&0x07)
+	ci.Width = int(binary.LittleEndian.Uint16(data[6:]) & 0x3fff)
+	ci.Height = int(binary.LittleEndian.Uint16(data[8:]) & 0x3fff)
 	return
 }
 
+// ---------------------------------------------------------------------------------
+// VP9 uncompressed header (VP9 bitstream spec 6.2)
+// ---------------------------------------------------------------------------------
+func parseVP9Header(data []byte) (fkey bool, ci CodecInfo) {
+	r := &bitReader{data: data}
+	if r.u(2) != 2 { // frame_marker
+		return
+	}
+	low := r.u(1)
+	profile := r.u(1)<<1 | low
+	if profile == 3 {
+		r.skip(1) // reserved_zero
+	}
+	if r.flag() { // show_existing_frame
+		return
+	}
+	if r.u(1) != 0 || r.err != nil { // frame_type: KEY_FRAME(0)
+		return
+	}
+	fkey = true
+
+	r.skip(2)                // show_frame, error_resilient_mode
+	if r.u(24) != 0x498342 { // frame_sync_code
+		return
+	}
+	if profile >= 2 {
+		r.skip(1) // ten_or_twelve_bit
+	}
+	if r.u(3) != 7 { // color_space != CS_RGB
+		r.skip(1) // color_range
+		if profile == 1 || profile == 3 {
+			r.skip(3) // subsampling_x, subsampling_y, reserved_zero
+		}
+	} else if profile == 1 || profile == 3 {
+		r.skip(1) // reserved_zero
+	}
+	width := r.u(16) + 1
+	height := r.u(16) + 1
+	if r.err != nil {
+		return
+	}
+	ci.Profile = fmt.Sprintf("%d", profile)
+	ci.Width, ci.Height = int(width), int(height)
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// JPEG SOFn marker segment
+// ---------------------------------------------------------------------------------
+func parseJPEGInfo(data []byte) (ci CodecInfo, err error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return ci, fmt.Errorf("not jpeg")
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return ci, fmt.Errorf("invalid jpeg marker at %d", i)
+		}
+		marker := data[i+1]
+		if marker == 0xff { // fill byte
+			i++
+			continue
+		}
+		if marker == 0xd8 || (marker >= 0xd0 && marker <= 0xd7) || marker == 0x01 {
+			i += 2
+			continue
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker >= 0xc0 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc {
+			if i+9 > len(data) {
+				break
+			}
+			ci.Height = int(binary.BigEndian.Uint16(data[i+5:]))
+			ci.Width = int(binary.BigEndian.Uint16(data[i+7:]))
+			switch marker {
+			case 0xc0:
+				ci.Profile = "Baseline"
+			case 0xc2:
+				ci.Profile = "Progressive"
+			default:
+				ci.Profile = fmt.Sprintf("SOF%d", marker-0xc0)
+			}
+			return ci, nil
+		}
+		if marker == 0xda || marker == 0xd9 { // SOS, EOI
+			break
+		}
+		i += 2 + size
+	}
+	return ci, fmt.Errorf("no jpeg frame header")
+}
+
 //=================================================================================
@@ -0,0 +1,234 @@
+// =================================================================================
+// Filename: util-codec_test.go
+// Function: Test functions for util-codec.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// ---------------------------------------------------------------------------------
+// bitWriter makes the test bitstreams, counterpart of bitReader
+type bitWriter struct {
+	data []byte
+	n    int
+}
+
+func (d *bitWriter) u(n int, v uint32) {
+	for i := n - 1; i >= 0; i-- {
+		if d.n%8 == 0 {
+			d.data = append(d.data, 0)
+		}
+		if (v>>uint(i))&1 == 1 {
+			d.data[d.n/8] |= 1 << (7 - uint(d.n%8))
+		}
+		d.n++
+	}
+}
+
+func (d *bitWriter) ue(v uint32) {
+	v++
+	n := 0
+	for t := v; t > 1; t >>= 1 {
+		n++
+	}
+	d.u(n, 0)
+	d.u(n+1, v)
+}
+
+func (d *bitWriter) bytes() []byte {
+	d.u(1, 1) // rbsp_stop_one_bit
+	return d.data
+}
+
+// 1920x1080 (1088 cropped) high profile, level 4.0, 30fps
+func makeH264SPS() []byte {
+	w := &bitWriter{}
+	w.u(8, 0x67) // nal header
+	w.u(8, 100)  // profile_idc
+	w.u(8, 0)    // constraint flags
+	w.u(8, 40)   // level_idc
+	w.ue(0)      // seq_parameter_set_id
+	w.ue(1)      // chroma_format_idc
+	w.ue(0)      // bit_depth_luma_minus8
+	w.ue(0)      // bit_depth_chroma_minus8
+	w.u(1, 0)    // qpprime_y_zero_transform_bypass_flag
+	w.u(1, 0)    // seq_scaling_matrix_present_flag
+	w.ue(0)      // log2_max_frame_num_minus4
+	w.ue(0)      // pic_order_cnt_type
+	w.ue(2)      // log2_max_pic_order_cnt_lsb_minus4
+	w.ue(4)      // max_num_ref_frames
+	w.u(1, 0)    // gaps_in_frame_num_value_allowed_flag
+	w.ue(119)    // pic_width_in_mbs_minus1
+	w.ue(67)     // pic_height_in_map_units_minus1
+	w.u(1, 1)    // frame_mbs_only_flag
+	w.u(1, 1)    // direct_8x8_inference_flag
+	w.u(1, 1)    // frame_cropping_flag
+	w.ue(0)
+	w.ue(0)
+	w.ue(0)
+	w.ue(4)     // bottom: 4 * 2 = 8 lines
+	w.u(1, 1)   // vui_parameters_present_flag
+	w.u(1, 0)   // aspect_ratio_info_present_flag
+	w.u(1, 0)   // overscan_info_present_flag
+	w.u(1, 0)   // video_signal_type_present_flag
+	w.u(1, 0)   // chroma_loc_info_present_flag
+	w.u(1, 1)   // timing_info_present_flag
+	w.u(32, 1)  // num_units_in_tick
+	w.u(32, 60) // time_scale
+	w.u(1, 1)   // fixed_frame_rate_flag
+	return w.bytes()
+}
+
+// ---------------------------------------------------------------------------------
+func TestSplitNALUnits(t *testing.T) {
+	sps := makeH264SPS()
+	idr := []byte{0x65, 0x88, 0x84}
+
+	// annex-b with 4 and 3 byte start codes
+	annexb := append([]byte{0, 0, 0, 1}, sps...)
+	annexb = append(annexb, 0, 0, 1)
+	annexb = append(annexb, idr...)
+	nals, format := SplitNALUnits(annexb)
+	if format != "annexb" || len(nals) != 2 || nals[1][0] != 0x65 || len(nals[0]) != len(sps) {
+		t.Fatal("invalid annexb split:", format, len(nals))
+	}
+
+	// avcc with 4 byte length prefix
+	avcc := binary.BigEndian.AppendUint32(nil, uint32(len(sps)))
+	avcc = append(avcc, sps...)
+	avcc = binary.BigEndian.AppendUint32(avcc, uint32(len(idr)))
+	avcc = append(avcc, idr...)
+	nals, format = SplitNALUnits(avcc)
+	if format != "avcc" || len(nals) != 2 || nals[1][0] != 0x65 {
+		t.Fatal("invalid avcc split:", format, len(nals))
+	}
+	if !IsKeyFrame("h264", avcc) {
+		t.Fatal("avcc key frame is not detected")
+	}
+
+	// avcc of the length like the start code, 0x000001xx
+	slice := append([]byte{0x41}, bytes.Repeat([]byte{0x9a}, 299)...)
+	avcc = binary.BigEndian.AppendUint32(nil, uint32(len(slice)))
+	avcc = append(avcc, slice...)
+	nals, format = SplitNALUnits(avcc)
+	if format != "avcc" || len(nals) != 1 || len(nals[0]) != 300 {
+		t.Fatal("invalid avcc split of long nal:", format, len(nals))
+	}
+}
+
+func TestIsKeyFrameShort(t *testing.T) {
+	// must not panic on short or empty data
+	for _, codec := range []string{"jpeg", "h264", "h265", "vp8", "vp9", "av1", "none"} {
+		for _, data := range [][]byte{nil, {0}, {0, 0, 1}, {0, 0, 0, 1}, {0, 0, 0, 1, 0x65}} {
+			IsKeyFrame(codec, data)
+			ParseCodecInfo(codec, data)
+		}
+	}
+	if !IsKeyFrame("h264", []byte{0, 0, 1, 0x65, 0x88}) {
+		t.Fatal("3 byte start code idr is not detected")
+	}
+	if IsKeyFrame("h264", []byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 1, 0x41, 0x9a}) {
+		t.Fatal("delta frame is detected as key")
+	}
+	if !IsKeyFrame("h265", []byte{0, 0, 0, 1, 0x26, 0x01, 0xaf}) { // IDR_W_RADL
+		t.Fatal("h265 idr is not detected")
+	}
+	if IsKeyFrame("h264", []byte{0, 0, 0, 1, 0x67, 0x42}) || !IsParamSet("h264", []byte{0, 0, 0, 1, 0x68, 0xce}) {
+		t.Fatal("parameter set is detected as key")
+	}
+	if IsKeyFrame("h265", []byte{0, 0, 0, 1, 0x40, 0x01}) || !IsParamSet("h265", []byte{0, 0, 0, 1, 0x40, 0x01}) {
+		t.Fatal("h265 vps is detected as key")
+	}
+}
+
+func TestParseH264SPS(t *testing.T) {
+	data := append([]byte{0, 0, 0, 1}, makeH264SPS()...)
+	ci, ok := ParseCodecInfo("h264", data)
+	if !ok {
+		t.Fatal("sps is not parsed")
+	}
+	if ci.Width != 1920 || ci.Height != 1080 || ci.Profile != "High" || ci.Level != "4.0" || ci.FPS != 30 {
+		t.Fatal("invalid sps info:", ci)
+	}
+}
+
+func TestParseH265SPS(t *testing.T) {
+	w := &bitWriter{}
+	w.u(16, 0x4201) // nal header: SPS
+	w.u(4, 0)       // sps_video_parameter_set_id
+	w.u(3, 0)       // sps_max_sub_layers_minus1
+	w.u(1, 1)       // sps_temporal_id_nesting_flag
+	w.u(2, 0)       // general_profile_space
+	w.u(1, 0)       // general_tier_flag
+	w.u(5, 1)       // general_profile_idc: Main
+	w.u(32, 0x60000000)
+	w.u(24, 0)
+	w.u(24, 0)
+	w.u(8, 123) // general_level_idc: 4.1
+	w.ue(0)     // sps_seq_parameter_set_id
+	w.ue(1)     // chroma_format_idc
+	w.ue(1280)  // pic_width_in_luma_samples
+	w.ue(720)   // pic_height_in_luma_samples
+	w.u(1, 0)   // conformance_window_flag
+
+	ci, ok := ParseCodecInfo("h265", append([]byte{0, 0, 1}, w.bytes()...))
+	if !ok || ci.Width != 1280 || ci.Height != 720 || ci.Profile != "Main" || ci.Level != "4.1" {
+		t.Fatal("invalid h265 sps info:", ok, ci)
+	}
+}
+
+func TestParseVPxAV1JPEG(t *testing.T) {
+	// vp8 key frame: frame tag, start code, 640x480
+	vp8 := []byte{0x50, 0x42, 0x00, 0x9d, 0x01, 0x2a, 0x80, 0x02, 0xe0, 0x01}
+	if ci, ok := ParseCodecInfo("vp8", vp8); !ok || ci.Width != 640 || ci.Height != 480 {
+		t.Fatal("invalid vp8 info:", ok, ci)
+	}
+
+	// vp9 profile 0 key frame, 320x240
+	w := &bitWriter{}
+	w.u(2, 2)         // frame_marker
+	w.u(2, 0)         // profile
+	w.u(1, 0)         // show_existing_frame
+	w.u(1, 0)         // frame_type: key
+	w.u(2, 2)         // show_frame, error_resilient_mode
+	w.u(24, 0x498342) // sync code
+	w.u(3, 1)         // color_space
+	w.u(1, 0)         // color_range
+	w.u(16, 319)
+	w.u(16, 239)
+	if ci, ok := ParseCodecInfo("vp9", w.bytes()); !ok || ci.Width != 320 || ci.Height != 240 {
+		t.Fatal("invalid vp9 info:", ok, ci)
+	}
+
+	// av1 sequence header obu, reduced still picture header, 1280x720
+	w = &bitWriter{}
+	w.u(3, 0)  // seq_profile
+	w.u(1, 1)  // still_picture
+	w.u(1, 1)  // reduced_still_picture_header
+	w.u(5, 8)  // seq_level_idx: 4.0
+	w.u(4, 10) // frame_width_bits_minus_1
+	w.u(4, 9)  // frame_height_bits_minus_1
+	w.u(11, 1279)
+	w.u(10, 719)
+	payload := w.bytes()
+	obu := append([]byte{0x0a, byte(len(payload))}, payload...)
+	ci, ok := ParseCodecInfo("av1", obu)
+	if !ok || !IsKeyFrame("av1", obu) || ci.Width != 1280 || ci.Height != 720 || ci.Level != "4.0" {
+		t.Fatal("invalid av1 info:", ok, ci)
+	}
+
+	// jpeg with SOF0, 64x48
+	jpeg := []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x04, 0x00, 0x00,
+		0xff, 0xc0, 0x00, 0x11, 0x08, 0x00, 0x30, 0x00, 0x40, 0x03}
+	if ci, ok := ParseCodecInfo("jpeg", jpeg); !ok || ci.Width != 64 || ci.Height != 48 || ci.Profile != "Baseline" {
+		t.Fatal("invalid jpeg info:", ok, ci)
+	}
+}
+
+//=================================================================================
@@ -0,0 +1,158 @@
+// =================================================================================
+// Filename: util-image.go
+// Function: Image scaling of JPEG frames by the standard image packages
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+)
+
+// ---------------------------------------------------------------------------------
+const (
+	IMAGE_MAX_PIXELS = 8192 * 8192 // not to decode a bomb
+)
+
+// GetFitSize returns the size fit into the box of w x h keeping the aspect ratio,
+// not enlarged, 0 of w or h for any
+func GetFitSize(sw, sh, w, h int) (dw, dh int) {
+	dw, dh = sw, sh
+	if w > 0 && dw > w {
+		dw, dh = w, max(sh*w/sw, 1)
+	}
+	if h > 0 && dh > h {
+		dw, dh = max(sw*h/sh, 1), h
+	}
+	return
+}
+
+// RescaleJPEG decodes, resizes into the box and encodes the jpeg in the quality
+func RescaleJPEG(data []byte, w, h, quality int) (out []byte, err error) {
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	if cfg.Width*cfg.Height > IMAGE_MAX_PIXELS {
+		err = fmt.Errorf("too large image: %dx%d", cfg.Width, cfg.Height)
+		return
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	dw, dh := GetFitSize(cfg.Width, cfg.Height, w, h)
+	if dw != cfg.Width || dh != cfg.Height {
+		img = ScaleImage(img, dw, dh)
+	}
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	out = buf.Bytes()
+	return
+}
+
+// ScaleImage scales the image into w x h by the area average, for the downscale
+//   - YCbCr and Gray scale their planes directly, the others by RGBA
+func ScaleImage(src image.Image, w, h int) image.Image {
+	r := src.Bounds()
+	switch s := src.(type) {
+	case *image.YCbCr:
+		d := image.NewYCbCr(image.Rect(0, 0, w, h), s.SubsampleRatio)
+		scalePlane(d.Y, d.YStride, w, h, s.Y[s.YOffset(r.Min.X, r.Min.Y):], s.YStride, r.Dx(), r.Dy(), 1)
+		scw, sch := getChromaSize(r, s.SubsampleRatio)
+		dcw, dch := getChromaSize(d.Rect, d.SubsampleRatio)
+		coff := s.COffset(r.Min.X, r.Min.Y)
+		scalePlane(d.Cb, d.CStride, dcw, dch, s.Cb[coff:], s.CStride, scw, sch, 1)
+		scalePlane(d.Cr, d.CStride, dcw, dch, s.Cr[coff:], s.CStride, scw, sch, 1)
+		return d
+	case *image.Gray:
+		d := image.NewGray(image.Rect(0, 0, w, h))
+		scalePlane(d.Pix, d.Stride, w, h, s.Pix[s.PixOffset(r.Min.X, r.Min.Y):], s.Stride, r.Dx(), r.Dy(), 1)
+		return d
+	}
+	s := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(s, s.Rect, src, r.Min, draw.Src)
+	d := image.NewRGBA(image.Rect(0, 0, w, h))
+	scalePlane(d.Pix, d.Stride, w, h, s.Pix, s.Stride, r.Dx(), r.Dy(), 4)
+	return d
+}
+
+// DecodeGrayJPEG decodes the jpeg into the gray image of w x h, by the luma plane if YCbCr
+func DecodeGrayJPEG(data []byte, w, h int) (g *image.Gray, err error) {
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	if cfg.Width*cfg.Height > IMAGE_MAX_PIXELS {
+		err = fmt.Errorf("too large image: %dx%d", cfg.Width, cfg.Height)
+		return
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	r := img.Bounds()
+	var src *image.Gray
+	switch s := img.(type) {
+	case *image.YCbCr:
+		src = &image.Gray{Pix: s.Y[s.YOffset(r.Min.X, r.Min.Y):], Stride: s.YStride, Rect: image.Rect(0, 0, r.Dx(), r.Dy())}
+	case *image.Gray:
+		src = s
+	default:
+		src = image.NewGray(image.Rect(0, 0, r.Dx(), r.Dy()))
+		draw.Draw(src, src.Rect, img, r.Min, draw.Src)
+	}
+	g = ScaleImage(src, w, h).(*image.Gray)
+	return
+}
+
+// size of the chroma planes, the same as the image package
+func getChromaSize(r image.Rectangle, ratio image.YCbCrSubsampleRatio) (w, h int) {
+	w, h = r.Dx(), r.Dy()
+	switch ratio {
+	case image.YCbCrSubsampleRatio422:
+		w = (r.Max.X+1)/2 - r.Min.X/2
+	case image.YCbCrSubsampleRatio420:
+		w, h = (r.Max.X+1)/2-r.Min.X/2, (r.Max.Y+1)/2-r.Min.Y/2
+	case image.YCbCrSubsampleRatio440:
+		h = (r.Max.Y+1)/2 - r.Min.Y/2
+	case image.YCbCrSubsampleRatio411:
+		w = (r.Max.X+3)/4 - r.Min.X/4
+	case image.YCbCrSubsampleRatio410:
+		w, h = (r.Max.X+3)/4-r.Min.X/4, (r.Max.Y+1)/2-r.Min.Y/2
+	}
+	return
+}
+
+// scale the plane of n bytes per pixel by averaging the source area of each pixel
+func scalePlane(dst []byte, dstride, dw, dh int, src []byte, sstride, sw, sh, n int) {
+	sum := make([]int, n)
+	for y := 0; y < dh; y++ {
+		y0 := y * sh / dh
+		y1 := max((y+1)*sh/dh, y0+1)
+		for x := 0; x < dw; x++ {
+			x0 := x * sw / dw
+			x1 := max((x+1)*sw/dw, x0+1)
+			clear(sum)
+			for sy := y0; sy < y1; sy++ {
+				row := src[sy*sstride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < n; c++ {
+						sum[c] += int(row[sx*n+c])
+					}
+				}
+			}
+			cnt := (y1 - y0) * (x1 - x0)
+			for c := 0; c < n; c++ {
+				dst[y*dstride+x*n+c] = byte((sum[c] + cnt/2) / cnt)
+			}
+		}
+	}
+}
+
+//=================================================================================
@@ -0,0 +1,272 @@
+// =================================================================================
+// Filename: util-mavlink.go
+// Function: MAVLink v1/v2 parser and decoder of telemetry messages
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// ---------------------------------------------------------------------------------
+// MAVLink frames
+//   - v1: 0xFE + len + seq + sysid + compid + msgid(1) + payload + crc(2)
+//   - v2: 0xFD + len + incompat + compat + seq + sysid + compid + msgid(3) + payload + crc(2) [+ signature(13)]
+//
+// The crc is X.25 over the bytes after the magic with the crc extra of message,
+// so only the messages known here are validated and the others are skipped.
+// ---------------------------------------------------------------------------------
+const (
+	MAVLINK_MAGIC_V1      = 0xFE
+	MAVLINK_MAGIC_V2      = 0xFD
+	MAVLINK_HEAD_LEN_V1   = 6
+	MAVLINK_HEAD_LEN_V2   = 10
+	MAVLINK_SIGN_LEN      = 13
+	MAVLINK_FLAG_SIGNED   = 0x01
+	MAVLINK_MAX_FRAME_LEN = MAVLINK_HEAD_LEN_V2 + 255 + 2 + MAVLINK_SIGN_LEN
+
+	MAVLINK_MSG_HEARTBEAT           = 0
+	MAVLINK_MSG_SYS_STATUS          = 1
+	MAVLINK_MSG_ATTITUDE            = 30
+	MAVLINK_MSG_GLOBAL_POSITION_INT = 33
+)
+
+// message length and crc extra of the messages decoded
+var mavMessageInfo = map[uint32]struct {
+	name  string
+	size  int
+	extra byte
+}{
+	MAVLINK_MSG_HEARTBEAT:           {"HEARTBEAT", 9, 50},
+	MAVLINK_MSG_SYS_STATUS:          {"SYS_STATUS", 31, 124},
+	MAVLINK_MSG_ATTITUDE:            {"ATTITUDE", 28, 39},
+	MAVLINK_MSG_GLOBAL_POSITION_INT: {"GLOBAL_POSITION_INT", 28, 104},
+}
+
+// MAV_STATE of HEARTBEAT
+var mavStateNames = []string{"uninit", "boot", "calibrating", "standby", "active",
+	"critical", "emergency", "poweroff", "termination"}
+
+func GetMavStateName(state uint8) string {
+	if int(state) < len(mavStateNames) {
+		return mavStateNames[state]
+	}
+	return "unknown"
+}
+
+// check if the state is a failsafe condition: critical, emergency, termination
+func IsMavFailsafeState(state uint8) bool {
+	return state == 5 || state == 6 || state == 8
+}
+
+// ---------------------------------------------------------------------------------
+type MavFrame struct {
+	Version int    `json:"version"`
+	Seq     uint8  `json:"seq"`
+	SysID   uint8  `json:"sys_id"`
+	CompID  uint8  `json:"comp_id"`
+	MsgID   uint32 `json:"msg_id"`
+	Payload []byte `json:"-"` // zero-filled to the message length if known
+}
+
+// MavCRC accumulates the X.25 crc of MAVLink
+func MavCRC(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		tmp := b ^ byte(crc)
+		tmp ^= tmp << 4
+		crc = crc>>8 ^ uint16(tmp)<<8 ^ uint16(tmp)<<3 ^ uint16(tmp)>>4
+	}
+	return crc
+}
+
+// EncodeMavFrame makes the frame of version 1 or 2 without the signature
+func EncodeMavFrame(version int, seq, sysid, compid uint8, msgid uint32, payload []byte) (frame []byte) {
+	if version == 1 {
+		frame = []byte{MAVLINK_MAGIC_V1, byte(len(payload)), seq, sysid, compid, byte(msgid)}
+	} else {
+		frame = []byte{MAVLINK_MAGIC_V2, byte(len(payload)), 0, 0, seq, sysid, compid,
+			byte(msgid), byte(msgid >> 8), byte(msgid >> 16)}
+	}
+	frame = append(frame, payload...)
+	crc := MavCRC(0xFFFF, frame[1:])
+	crc = MavCRC(crc, []byte{mavMessageInfo[msgid].extra})
+	return binary.LittleEndian.AppendUint16(frame, crc)
+}
+
+// ---------------------------------------------------------------------------------
+// MavParser splits the stream of slots into frames, keeping the partial frame
+type MavParser struct {
+	Frames  int `json:"frames"`  // number of valid frames
+	Unknown int `json:"unknown"` // frames of unknown messages, not validated
+	Errors  int `json:"errors"`  // crc errors
+	Skipped int `json:"skipped"` // bytes skipped to sync
+	// --- internal variables
+	buf []byte
+}
+
+// Parse returns the frames of known messages in data
+func (d *MavParser) Parse(data []byte) (frames []MavFrame) {
+	d.buf = append(d.buf, data...)
+	i := 0
+	for i < len(d.buf) {
+		magic := d.buf[i]
+		if magic != MAVLINK_MAGIC_V1 && magic != MAVLINK_MAGIC_V2 {
+			i++
+			d.Skipped++
+			continue
+		}
+		hlen := MAVLINK_HEAD_LEN_V1
+		if magic == MAVLINK_MAGIC_V2 {
+			hlen = MAVLINK_HEAD_LEN_V2
+		}
+		if len(d.buf)-i < hlen {
+			break
+		}
+		head := d.buf[i : i+hlen]
+		flen := hlen + int(head[1]) + 2
+		f := MavFrame{Version: 1, Seq: head[2], SysID: head[3], CompID: head[4], MsgID: uint32(head[5])}
+		if magic == MAVLINK_MAGIC_V2 {
+			if head[2]&MAVLINK_FLAG_SIGNED != 0 {
+				flen += MAVLINK_SIGN_LEN
+			}
+			f = MavFrame{Version: 2, Seq: head[4], SysID: head[5], CompID: head[6],
+				MsgID: uint32(head[7]) | uint32(head[8])<<8 | uint32(head[9])<<16}
+		}
+		if len(d.buf)-i < flen {
+			break
+		}
+		info, ok := mavMessageInfo[f.MsgID]
+		if !ok {
+			d.Unknown++
+			i += flen
+			continue
+		}
+		end := i + hlen + int(head[1])
+		crc := MavCRC(0xFFFF, d.buf[i+1:end])
+		crc = MavCRC(crc, []byte{info.extra})
+		if crc != binary.LittleEndian.Uint16(d.buf[end:]) {
+			d.Errors++
+			d.Skipped++
+			i++ // resync from the next byte
+			continue
+		}
+		f.Payload = make([]byte, max(info.size, int(head[1])))
+		copy(f.Payload, d.buf[i+hlen:end]) // v2 truncates the zeros at the end
+		frames = append(frames, f)
+		d.Frames++
+		i += flen
+	}
+	d.buf = append(d.buf[:0], d.buf[i:]...)
+	if len(d.buf) > MAVLINK_MAX_FRAME_LEN { // not possible for a valid frame
+		d.Skipped += len(d.buf)
+		d.buf = d.buf[:0]
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// decoded messages in the units of ground stations
+// ---------------------------------------------------------------------------------
+type MavHeartbeat struct {
+	Type       uint8  `json:"type"`      // MAV_TYPE
+	Autopilot  uint8  `json:"autopilot"` // MAV_AUTOPILOT
+	BaseMode   uint8  `json:"base_mode"`
+	CustomMode uint32 `json:"custom_mode"`
+	Status     string `json:"status"` // MAV_STATE
+	Armed      bool   `json:"armed"`
+	state      uint8
+}
+
+type MavSysStatus struct {
+	Voltage   float64 `json:"voltage"`   // V
+	Current   float64 `json:"current"`   // A, -1 if unknown
+	Battery   int     `json:"battery"`   // %, -1 if unknown
+	Load      float64 `json:"load"`      // %
+	DropRate  float64 `json:"drop_rate"` // % of communication
+	SensorsOK bool    `json:"sensors_ok"`
+}
+
+type MavAttitude struct {
+	TimeBoot   uint32  `json:"time_boot_ms"`
+	Roll       float64 `json:"roll"` // deg
+	Pitch      float64 `json:"pitch"`
+	Yaw        float64 `json:"yaw"`
+	RollSpeed  float64 `json:"roll_speed"` // deg/s
+	PitchSpeed float64 `json:"pitch_speed"`
+	YawSpeed   float64 `json:"yaw_speed"`
+}
+
+type MavPosition struct {
+	TimeBoot uint32  `json:"time_boot_ms"`
+	Lat      float64 `json:"lat"` // deg
+	Lon      float64 `json:"lon"`
+	Alt      float64 `json:"alt"`     // m, MSL
+	RelAlt   float64 `json:"rel_alt"` // m, above home
+	Vx       float64 `json:"vx"`      // m/s, north
+	Vy       float64 `json:"vy"`      // east
+	Vz       float64 `json:"vz"`      // down
+	Heading  float64 `json:"heading"` // deg, -1 if unknown
+}
+
+func getMavFloat(p []byte, off int) float64 {
+	return math.Round(float64(math.Float32frombits(binary.LittleEndian.Uint32(p[off:])))*1e6) / 1e6
+}
+
+func getMavDegree(p []byte, off int) float64 {
+	return math.Round(getMavFloat(p, off)*180/math.Pi*1e3) / 1e3
+}
+
+// DecodeMavMessage decodes the payload of the known message
+func DecodeMavMessage(f MavFrame) (name string, msg interface{}) {
+	p := f.Payload
+	switch f.MsgID {
+	case MAVLINK_MSG_HEARTBEAT:
+		m := MavHeartbeat{CustomMode: binary.LittleEndian.Uint32(p), Type: p[4], Autopilot: p[5],
+			BaseMode: p[6], state: p[7]}
+		m.Status = GetMavStateName(m.state)
+		m.Armed = m.BaseMode&0x80 != 0 // MAV_MODE_FLAG_SAFETY_ARMED
+		msg = m
+	case MAVLINK_MSG_SYS_STATUS:
+		m := MavSysStatus{
+			Voltage:  float64(binary.LittleEndian.Uint16(p[14:])) / 1000,
+			Current:  float64(int16(binary.LittleEndian.Uint16(p[16:]))) / 100,
+			Battery:  int(int8(p[30])),
+			Load:     float64(binary.LittleEndian.Uint16(p[12:])) / 10,
+			DropRate: float64(binary.LittleEndian.Uint16(p[18:])) / 100,
+		}
+		enabled := binary.LittleEndian.Uint32(p[4:])
+		m.SensorsOK = binary.LittleEndian.Uint32(p[8:])&enabled == enabled
+		if int16(binary.LittleEndian.Uint16(p[16:])) == -1 {
+			m.Current = -1
+		}
+		msg = m
+	case MAVLINK_MSG_ATTITUDE:
+		msg = MavAttitude{TimeBoot: binary.LittleEndian.Uint32(p),
+			Roll: getMavDegree(p, 4), Pitch: getMavDegree(p, 8), Yaw: getMavDegree(p, 12),
+			RollSpeed: getMavDegree(p, 16), PitchSpeed: getMavDegree(p, 20), YawSpeed: getMavDegree(p, 24)}
+	case MAVLINK_MSG_GLOBAL_POSITION_INT:
+		m := MavPosition{TimeBoot: binary.LittleEndian.Uint32(p),
+			Lat:    float64(int32(binary.LittleEndian.Uint32(p[4:]))) / 1e7,
+			Lon:    float64(int32(binary.LittleEndian.Uint32(p[8:]))) / 1e7,
+			Alt:    float64(int32(binary.LittleEndian.Uint32(p[12:]))) / 1000,
+			RelAlt: float64(int32(binary.LittleEndian.Uint32(p[16:]))) / 1000,
+			Vx:     float64(int16(binary.LittleEndian.Uint16(p[20:]))) / 100,
+			Vy:     float64(int16(binary.LittleEndian.Uint16(p[22:]))) / 100,
+			Vz:     float64(int16(binary.LittleEndian.Uint16(p[24:]))) / 100,
+		}
+		m.Heading = -1
+		if hdg := binary.LittleEndian.Uint16(p[26:]); hdg != math.MaxUint16 {
+			m.Heading = float64(hdg) / 100
+		}
+		msg = m
+	default:
+		return
+	}
+	name = mavMessageInfo[f.MsgID].name
+	return
+}
+
+//=================================================================================
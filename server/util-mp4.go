@@ -0,0 +1,535 @@
+// =================================================================================
+// Filename: util-mp4.go
+// Function: Fragmented MP4 (ISO-BMFF) muxer of H.264/H.265/Opus tracks
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// ---------------------------------------------------------------------------------
+const (
+	MP4_VIDEO_TIMESCALE   = 90000
+	MP4_AUDIO_TIMESCALE   = 48000 // opus is always 48kHz
+	MP4_MOVIE_TIMESCALE   = 1000
+	MP4_FRAGMENT_TIME     = time.Second      // fragment duration if no video track
+	MP4_FRAGMENT_MAX      = 10 * time.Second // max fragment duration waiting for the key frame
+	MP4_OPUS_PRESKIP      = 312
+	MP4_SAMPLE_KEY        = 0x02000000 // sample_depends_on=2
+	MP4_SAMPLE_NON_KEY    = 0x01010000 // sample_depends_on=1, sample_is_non_sync_sample=1
+	MP4_TRUN_FLAGS        = 0x000701   // data-offset, sample-duration, sample-size, sample-flags
+	MP4_TFHD_BASE_IS_MOOF = 0x020000
+)
+
+// min length of the parameter sets to build the config records, avcC and hvcC
+const (
+	MP4_MIN_SPS_H264 = 4  // header(1) + profile, constraint, level
+	MP4_MIN_SPS_H265 = 15 // header(2) + sub_layers(1) + profile_tier_level(12)
+	MP4_MIN_PARAM    = 2  // header of vps, pps
+)
+
+type mp4Sample struct {
+	data []byte
+	time time.Time
+	dur  uint32
+	key  bool
+}
+
+// ---------------------------------------------------------------------------------
+// MP4Track is a track of the muxer, its config is taken from the parameter sets in the stream
+// ---------------------------------------------------------------------------------
+type MP4Track struct {
+	ID        int    `json:"id"`
+	Codec     string `json:"codec"` // h264, h265, opus
+	Timescale uint32 `json:"timescale"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Channels  int    `json:"channels,omitempty"`
+	// --- internal variables
+	vps, sps, pps []byte
+	enabled       bool // written in the init segment
+	started       bool // the first sample is given
+	samples       []mp4Sample
+	pending       *mp4Sample // duration is known at the next sample
+	decodeTime    uint64
+	lastDur       uint32
+}
+
+func (d *MP4Track) isVideo() bool {
+	return d.Codec == "h264" || d.Codec == "h265"
+}
+
+// ready to write the sample entry
+func (d *MP4Track) isReady() bool {
+	switch d.Codec {
+	case "h264":
+		return d.sps != nil && d.pps != nil
+	case "h265":
+		return d.vps != nil && d.sps != nil && d.pps != nil
+	}
+	return true
+}
+
+// ticks of time t from the start in the timescale of track
+func (d *MP4Track) ticks(t, start time.Time) uint64 {
+	dt := t.Sub(start)
+	if dt < 0 {
+		dt = 0
+	}
+	return uint64(dt) * uint64(d.Timescale) / uint64(time.Second)
+}
+
+// keep the parameter set if valid, the malformed one is ignored to keep the last one
+func keepParamSet(dst *[]byte, nal []byte, minLen int) {
+	if len(nal) < minLen || len(nal) > 0xffff { // 16 bits length in the config record
+		log.Println("mp4 invalid parameter set:", len(nal))
+		return
+	}
+	*dst = append([]byte(nil), nal...)
+}
+
+// convert the access unit to length prefixed NAL units without parameter sets
+//   - returns the key flag and nil data if it has only parameter sets
+func (d *MP4Track) convertVideoSample(data []byte) (out []byte, key bool) {
+	nals, _ := SplitNALUnits(data)
+	for _, nal := range nals {
+		if len(nal) == 0 {
+			continue
+		}
+		if d.Codec == "h264" {
+			switch nal[0] & 0x1f {
+			case 7:
+				keepParamSet(&d.sps, nal, MP4_MIN_SPS_H264)
+				continue
+			case 8:
+				keepParamSet(&d.pps, nal, MP4_MIN_PARAM)
+				continue
+			case 9: // AUD
+				continue
+			case 5:
+				key = true
+			}
+		} else {
+			nut := (nal[0] >> 1) & 0x3f
+			switch nut {
+			case 32:
+				keepParamSet(&d.vps, nal, MP4_MIN_PARAM)
+				continue
+			case 33:
+				keepParamSet(&d.sps, nal, MP4_MIN_SPS_H265)
+				continue
+			case 34:
+				keepParamSet(&d.pps, nal, MP4_MIN_PARAM)
+				continue
+			case 35: // AUD
+				continue
+			}
+			if nut >= 16 && nut <= 23 {
+				key = true
+			}
+		}
+		out = binary.BigEndian.AppendUint32(out, uint32(len(nal)))
+		out = append(out, nal...)
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// MP4Muxer writes the init segment (ftyp, moov) and fragments (moof, mdat)
+//   - fragments are cut at the key frames of the first video track, or by MP4_FRAGMENT_TIME
+//   - the init segment is written at the first fragment, tracks not ready then are ignored
+//
+// ---------------------------------------------------------------------------------
+type MP4Muxer struct {
+	Tracks   []*MP4Track `json:"tracks"`
+	Size     int64       `json:"size"`
+	Fragment time.Duration
+	// --- internal variables
+	w         io.Writer
+	seq       uint32
+	inited    bool
+	tstart    time.Time
+	tfragment time.Time
+}
+
+func NewMP4Muxer(w io.Writer) (d *MP4Muxer) {
+	d = &MP4Muxer{w: w, Fragment: MP4_FRAGMENT_TIME}
+	return
+}
+
+// addTrack adds a track of the codec before the init segment, returns its id
+func (d *MP4Muxer) addTrack(codec string) (id int, err error) {
+	if d.inited {
+		err = fmt.Errorf("mp4 already initialized")
+		return
+	}
+	t := &MP4Track{ID: len(d.Tracks) + 1, Codec: codec}
+	switch codec {
+	case "h264", "h265":
+		t.Timescale = MP4_VIDEO_TIMESCALE
+	case "opus":
+		t.Timescale = MP4_AUDIO_TIMESCALE
+		t.Channels = 2
+	default:
+		err = fmt.Errorf("not supported codec in mp4: %s", codec)
+		return
+	}
+	d.Tracks = append(d.Tracks, t)
+	return t.ID, nil
+}
+
+func (d *MP4Muxer) getTrack(id int) *MP4Track {
+	if id < 1 || id > len(d.Tracks) {
+		return nil
+	}
+	return d.Tracks[id-1]
+}
+
+// the lead track to cut the fragments, the first video track if any
+func (d *MP4Muxer) getLeadTrack() (t *MP4Track) {
+	for _, v := range d.Tracks {
+		if v.isVideo() && (!d.inited || v.enabled) {
+			return v
+		}
+	}
+	if len(d.Tracks) > 0 {
+		t = d.Tracks[0]
+	}
+	return
+}
+
+// writeSample buffers the frame of the track at time t, cutting the fragment if needed
+func (d *MP4Muxer) writeSample(id int, data []byte, t time.Time) (err error) {
+	tr := d.getTrack(id)
+	if tr == nil {
+		return fmt.Errorf("invalid mp4 track: %d", id)
+	}
+	if d.inited && !tr.enabled {
+		return
+	}
+
+	key := true
+	if tr.isVideo() {
+		data, key = tr.convertVideoSample(data)
+		if len(data) == 0 {
+			return
+		}
+		if !tr.started && (!key || !tr.isReady()) { // start at the key frame
+			return
+		}
+		if tr.Width == 0 {
+			if ci, ok := ParseCodecInfo(tr.Codec, append([]byte{0, 0, 0, 1}, tr.sps...)); ok {
+				tr.Width, tr.Height = ci.Width, ci.Height
+			}
+		}
+	} else if tr.Codec == "opus" && !tr.started && len(data) > 0 {
+		if data[0]&0x04 == 0 { // stereo flag of TOC
+			tr.Channels = 1
+		}
+	}
+
+	if d.tstart.IsZero() {
+		d.tstart, d.tfragment = t, t
+	}
+	elapsed := t.Sub(d.tfragment)
+	if (tr == d.getLeadTrack() && key && tr.started && (tr.isVideo() || elapsed >= d.Fragment)) ||
+		elapsed >= MP4_FRAGMENT_MAX {
+		err = d.flushFragment()
+		if err != nil {
+			return
+		}
+		d.tfragment = t
+	}
+
+	if !tr.started {
+		tr.started = true
+		tr.decodeTime = tr.ticks(t, d.tstart)
+	}
+	if p := tr.pending; p != nil {
+		dt := int64(tr.ticks(t, d.tstart)) - int64(tr.ticks(p.time, d.tstart))
+		p.dur = uint32(max(dt, 1))
+		tr.lastDur = p.dur
+		tr.samples = append(tr.samples, *p)
+	}
+	tr.pending = &mp4Sample{data: append([]byte(nil), data...), time: t, key: key}
+	return
+}
+
+// Close writes the pending samples in the last fragment
+func (d *MP4Muxer) Close() (err error) {
+	for _, tr := range d.Tracks {
+		if p := tr.pending; p != nil {
+			p.dur = tr.lastDur
+			if p.dur == 0 {
+				p.dur = tr.Timescale / 30
+				if tr.Codec == "opus" {
+					p.dur = MP4_AUDIO_TIMESCALE / 50 // 20ms
+				}
+			}
+			tr.samples = append(tr.samples, *p)
+			tr.pending = nil
+		}
+	}
+	return d.flushFragment()
+}
+
+func (d *MP4Muxer) write(data []byte) (err error) {
+	n, err := d.w.Write(data)
+	d.Size += int64(n)
+	return
+}
+
+// write the fragment of buffered samples, and the init segment at first
+func (d *MP4Muxer) flushFragment() (err error) {
+	if !d.inited {
+		for _, tr := range d.Tracks {
+			tr.enabled = tr.started && tr.isReady()
+		}
+		err = d.write(d.buildInit())
+		if err != nil {
+			return
+		}
+		d.inited = true
+	}
+
+	var trafs []*MP4Track
+	for _, tr := range d.Tracks {
+		if tr.enabled && len(tr.samples) > 0 {
+			trafs = append(trafs, tr)
+		}
+	}
+	if len(trafs) == 0 {
+		return
+	}
+	d.seq++
+
+	moof := d.buildMoof(trafs, 0)
+	moof = d.buildMoof(trafs, len(moof)+8)
+	mdat := make([]byte, 0, 8)
+	for _, tr := range trafs {
+		for _, s := range tr.samples {
+			mdat = append(mdat, s.data...)
+		}
+	}
+	err = d.write(moof)
+	if err == nil {
+		err = d.write(mp4Box("mdat", mdat))
+	}
+
+	for _, tr := range trafs {
+		for _, s := range tr.samples {
+			tr.decodeTime += uint64(s.dur)
+		}
+		tr.samples = nil
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// boxes
+// ---------------------------------------------------------------------------------
+func mp4Box(typ string, payloads ...[]byte) (box []byte) {
+	n := 8
+	for _, p := range payloads {
+		n += len(p)
+	}
+	box = binary.BigEndian.AppendUint32(make([]byte, 0, n), uint32(n))
+	box = append(box, typ...)
+	for _, p := range payloads {
+		box = append(box, p...)
+	}
+	return
+}
+
+func mp4FullBox(typ string, version byte, flags uint32, payloads ...[]byte) []byte {
+	vf := mp4U32(uint32(version)<<24 | flags)
+	return mp4Box(typ, append([][]byte{vf}, payloads...)...)
+}
+
+func mp4U16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func mp4U32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func mp4U64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+var mp4Matrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+func mp4MatrixBytes() (b []byte) {
+	for _, v := range mp4Matrix {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return
+}
+
+func (d *MP4Muxer) buildInit() []byte {
+	ftyp := mp4Box("ftyp", []byte("iso5"), mp4U32(512), []byte("iso5iso6mp41"))
+
+	mvhd := mp4FullBox("mvhd", 0, 0,
+		mp4U32(0), mp4U32(0), mp4U32(MP4_MOVIE_TIMESCALE), mp4U32(0), // times, timescale, duration
+		mp4U32(0x00010000), mp4U16(0x0100), make([]byte, 10), // rate, volume, reserved
+		mp4MatrixBytes(), make([]byte, 24), mp4U32(uint32(len(d.Tracks)+1)))
+
+	moov := [][]byte{mvhd}
+	var trexs [][]byte
+	for _, tr := range d.Tracks {
+		if !tr.enabled {
+			continue
+		}
+		moov = append(moov, tr.buildTrak())
+		trexs = append(trexs, mp4FullBox("trex", 0, 0,
+			mp4U32(uint32(tr.ID)), mp4U32(1), mp4U32(0), mp4U32(0), mp4U32(0)))
+	}
+	moov = append(moov, mp4Box("mvex", trexs...))
+	return append(ftyp, mp4Box("moov", moov...)...)
+}
+
+func (d *MP4Track) buildTrak() []byte {
+	volume, handler, name := uint16(0), "vide", "VideoHandler"
+	mhd := mp4FullBox("vmhd", 0, 1, make([]byte, 8))
+	if !d.isVideo() {
+		volume, handler, name = 0x0100, "soun", "SoundHandler"
+		mhd = mp4FullBox("smhd", 0, 0, make([]byte, 4))
+	}
+	tkhd := mp4FullBox("tkhd", 0, 3,
+		mp4U32(0), mp4U32(0), mp4U32(uint32(d.ID)), mp4U32(0), mp4U32(0), // times, id, reserved, duration
+		make([]byte, 8), mp4U16(0), mp4U16(0), mp4U16(volume), mp4U16(0), // reserved, layer, group, volume
+		mp4MatrixBytes(), mp4U32(uint32(d.Width)<<16), mp4U32(uint32(d.Height)<<16))
+	mdhd := mp4FullBox("mdhd", 0, 0,
+		mp4U32(0), mp4U32(0), mp4U32(d.Timescale), mp4U32(0), mp4U16(0x55c4), mp4U16(0)) // und
+	hdlr := mp4FullBox("hdlr", 0, 0, mp4U32(0), []byte(handler), make([]byte, 12), []byte(name+"\x00"))
+	dinf := mp4Box("dinf", mp4FullBox("dref", 0, 0, mp4U32(1), mp4FullBox("url ", 0, 1)))
+	stbl := mp4Box("stbl",
+		mp4FullBox("stsd", 0, 0, mp4U32(1), d.buildSampleEntry()),
+		mp4FullBox("stts", 0, 0, mp4U32(0)),
+		mp4FullBox("stsc", 0, 0, mp4U32(0)),
+		mp4FullBox("stsz", 0, 0, mp4U32(0), mp4U32(0)),
+		mp4FullBox("stco", 0, 0, mp4U32(0)))
+	minf := mp4Box("minf", mhd, dinf, stbl)
+	return mp4Box("trak", tkhd, mp4Box("mdia", mdhd, hdlr, minf))
+}
+
+func (d *MP4Track) buildSampleEntry() []byte {
+	if d.Codec == "opus" {
+		dops := mp4Box("dOps", []byte{0, byte(d.Channels)}, mp4U16(MP4_OPUS_PRESKIP),
+			mp4U32(MP4_AUDIO_TIMESCALE), mp4U16(0), []byte{0})
+		return mp4Box("Opus", make([]byte, 6), mp4U16(1), make([]byte, 8), // reserved, data_reference_index
+			mp4U16(uint16(d.Channels)), mp4U16(16), mp4U32(0), mp4U32(MP4_AUDIO_TIMESCALE<<16), dops)
+	}
+
+	visual := [][]byte{make([]byte, 6), mp4U16(1), make([]byte, 16), // reserved, data_reference_index, pre_defined
+		mp4U16(uint16(d.Width)), mp4U16(uint16(d.Height)),
+		mp4U32(0x00480000), mp4U32(0x00480000), mp4U32(0), mp4U16(1), // resolution, reserved, frame_count
+		make([]byte, 32), mp4U16(0x0018), mp4U16(0xffff)} // compressorname, depth, pre_defined
+	if d.Codec == "h264" {
+		return mp4Box("avc1", append(visual, d.buildAVCC())...)
+	}
+	return mp4Box("hvc1", append(visual, d.buildHVCC())...)
+}
+
+// AVCDecoderConfigurationRecord (ISO/IEC 14496-15 5.3.3.1)
+func (d *MP4Track) buildAVCC() []byte {
+	rec := []byte{1, d.sps[1], d.sps[2], d.sps[3], 0xff, 0xe1} // lengthSizeMinusOne=3, numOfSPS=1
+	rec = append(rec, mp4U16(uint16(len(d.sps)))...)
+	rec = append(rec, d.sps...)
+	rec = append(rec, 1)
+	rec = append(rec, mp4U16(uint16(len(d.pps)))...)
+	rec = append(rec, d.pps...)
+	return mp4Box("avcC", rec)
+}
+
+// HEVCDecoderConfigurationRecord (ISO/IEC 14496-15 8.3.3.1)
+func (d *MP4Track) buildHVCC() []byte {
+	ptl := make([]byte, 12) // general profile, compatibility, constraint and level
+	if rbsp := unescapeRBSP(d.sps[2:]); len(rbsp) >= 13 {
+		copy(ptl, rbsp[1:13])
+	}
+	rec := []byte{1}
+	rec = append(rec, ptl[:11]...) // profile_space..constraint_indicator_flags
+	rec = append(rec, ptl[11])     // general_level_idc
+	rec = append(rec, 0xf0, 0x00, 0xfc, 0xfd, 0xf8, 0xf8, 0x00, 0x00, 0x0f, 3)
+	for _, nal := range [][]byte{d.vps, d.sps, d.pps} {
+		rec = append(rec, 0x80|(nal[0]>>1)&0x3f) // array_completeness, NAL_unit_type
+		rec = append(rec, mp4U16(1)...)
+		rec = append(rec, mp4U16(uint16(len(nal)))...)
+		rec = append(rec, nal...)
+	}
+	return mp4Box("hvcC", rec)
+}
+
+// moof of the tracks, offset is the size of moof and mdat header to the data
+func (d *MP4Muxer) buildMoof(trafs []*MP4Track, offset int) []byte {
+	boxes := [][]byte{mp4FullBox("mfhd", 0, 0, mp4U32(d.seq))}
+	for _, tr := range trafs {
+		trun := mp4U32(uint32(len(tr.samples)))
+		trun = append(trun, mp4U32(uint32(offset))...)
+		for _, s := range tr.samples {
+			flags := uint32(MP4_SAMPLE_KEY)
+			if !s.key {
+				flags = MP4_SAMPLE_NON_KEY
+			}
+			trun = append(trun, mp4U32(s.dur)...)
+			trun = append(trun, mp4U32(uint32(len(s.data)))...)
+			trun = append(trun, mp4U32(flags)...)
+			offset += len(s.data)
+		}
+		boxes = append(boxes, mp4Box("traf",
+			mp4FullBox("tfhd", 0, MP4_TFHD_BASE_IS_MOOF, mp4U32(uint32(tr.ID))),
+			mp4FullBox("tfdt", 1, 0, mp4U64(tr.decodeTime)),
+			mp4FullBox("trun", 0, MP4_TRUN_FLAGS, trun)))
+	}
+	return mp4Box("moof", boxes...)
+}
+
+// ---------------------------------------------------------------------------------
+// ConvertRecordToMP4 writes the supported tracks of the recording into fMP4
+// ---------------------------------------------------------------------------------
+func ConvertRecordToMP4(rr *RecordReader, w io.Writer) (mux *MP4Muxer, err error) {
+	mux = NewMP4Muxer(w)
+	ids := make(map[int]int) // record track index to mp4 track id
+	for {
+		var index int
+		var bs *Slot
+		index, bs, err = rr.readSlot()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return
+		}
+		id, ok := ids[index]
+		if !ok && index < len(rr.Tracks) {
+			id = 0
+			if codec := GetRecordCodec(rr.Tracks[index]); codec != "" {
+				id, _ = mux.addTrack(codec) // 0 if already initialized
+			}
+			ids[index] = id
+		}
+		if id == 0 || bs.Mark == RSSP_MARK_RTXT {
+			continue
+		}
+		err = mux.writeSample(id, bs.Data, bs.Time)
+		if err != nil {
+			return
+		}
+	}
+	err = mux.Close()
+	return
+}
+
+// get the codec of the recorded track supported in mp4, empty if not
+func GetRecordCodec(rt RecordTrack) (codec string) {
+	codec = GetCodecFromMime(rt.Mime)
+	switch codec {
+	case "h264", "h265", "opus":
+	default:
+		codec = ""
+	}
+	return
+}
+
+//=================================================================================
@@ -0,0 +1,207 @@
+// =================================================================================
+// Filename: util-mp4_test.go
+// Function: Test functions for util-mp4.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------------------------------------
+// list the boxes of the level, and the samples counted in trun
+func parseMP4Boxes(data []byte) (types []string, nsamples int) {
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		if size < 8 || size > len(data) {
+			return append(types, "invalid"), nsamples
+		}
+		types = append(types, typ)
+		switch typ {
+		case "moof", "traf", "moov", "trak", "mdia", "minf", "stbl", "mvex":
+			sub, n := parseMP4Boxes(data[8:size])
+			types = append(types, sub...)
+			nsamples += n
+		case "trun":
+			nsamples += int(binary.BigEndian.Uint32(data[12:16]))
+		}
+		data = data[size:]
+	}
+	return
+}
+
+func countMP4Box(types []string, typ string) (n int) {
+	for _, t := range types {
+		if t == typ {
+			n++
+		}
+	}
+	return
+}
+
+// write 2 seconds of h264 at 10fps with a key frame every second and opus at 50fps
+func writeTestMP4(t *testing.T, mux *MP4Muxer) {
+	vid, _ := mux.addTrack("h264")
+	aid, _ := mux.addTrack("opus")
+	sps := makeH264SPS()
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 100; i++ {
+		ts := start.Add(time.Duration(i) * 20 * time.Millisecond)
+		if i%5 == 0 {
+			frame := []byte{0, 0, 0, 1, 0x41, 0x9a, byte(i)}
+			if i%50 == 0 {
+				frame = append([]byte{0, 0, 0, 1}, sps...)
+				frame = append(frame, 0, 0, 0, 1, 0x68, 0xce, 0x38, 0x80, 0, 0, 1, 0x65, 0x88, byte(i))
+			}
+			if err := mux.writeSample(vid, frame, ts); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := mux.writeSample(aid, []byte{0xfc, 0xff, 0xfe}, ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mux.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMP4MuxerFragments(t *testing.T) {
+	var buf bytes.Buffer
+	mux := NewMP4Muxer(&buf)
+	writeTestMP4(t, mux)
+
+	types, nsamples := parseMP4Boxes(buf.Bytes())
+	if types[0] != "ftyp" || types[1] != "moov" || countMP4Box(types, "invalid") > 0 {
+		t.Fatal("invalid init segment:", types)
+	}
+	if countMP4Box(types, "moof") != 2 || countMP4Box(types, "mdat") != 2 || countMP4Box(types, "trak") != 2 {
+		t.Fatal("invalid fragments:", types)
+	}
+	if nsamples != 20+100 || int64(buf.Len()) != mux.Size {
+		t.Fatal("invalid samples:", nsamples, buf.Len(), mux.Size)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("avcC")) || !bytes.Contains(buf.Bytes(), []byte("dOps")) {
+		t.Fatal("no codec config")
+	}
+	if v := mux.Tracks[0]; v.Width != 1920 || v.Height != 1080 || mux.Tracks[1].Channels != 2 {
+		t.Fatal("invalid track info:", v.Width, v.Height, mux.Tracks[1].Channels)
+	}
+}
+
+func TestMP4MalformedParamSets(t *testing.T) {
+	var buf bytes.Buffer
+	mux := NewMP4Muxer(&buf)
+	hid, _ := mux.addTrack("h264")
+	eid, _ := mux.addTrack("h265")
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 20; i++ {
+		ts := start.Add(time.Duration(i) * 100 * time.Millisecond)
+		// 1 byte sps with pps and idr of h264, 3 bytes sps with vps, pps and idr of h265
+		mux.writeSample(hid, []byte{0, 0, 0, 1, 0x67, 0, 0, 0, 1, 0x68, 0xce, 0, 0, 0, 1, 0x65, 0x88}, ts)
+		mux.writeSample(eid, []byte{0, 0, 0, 1, 0x40, 0x01, 0, 0, 0, 1, 0x42, 0x01, 0x01,
+			0, 0, 0, 1, 0x44, 0x01, 0, 0, 0, 1, 0x26, 0x01, 0xaf}, ts)
+	}
+	mux.Close()
+	if mux.Tracks[0].isReady() || mux.Tracks[1].isReady() {
+		t.Fatal("malformed parameter sets are kept")
+	}
+}
+
+func TestMP4RecordWriterRotate(t *testing.T) {
+	ro := NewRecordOutput("mp4", t.TempDir(), "chmp4")
+	rw := ro.(*MP4RecordWriter)
+	rw.MaxTime = time.Second
+	rw.writeTrack(RecordTrack{Index: 0, Source: "base", Track: "video", Mime: "video/h264"})
+
+	// 3 seconds at 10fps, a key frame every second
+	sps := makeH264SPS()
+	start := time.Unix(1700000000, 0)
+	var files []string
+	for i := 0; i < 30; i++ {
+		bs := Slot{Time: start.Add(time.Duration(i) * 100 * time.Millisecond), Data: []byte{0, 0, 0, 1, 0x41, 0x9a}}
+		if i%10 == 0 {
+			bs.Key = true
+			bs.Data = append(append([]byte{0, 0, 0, 1}, sps...), 0, 0, 0, 1, 0x68, 0xce, 0, 0, 0, 1, 0x65, 0x88)
+		}
+		if err := ro.writeSlot(0, &bs); err != nil {
+			t.Fatal(err)
+		}
+		if len(files) == 0 || files[len(files)-1] != ro.getFileName() {
+			files = append(files, ro.getFileName())
+		}
+	}
+	ro.closeFile()
+	if len(files) != 3 || filepath.Ext(files[0]) != ".mp4" {
+		t.Fatal("invalid rotation:", files)
+	}
+	data, _ := os.ReadFile(files[1])
+	if types, nsamples := parseMP4Boxes(data); types[0] != "ftyp" || nsamples != 10 {
+		t.Fatal("invalid mp4 file:", types, nsamples)
+	}
+}
+
+func TestRecordMP4Download(t *testing.T) {
+	dir := mConfig.DirRecord
+	mConfig.DirRecord = t.TempDir()
+	defer func() { mConfig.DirRecord = dir }()
+
+	rw := NewRecordWriter(filepath.Join(mConfig.DirRecord, "chmp4"), "chmp4")
+	rw.writeTrack(RecordTrack{Index: 0, Source: "base", Track: "video", Mime: "video/h264"})
+	rw.writeTrack(RecordTrack{Index: 1, Source: "base", Track: "data", Mime: "text/plain"})
+	sps := makeH264SPS()
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 30; i++ {
+		bs := Slot{Time: start.Add(time.Duration(i) * 100 * time.Millisecond), Data: []byte{0, 0, 0, 1, 0x41, 0x9a}}
+		if i%10 == 0 {
+			bs.Key = true
+			bs.Data = append(append([]byte{0, 0, 0, 1}, sps...), 0, 0, 0, 1, 0x68, 0xce, 0, 0, 0, 1, 0x65, 0x88)
+		}
+		bs.Length = len(bs.Data)
+		rw.writeSlot(0, &bs)
+		rw.writeSlot(1, &Slot{Time: bs.Time, Data: []byte("hello"), Length: 5})
+	}
+	rw.closeFile()
+	id := rw.Header.Name
+
+	req := httptest.NewRequest("GET", "/record/"+id+".mp4", nil)
+	rec := httptest.NewRecorder()
+	RecordHTTPHandler(rec, req)
+	full := rec.Body.Bytes()
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "video/mp4" {
+		t.Fatal("invalid response:", rec.Code, rec.Header())
+	}
+	types, nsamples := parseMP4Boxes(full)
+	if countMP4Box(types, "trak") != 1 || countMP4Box(types, "moof") != 3 || nsamples != 30 {
+		t.Fatal("invalid mp4:", types, nsamples)
+	}
+
+	// served from the converted file with the range
+	req = httptest.NewRequest("GET", "/record/"+id+".mp4", nil)
+	req.Header.Set("Range", "bytes=100-199")
+	rec = httptest.NewRecorder()
+	RecordHTTPHandler(rec, req)
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), full[100:200]) {
+		t.Fatal("invalid range response:", rec.Code, rec.Body.Len())
+	}
+
+	for _, path := range []string{"/record/nothing.mp4", "/record/..%2Fetc.mp4", "/record/" + id + ".rssp"} {
+		rec = httptest.NewRecorder()
+		RecordHTTPHandler(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code == http.StatusOK {
+			t.Fatal("invalid request is served:", path)
+		}
+	}
+}
+
+//=================================================================================
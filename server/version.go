@@ -7,6 +7,6 @@
 package main
 
 // ---------------------------------------------------------------------------------
-const Version = "1.1.7.5"
+const Version = "1.1.7.6"
 
 //=================================================================================
@@ -0,0 +1,255 @@
+// =================================================================================
+// Filename: work-level.go
+// Function: Audio level metering and silence detection of PCM tracks
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// ---------------------------------------------------------------------------------
+// The tracks of audio/x-wav (signed 16-bit little-endian PCM) are metered per window
+// into the derived track <track>@level of json levels in dBFS by the processor "level",
+// attached to the tracks by the mime.
+//   - rate and channels by the WAV header in the slot, or the mime parameters,
+//     audio/x-wav;codec=pcm;rate=16000;channels=2, 48000 and 1 if not given
+//
+// Events of the channel on /proc/level/<source>/<track>, with the hysteresis:
+//   - audio-silence, audio-silence-end: rms under LEVEL_SILENCE_DB for LEVEL_SILENCE_TIME,
+//     ended over LEVEL_SILENCE_DB + LEVEL_HYSTERESIS_DB
+//   - audio-clip, audio-clip-end: peak over LEVEL_CLIP_DB, ended after LEVEL_CLIP_HOLD
+//
+// ---------------------------------------------------------------------------------
+const (
+	LEVEL_DERIVED_NAME   = "level"
+	LEVEL_WINDOW         = 100 * time.Millisecond
+	LEVEL_DEFAULT_RATE   = 48000
+	LEVEL_FLOOR_DB       = -100.0
+	LEVEL_SILENCE_DB     = -50.0
+	LEVEL_HYSTERESIS_DB  = 6.0
+	LEVEL_SILENCE_TIME   = 2 * time.Second
+	LEVEL_CLIP_DB        = -0.1
+	LEVEL_CLIP_HOLD      = 1 * time.Second
+	LEVEL_WAVE_HEAD_LEN  = 44
+	LEVEL_MAX_RATE       = 384000
+	LEVEL_MAX_CHANNELS   = 32
+	LEVEL_SAMPLE_MAX_ABS = 32768.0
+)
+
+func init() {
+	RegisterProcessor(LEVEL_DERIVED_NAME, func() Processor { return &LevelProcessor{} })
+	RegisterAutoProcessor(LEVEL_DERIVED_NAME, IsPCMAudioMime)
+}
+
+// check if the mime is the pcm audio of wave
+func IsPCMAudioMime(mime string) bool {
+	base, _, _ := strings.Cut(mime, ";")
+	base = strings.TrimSpace(base)
+	return base == "audio/x-wav" || base == "audio/wave" || base == "audio/wav"
+}
+
+// GetPCMFormat returns the rate and channels by the mime parameters
+func GetPCMFormat(mime string) (rate, channels int) {
+	rate, channels = LEVEL_DEFAULT_RATE, 1
+	params := strings.Split(mime, ";")
+	for _, p := range params[1:] {
+		key, val, _ := strings.Cut(strings.TrimSpace(p), "=")
+		n, err := strconv.Atoi(val)
+		if err != nil || n <= 0 {
+			continue
+		}
+		switch key {
+		case "rate":
+			rate = min(n, LEVEL_MAX_RATE)
+		case "channels":
+			channels = min(n, LEVEL_MAX_CHANNELS)
+		}
+	}
+	return
+}
+
+// StripWaveHeader strips the canonical WAV header of 16-bit pcm, returning its format
+func StripWaveHeader(data []byte) (pcm []byte, rate, channels int, ok bool) {
+	pcm = data
+	if len(data) < LEVEL_WAVE_HEAD_LEN || string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" ||
+		string(data[12:16]) != "fmt " {
+		return
+	}
+	format := binary.LittleEndian.Uint16(data[20:])
+	channels = int(binary.LittleEndian.Uint16(data[22:]))
+	rate = int(binary.LittleEndian.Uint32(data[24:]))
+	bits := binary.LittleEndian.Uint16(data[34:])
+	if format != 1 || bits != 16 || channels < 1 || channels > LEVEL_MAX_CHANNELS || rate < 1 || rate > LEVEL_MAX_RATE {
+		return
+	}
+	// find the data chunk after fmt
+	for i := 20 + int(binary.LittleEndian.Uint32(data[16:])); i+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if string(data[i:i+4]) == "data" {
+			pcm, ok = data[i+8:], true
+			return
+		}
+		i += 8 + size
+	}
+	return
+}
+
+// convert the sample level into dBFS
+func GetLevelDB(level float64) float64 {
+	if level <= 0 {
+		return LEVEL_FLOOR_DB
+	}
+	db := 20 * math.Log10(level/LEVEL_SAMPLE_MAX_ABS)
+	return math.Round(max(db, LEVEL_FLOOR_DB)*10) / 10
+}
+
+// ---------------------------------------------------------------------------------
+// AudioLevel is the level of a window in the derived track
+type AudioLevel struct {
+	RMS     float64   `json:"rms"`  // dBFS
+	Peak    float64   `json:"peak"` // dBFS
+	Silence bool      `json:"silence"`
+	Clip    bool      `json:"clip"`
+	Time    time.Time `json:"time"`
+}
+
+// LevelMeter accumulates the samples into the levels of windows, with the detection
+type LevelMeter struct {
+	Rate     int      `json:"rate"`
+	Channels int      `json:"channels"`
+	Windows  int      `json:"windows"`
+	Silence  bool     `json:"silence"`
+	Clip     bool     `json:"clip"`
+	Events   []string `json:"-"` // events of the last windows, cleared by the caller
+	// --- internal variables
+	n       int // samples in the window
+	sumsq   float64
+	peak    float64
+	odd     []byte        // the byte left in the previous slot
+	tquiet  time.Duration // duration under the silence level
+	tnoclip time.Duration // duration without the clip
+}
+
+func NewLevelMeter(rate, channels int) *LevelMeter {
+	return &LevelMeter{Rate: rate, Channels: channels}
+}
+
+// Add adds the pcm data, returning the levels of the windows completed
+func (d *LevelMeter) Add(data []byte, now time.Time) (levels []AudioLevel) {
+	if pcm, rate, channels, ok := StripWaveHeader(data); ok {
+		d.Rate, d.Channels, data = rate, channels, pcm
+	}
+	if len(d.odd) > 0 {
+		data = append(d.odd, data...)
+		d.odd = nil
+	}
+	if len(data)%2 == 1 {
+		d.odd = []byte{data[len(data)-1]}
+		data = data[:len(data)-1]
+	}
+	window := max(d.Rate*d.Channels*int(LEVEL_WINDOW/time.Millisecond)/1000, 1)
+	for i := 0; i+1 < len(data); i += 2 {
+		v := float64(int16(binary.LittleEndian.Uint16(data[i:])))
+		d.sumsq += v * v
+		d.peak = max(d.peak, math.Abs(v))
+		d.n++
+		if d.n >= window {
+			levels = append(levels, d.complete(now))
+		}
+	}
+	return
+}
+
+// complete the window, detecting the silence and clip with the hysteresis
+func (d *LevelMeter) complete(now time.Time) (level AudioLevel) {
+	level = AudioLevel{RMS: GetLevelDB(math.Sqrt(d.sumsq / float64(d.n))), Peak: GetLevelDB(d.peak), Time: now}
+	d.n, d.sumsq, d.peak = 0, 0, 0
+	d.Windows++
+
+	if level.RMS < LEVEL_SILENCE_DB {
+		d.tquiet += LEVEL_WINDOW
+		if !d.Silence && d.tquiet >= LEVEL_SILENCE_TIME {
+			d.Silence = true
+			d.Events = append(d.Events, "audio-silence")
+		}
+	} else {
+		d.tquiet = 0
+		if d.Silence && level.RMS > LEVEL_SILENCE_DB+LEVEL_HYSTERESIS_DB {
+			d.Silence = false
+			d.Events = append(d.Events, "audio-silence-end")
+		}
+	}
+	if level.Peak >= LEVEL_CLIP_DB {
+		d.tnoclip = 0
+		if !d.Clip {
+			d.Clip = true
+			d.Events = append(d.Events, "audio-clip")
+		}
+	} else if d.Clip {
+		d.tnoclip += LEVEL_WINDOW
+		if d.tnoclip >= LEVEL_CLIP_HOLD {
+			d.Clip = false
+			d.Events = append(d.Events, "audio-clip-end")
+		}
+	}
+	level.Silence, level.Clip = d.Silence, d.Clip
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// LevelProcessor meters the slots of a pcm track into <track>@level, attached by its mime
+// ---------------------------------------------------------------------------------
+type LevelProcessor struct {
+	Meter *LevelMeter `json:"meter"`
+	// --- internal variables
+	pc *ProcContext
+}
+
+func (d *LevelProcessor) Init(pc *ProcContext) (err error) {
+	if !IsPCMAudioMime(pc.Mime) {
+		return fmt.Errorf("not pcm audio track: %s", pc.Mime)
+	}
+	d.Meter = NewLevelMeter(GetPCMFormat(pc.Mime))
+	d.pc = pc
+	pc.OutMime = "application/json"
+	return
+}
+
+func (d *LevelProcessor) Process(bs *Slot) (outs []Slot, events []ProcEvent, err error) {
+	if bs.FrameType != websocket.BinaryMessage {
+		return
+	}
+	for _, level := range d.Meter.Add(bs.Data, time.Now()) {
+		data, err := json.Marshal(level)
+		if err != nil {
+			return nil, nil, err
+		}
+		outs = append(outs, Slot{FrameType: websocket.BinaryMessage, Data: data})
+	}
+	for _, name := range d.Meter.Events {
+		data, _ := json.Marshal(struct {
+			Source string `json:"source"`
+			Track  string `json:"track"`
+		}{d.pc.Source, d.pc.Track})
+		events = append(events, ProcEvent{Name: name, Data: string(data)})
+	}
+	d.Meter.Events = nil
+	return
+}
+
+func (d *LevelProcessor) Close() error {
+	return nil
+}
+
+//=================================================================================
@@ -0,0 +1,134 @@
+// =================================================================================
+// Filename: work-level_test.go
+// Function: Test functions for work-level.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// ---------------------------------------------------------------------------------
+// make the pcm of the sine wave in the amplitude of 0-1 for the duration
+func newPCMSine(rate int, amp float64, dur time.Duration) (data []byte) {
+	n := rate * int(dur/time.Millisecond) / 1000
+	for i := 0; i < n; i++ {
+		v := amp * 32767 * math.Sin(2*math.Pi*440*float64(i)/float64(rate))
+		data = binary.LittleEndian.AppendUint16(data, uint16(int16(v)))
+	}
+	return
+}
+
+func newWaveHeader(rate, channels int) []byte {
+	h := []byte("RIFF\x00\x00\x00\x00WAVEfmt ")
+	h = binary.LittleEndian.AppendUint32(h, 16)
+	h = binary.LittleEndian.AppendUint16(h, 1) // pcm
+	h = binary.LittleEndian.AppendUint16(h, uint16(channels))
+	h = binary.LittleEndian.AppendUint32(h, uint32(rate))
+	h = binary.LittleEndian.AppendUint32(h, uint32(rate*channels*2))
+	h = binary.LittleEndian.AppendUint16(h, uint16(channels*2))
+	h = binary.LittleEndian.AppendUint16(h, 16)
+	h = append(h, "data"...)
+	return binary.LittleEndian.AppendUint32(h, 0)
+}
+
+func TestLevelMeter(t *testing.T) {
+	if !IsPCMAudioMime(MIME_AUDIO_WAVE) || IsPCMAudioMime(MIME_AUDIO_OPUS) {
+		t.Fatal("invalid pcm mime check")
+	}
+	if rate, channels := GetPCMFormat("audio/x-wav;codec=pcm;rate=16000;channels=2"); rate != 16000 || channels != 2 {
+		t.Fatal("invalid pcm format:", rate, channels)
+	}
+
+	// the format by the wav header, levels per window
+	m := NewLevelMeter(GetPCMFormat(MIME_AUDIO_WAVE))
+	now := time.Now()
+	levels := m.Add(append(newWaveHeader(8000, 1), newPCMSine(8000, 0.1, time.Second)...), now)
+	if m.Rate != 8000 || len(levels) != 10 {
+		t.Fatal("invalid windows:", m.Rate, len(levels))
+	}
+	if l := levels[5]; math.Abs(l.RMS+23) > 0.5 || math.Abs(l.Peak+20) > 0.5 || l.Silence || l.Clip {
+		t.Fatal("invalid level:", l) // -23 dBFS rms, -20 dBFS peak of the sine in 0.1
+	}
+
+	// silence after LEVEL_SILENCE_TIME, ended over the hysteresis
+	m.Add(make([]byte, 8000*2*int(LEVEL_SILENCE_TIME/time.Second)-2), now)
+	if m.Silence || len(m.Events) > 0 {
+		t.Fatal("silence is detected early:", m.Events)
+	}
+	m.Add(make([]byte, 1600), now)
+	m.Add(newPCMSine(8000, 0.005, 500*time.Millisecond), now) // -49 dBFS, under the hysteresis
+	if !m.Silence || !slices.Equal(m.Events, []string{"audio-silence"}) {
+		t.Fatal("silence is not detected:", m.Events)
+	}
+	m.Add(newPCMSine(8000, 0.5, 200*time.Millisecond), now)
+	if m.Silence || m.Events[len(m.Events)-1] != "audio-silence-end" {
+		t.Fatal("silence is not ended:", m.Events)
+	}
+
+	// clip, ended after LEVEL_CLIP_HOLD
+	m.Events = nil
+	m.Add(newPCMSine(8000, 1, 100*time.Millisecond), now)
+	m.Add(newPCMSine(8000, 0.5, LEVEL_CLIP_HOLD-LEVEL_WINDOW), now)
+	if !m.Clip || !slices.Equal(m.Events, []string{"audio-clip"}) {
+		t.Fatal("clip is not detected:", m.Events)
+	}
+	m.Add(newPCMSine(8000, 0.5, 2*LEVEL_WINDOW), now) // the windows not aligned to the slots
+	if m.Clip || !slices.Equal(m.Events, []string{"audio-clip", "audio-clip-end"}) {
+		t.Fatal("clip is not ended:", m.Events)
+	}
+}
+
+func TestLevelProcessor(t *testing.T) {
+	chn := pStudio.addChannel(NewChannelPointer())
+	defer pStudio.deleteChannel(chn)
+	chn.State = Using
+	_, trk, _ := chn.addSourceTrackBySize("mic", "audio", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+	b := trk.getRingByOrder(BUFFER_NUM_FORE)
+	trk.setRingMime(b, "audio/x-wav;codec=pcm;rate=8000")
+
+	s := pStudio.addNewSessionWithName("/pang/ws/pub") // to keep the channel using
+	s.ChannelID, s.SourceID, s.TrackID = chn.ID, "mic", "audio"
+	chn.addPublisher(s)
+	chn.startProcessor() // attached by the mime, without ProcsAuto
+
+	// the levels in the derived track
+	var bs *Slot
+	for tout := time.After(5 * time.Second); bs == nil; {
+		data := newPCMSine(8000, 0.1, 100*time.Millisecond)
+		b.writeSlot(Slot{FrameType: websocket.BinaryMessage, Data: data, Length: len(data)}, false)
+		select {
+		case <-tout:
+			t.Fatal("no level track")
+		case <-time.After(50 * time.Millisecond):
+		}
+		if _, dtrk, _ := chn.findSourceTrackByLabel("mic", "audio@"+LEVEL_DERIVED_NAME); dtrk != nil {
+			db := dtrk.getRingByOrder(BUFFER_NUM_FORE)
+			bs = db.readSlotBySeq(db.getWriteSeq())
+		}
+	}
+	var level AudioLevel
+	if json.Unmarshal(bs.Data, &level) != nil || math.Abs(level.RMS+23) > 0.5 {
+		t.Fatal("invalid level:", string(bs.Data))
+	}
+
+	chn.deletePublisher(s)
+	pStudio.deleteSessionWithClose(s)
+	for i := 0; i < 200 && chn.getProcsState() == Using; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if chn.getProcsState() != Idle || pStudio.findPublisherByResource(chn.ID, "mic", "audio@"+LEVEL_DERIVED_NAME) != nil {
+		t.Fatal("level processor is not stopped")
+	}
+}
+
+//=================================================================================
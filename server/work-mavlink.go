@@ -0,0 +1,225 @@
+// =================================================================================
+// Filename: work-mavlink.go
+// Function: Telemetry worker decoding the MAVLink tracks of channel
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// ---------------------------------------------------------------------------------
+// The tracks of mavlink/binary are decoded into the derived track <track>@mavlink
+// of json messages, and the latest telemetry per vehicle is shown in info_track.
+// The processor "mavlink" is attached to the tracks by their mime, run by the procs worker.
+// Events of the channel, on the path /proc/mavlink/<source>/<track>:
+//   - mav-link-lost, mav-link-ok: no heartbeat of the vehicle for MAVLINK_LINK_TIMEOUT
+//   - mav-failsafe, mav-failsafe-clear: critical/emergency state, or low battery
+//
+// ---------------------------------------------------------------------------------
+const (
+	MAVLINK_SCAN_PERIOD   = 1 * time.Second // to check the links
+	MAVLINK_LINK_TIMEOUT  = 5 * time.Second
+	MAVLINK_BATTERY_LOW   = 15 // %
+	MAVLINK_DERIVED_NAME  = "mavlink"
+	MAVLINK_UPDATE_PERIOD = 200 * time.Millisecond // to update the telemetry of track
+)
+
+// MavVehicle is the latest telemetry of a vehicle identified by the system id
+type MavVehicle struct {
+	SysID       uint8         `json:"sys_id"`
+	CompID      uint8         `json:"comp_id"`
+	Link        string        `json:"link"`               // ok, lost
+	Failsafe    string        `json:"failsafe,omitempty"` // reason of failsafe
+	Heartbeat   *MavHeartbeat `json:"heartbeat,omitempty"`
+	Status      *MavSysStatus `json:"sys_status,omitempty"`
+	Attitude    *MavAttitude  `json:"attitude,omitempty"`
+	Position    *MavPosition  `json:"position,omitempty"`
+	Messages    int           `json:"messages"`
+	AtHeartbeat time.Time     `json:"at_heartbeat"`
+	AtUpdated   time.Time     `json:"at_updated"`
+}
+
+// MavMessage is the json message in the derived track
+type MavMessage struct {
+	SysID  uint8       `json:"sys_id"`
+	CompID uint8       `json:"comp_id"`
+	Name   string      `json:"msg"`
+	Data   interface{} `json:"data"`
+	Time   time.Time   `json:"time"`
+}
+
+func init() {
+	RegisterProcessor(MAVLINK_DERIVED_NAME, func() Processor { return &MavProcessor{} })
+	RegisterAutoProcessor(MAVLINK_DERIVED_NAME, func(mime string) bool { return mime == MIME_MAVLINK_BIN })
+}
+
+// ---------------------------------------------------------------------------------
+// MavProcessor decodes the slots of a mavlink track, attached by its mime
+// ---------------------------------------------------------------------------------
+type MavProcessor struct {
+	Parser MavParser `json:"parser"`
+	// --- internal variables
+	trk      *Track
+	vehicles map[uint8]*MavVehicle
+	events   []ProcEvent
+	tupdate  time.Time
+	tcheck   time.Time
+	updated  bool // telemetry to be set into the track
+}
+
+func (d *MavProcessor) Init(pc *ProcContext) (err error) {
+	if pc.Mime != MIME_MAVLINK_BIN {
+		return fmt.Errorf("not mavlink track: %s", pc.Mime)
+	}
+	d.trk = pc.trk
+	d.vehicles = make(map[uint8]*MavVehicle)
+	pc.OutMime = "application/json"
+	return
+}
+
+func (d *MavProcessor) Process(bs *Slot) (outs []Slot, events []ProcEvent, err error) {
+	if bs.FrameType != websocket.BinaryMessage {
+		return
+	}
+	now := time.Now()
+	for _, f := range d.Parser.Parse(bs.Data) {
+		if data := d.decode(f, now); data != nil {
+			outs = append(outs, Slot{FrameType: websocket.BinaryMessage, Data: data})
+		}
+	}
+	events, d.events = d.events, nil
+	return
+}
+
+// Tick checks the links of vehicles, and sets the telemetry into the track
+func (d *MavProcessor) Tick(now time.Time) (events []ProcEvent) {
+	if now.Sub(d.tcheck) >= MAVLINK_SCAN_PERIOD {
+		d.tcheck = now
+		d.updated = d.checkLinks(now) || d.updated
+	}
+	if d.updated && now.Sub(d.tupdate) >= MAVLINK_UPDATE_PERIOD {
+		d.tupdate, d.updated = now, false
+		d.trk.setTelemetry(d.getVehicles())
+	}
+	events, d.events = d.events, nil
+	return
+}
+
+func (d *MavProcessor) Close() error {
+	if d.trk != nil {
+		d.trk.setTelemetry(nil)
+	}
+	return nil
+}
+
+// decode the frame into the telemetry of vehicle, returning the json message of derived track
+func (d *MavProcessor) decode(f MavFrame, now time.Time) (data []byte) {
+	name, msg := DecodeMavMessage(f)
+	if msg == nil {
+		return
+	}
+	d.updated = true
+	v := d.vehicles[f.SysID]
+	if v == nil {
+		v = &MavVehicle{SysID: f.SysID, CompID: f.CompID}
+		d.vehicles[f.SysID] = v
+	}
+	v.Messages++
+	v.AtUpdated = now
+	switch m := msg.(type) {
+	case MavHeartbeat:
+		if v.Link != "ok" {
+			v.Link = "ok"
+			d.pushEvent("mav-link-ok", v)
+		}
+		v.Heartbeat, v.AtHeartbeat = &m, now
+		d.checkFailsafe(v)
+	case MavSysStatus:
+		v.Status = &m
+		d.checkFailsafe(v)
+	case MavAttitude:
+		v.Attitude = &m
+	case MavPosition:
+		v.Position = &m
+	}
+
+	data, err := json.Marshal(MavMessage{SysID: f.SysID, CompID: f.CompID, Name: name, Data: msg, Time: now})
+	if err != nil {
+		log.Println("mavlink:", err)
+	}
+	return
+}
+
+// check the failsafe condition of vehicle, pushing the event at its change
+func (d *MavProcessor) checkFailsafe(v *MavVehicle) {
+	reason := ""
+	if v.Heartbeat != nil && IsMavFailsafeState(v.Heartbeat.state) {
+		reason = v.Heartbeat.Status
+	} else if v.Status != nil && v.Status.Battery >= 0 && v.Status.Battery <= MAVLINK_BATTERY_LOW {
+		reason = "battery"
+	}
+	if reason == v.Failsafe {
+		return
+	}
+	v.Failsafe = reason
+	if reason != "" {
+		d.pushEvent("mav-failsafe", v)
+	} else {
+		d.pushEvent("mav-failsafe-clear", v)
+	}
+}
+
+// check the heartbeats of vehicles, pushing the event of link loss
+func (d *MavProcessor) checkLinks(now time.Time) (changed bool) {
+	for _, v := range d.vehicles {
+		if v.Link == "ok" && now.Sub(v.AtHeartbeat) > MAVLINK_LINK_TIMEOUT {
+			v.Link = "lost"
+			d.pushEvent("mav-link-lost", v)
+			changed = true
+		}
+	}
+	return
+}
+
+// add the event of vehicle, pushed by the runner
+func (d *MavProcessor) pushEvent(name string, v *MavVehicle) {
+	data, _ := json.Marshal(struct {
+		SysID    uint8  `json:"sys_id"`
+		Link     string `json:"link"`
+		Failsafe string `json:"failsafe"`
+	}{v.SysID, v.Link, v.Failsafe})
+	d.events = append(d.events, ProcEvent{Name: name, Data: string(data)})
+}
+
+// get the copies of vehicles in the order of system id
+func (d *MavProcessor) getVehicles() (vs []MavVehicle) {
+	for _, v := range d.vehicles {
+		vs = append(vs, *v)
+	}
+	sort.Slice(vs, func(i, j int) bool { return vs[i].SysID < vs[j].SysID })
+	return
+}
+
+// ---------------------------------------------------------------------------------
+func (d *Track) setTelemetry(vs []MavVehicle) {
+	d.Lock()
+	defer d.Unlock()
+	d.Vehicles = vs
+}
+
+func (d *Track) getTelemetry() []MavVehicle {
+	d.RLock()
+	defer d.RUnlock()
+	return d.Vehicles
+}
+
+//=================================================================================
@@ -0,0 +1,148 @@
+// =================================================================================
+// Filename: work-mavlink_test.go
+// Function: Test functions for work-mavlink.go and util-mavlink.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// ---------------------------------------------------------------------------------
+func newMavHeartbeat(state, mode uint8) []byte {
+	p := make([]byte, 9)
+	binary.LittleEndian.PutUint32(p, 4) // custom mode
+	p[4], p[5], p[6], p[7], p[8] = 2, 3, mode, state, 3
+	return p
+}
+
+func newMavPosition(lat, lon float64, relalt float64) []byte {
+	p := make([]byte, 28)
+	binary.LittleEndian.PutUint32(p[4:], uint32(int32(lat*1e7)))
+	binary.LittleEndian.PutUint32(p[8:], uint32(int32(lon*1e7)))
+	binary.LittleEndian.PutUint32(p[16:], uint32(int32(relalt*1000)))
+	binary.LittleEndian.PutUint16(p[26:], 9000)
+	return p
+}
+
+func TestMavParser(t *testing.T) {
+	att := make([]byte, 28)
+	binary.LittleEndian.PutUint32(att[4:], math.Float32bits(math.Pi/2))
+	stream := []byte{0x00, 0x11} // garbage to sync
+	stream = append(stream, EncodeMavFrame(1, 0, 1, 1, 0, newMavHeartbeat(4, 0x80))...)
+	stream = append(stream, EncodeMavFrame(2, 1, 1, 1, 30, att[:8])...) // truncated zeros
+	stream = append(stream, EncodeMavFrame(2, 2, 1, 1, 999, []byte{1, 2, 3})...)
+	bad := EncodeMavFrame(2, 3, 1, 1, 33, newMavPosition(37.5, 127.0, 10))
+	bad[12] ^= 0xFF
+	stream = append(stream, bad...)
+	stream = append(stream, EncodeMavFrame(2, 4, 2, 1, 33, newMavPosition(37.5, 127.0, 10))...)
+
+	// split into slots at every 7 bytes
+	var p MavParser
+	var frames []MavFrame
+	for i := 0; i < len(stream); i += 7 {
+		frames = append(frames, p.Parse(stream[i:min(i+7, len(stream))])...)
+	}
+	if len(frames) != 3 || p.Unknown != 1 || p.Errors < 1 {
+		t.Fatal("invalid parsing:", len(frames), p.Unknown, p.Errors)
+	}
+
+	name, msg := DecodeMavMessage(frames[0])
+	if hb, ok := msg.(MavHeartbeat); name != "HEARTBEAT" || !ok || !hb.Armed || hb.Status != "active" {
+		t.Fatal("invalid heartbeat:", name, msg)
+	}
+	_, msg = DecodeMavMessage(frames[1])
+	if at, ok := msg.(MavAttitude); !ok || at.Roll != 90 || at.Pitch != 0 {
+		t.Fatal("invalid attitude:", msg)
+	}
+	_, msg = DecodeMavMessage(frames[2])
+	if pos, ok := msg.(MavPosition); !ok || frames[2].SysID != 2 || pos.Lat != 37.5 || pos.RelAlt != 10 || pos.Heading != 90 {
+		t.Fatal("invalid position:", msg)
+	}
+}
+
+func TestMavProcessor(t *testing.T) {
+	chn := pStudio.addChannel(NewChannelPointer())
+	defer pStudio.deleteChannel(chn)
+	chn.State = Using
+	_, trk, _ := chn.addSourceTrackBySize("drone", "mav", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+	b := trk.getRingByOrder(BUFFER_NUM_FORE)
+	trk.setRingMime(b, MIME_MAVLINK_BIN)
+
+	s := pStudio.addNewSessionWithName("/pang/ws/pub") // to keep the channel using
+	s.ChannelID, s.SourceID, s.TrackID = chn.ID, "drone", "mav"
+	chn.addPublisher(s)
+	chn.startProcessor() // attached by the mime, without ProcsAuto
+
+	// the telemetry of vehicle in the track, the messages in the derived track
+	var vs []MavVehicle
+	for tout := time.After(5 * time.Second); len(vs) == 0 || vs[0].Failsafe == ""; {
+		data := EncodeMavFrame(2, 0, 1, 1, 0, newMavHeartbeat(6, 0))
+		data = append(data, EncodeMavFrame(2, 1, 1, 1, 33, newMavPosition(37.5, 127.0, 10))...)
+		b.writeSlot(Slot{FrameType: websocket.BinaryMessage, Data: data, Length: len(data)}, false)
+		select {
+		case <-tout:
+			t.Fatal("no telemetry:", vs)
+		case <-time.After(50 * time.Millisecond):
+		}
+		vs = trk.getTelemetry()
+	}
+	if vs[0].Link != "ok" || vs[0].Failsafe != "emergency" || vs[0].Position == nil || vs[0].Position.Lat != 37.5 {
+		t.Fatal("invalid telemetry:", vs[0])
+	}
+	_, dtrk, err := chn.findSourceTrackByLabel("drone", "mav@"+MAVLINK_DERIVED_NAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := dtrk.getRingByOrder(BUFFER_NUM_FORE)
+	bs := db.readSlotBySeq(db.getWriteSeq())
+	var mm MavMessage
+	if db.Mime != "application/json" || bs == nil || json.Unmarshal(bs.Data, &mm) != nil || mm.SysID != 1 {
+		t.Fatal("invalid derived track:", db.Mime, bs)
+	}
+
+	// stopped at the last pub-out, releasing the derived track
+	chn.deletePublisher(s)
+	pStudio.deleteSessionWithClose(s)
+	for i := 0; i < 200 && chn.getProcsState() == Using; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if chn.getProcsState() != Idle || trk.getTelemetry() != nil {
+		t.Fatal("processor is not stopped")
+	}
+}
+
+func TestMavLinkLost(t *testing.T) {
+	d := &MavProcessor{vehicles: make(map[uint8]*MavVehicle)}
+	now := time.Now()
+	d.vehicles[1] = &MavVehicle{SysID: 1, Link: "ok", AtHeartbeat: now}
+	if d.checkLinks(now.Add(MAVLINK_LINK_TIMEOUT / 2)) {
+		t.Fatal("link is lost early")
+	}
+	if !d.checkLinks(now.Add(2*MAVLINK_LINK_TIMEOUT)) || d.vehicles[1].Link != "lost" {
+		t.Fatal("link is not lost")
+	}
+	var ev struct {
+		SysID uint8  `json:"sys_id"`
+		Link  string `json:"link"`
+	}
+	if len(d.events) != 1 || d.events[0].Name != "mav-link-lost" ||
+		json.Unmarshal([]byte(d.events[0].Data), &ev) != nil || ev.SysID != 1 || ev.Link != "lost" {
+		t.Fatal("invalid link event:", d.events)
+	}
+	d.vehicles[1].Status = &MavSysStatus{Battery: 10}
+	d.checkFailsafe(d.vehicles[1])
+	if d.vehicles[1].Failsafe != "battery" {
+		t.Fatal("low battery is not failsafe")
+	}
+}
+
+//=================================================================================
@@ -0,0 +1,273 @@
+// =================================================================================
+// Filename: work-motion.go
+// Function: Motion detection processor for the tracks of MJPEG (video/jpeg)
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// ---------------------------------------------------------------------------------
+// The frames are decoded into the gray grid and compared with the background,
+// updated slowly to follow the light. The motion starts if the changed pixels in
+// the zones are over the area, and ends after the hold time without the motion.
+//
+// proc=motion,sensitivity=50,zones=0:0:50:100;50:50:50:50,hold=3,fps=5,trigger=record
+//   - sensitivity: 1-100, the higher detects the smaller change
+//   - zones: x:y:w:h in percent of the frame separated by ';', the whole frame in default
+//   - hold: seconds to end the motion, fps: frames checked per second
+//   - trigger: record or snapshot of the channel while in motion
+//
+// ---------------------------------------------------------------------------------
+const (
+	MOTION_GRID_WIDTH  = 64
+	MOTION_GRID_HEIGHT = 48
+	MOTION_BG_RATE     = 16 // background follows 1/16 of the difference per frame
+	MOTION_HOLD_TIME   = 3 * time.Second
+	MOTION_CHECK_FPS   = 5
+)
+
+func init() {
+	RegisterProcessor("motion", func() Processor { return &MotionProcessor{} })
+}
+
+// MotionZone is the area to detect, in percent of the frame
+type MotionZone struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// ParseMotionZones parses the zones of x:y:w:h;...
+func ParseMotionZones(str string) (zones []MotionZone, err error) {
+	for _, item := range strings.Split(str, ";") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		var v [4]int
+		fields := strings.Split(item, ":")
+		for i := 0; i < len(fields) && i < 4 && err == nil; i++ {
+			v[i], err = strconv.Atoi(fields[i])
+		}
+		z := MotionZone{X: v[0], Y: v[1], W: v[2], H: v[3]}
+		if err != nil || len(fields) != 4 || z.X < 0 || z.Y < 0 || z.W < 1 || z.H < 1 || z.X+z.W > 100 || z.Y+z.H > 100 {
+			err = fmt.Errorf("invalid zone: %s [x:y:w:h in 0-100]", item)
+			return
+		}
+		zones = append(zones, z)
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// MotionDetector detects the motion of frames in the zones
+// ---------------------------------------------------------------------------------
+type MotionDetector struct {
+	Sensitivity int           `json:"sensitivity"`
+	Threshold   int           `json:"threshold"` // difference of pixel to be changed
+	MinArea     float64       `json:"min_area"`  // percent of changed pixels for the motion
+	Hold        time.Duration `json:"hold"`
+	Zones       []MotionZone  `json:"zones,omitempty"`
+	Frames      int           `json:"frames"`
+	Score       float64       `json:"score"` // percent of changed pixels in the zones
+	Motion      bool          `json:"motion"`
+	AtMotion    time.Time     `json:"at_motion"` // last time of the motion
+	Events      []string      `json:"events,omitempty"`
+	// --- internal variables
+	bg    []int  // background in 1/16 unit
+	mask  []bool // pixels in the zones
+	nmask int
+}
+
+func NewMotionDetector(sensitivity int, zones []MotionZone, hold time.Duration) *MotionDetector {
+	sensitivity = min(max(sensitivity, 1), 100)
+	return &MotionDetector{Sensitivity: sensitivity, Zones: zones, Hold: hold,
+		Threshold: 60 - sensitivity/2, MinArea: float64(101-sensitivity) / 20}
+}
+
+// make the mask of zones on the grid
+func (d *MotionDetector) makeMask() {
+	d.mask = make([]bool, MOTION_GRID_WIDTH*MOTION_GRID_HEIGHT)
+	zones := d.Zones
+	if len(zones) == 0 {
+		zones = []MotionZone{{0, 0, 100, 100}}
+	}
+	for _, z := range zones {
+		x0, x1 := z.X*MOTION_GRID_WIDTH/100, max((z.X+z.W)*MOTION_GRID_WIDTH/100, z.X*MOTION_GRID_WIDTH/100+1)
+		y0, y1 := z.Y*MOTION_GRID_HEIGHT/100, max((z.Y+z.H)*MOTION_GRID_HEIGHT/100, z.Y*MOTION_GRID_HEIGHT/100+1)
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				d.mask[y*MOTION_GRID_WIDTH+x] = true
+			}
+		}
+	}
+	d.nmask = 0
+	for _, on := range d.mask {
+		if on {
+			d.nmask++
+		}
+	}
+}
+
+// Add compares the jpeg frame with the background, the events are appended to Events
+func (d *MotionDetector) Add(data []byte, now time.Time) (score float64, err error) {
+	g, err := DecodeGrayJPEG(data, MOTION_GRID_WIDTH, MOTION_GRID_HEIGHT)
+	if err != nil {
+		return
+	}
+	d.Frames++
+	if d.bg == nil { // the first frame as the background
+		d.makeMask()
+		d.bg = make([]int, len(g.Pix))
+		for i, v := range g.Pix {
+			d.bg[i] = int(v) << 4
+		}
+		return
+	}
+	changed := 0
+	for i, v := range g.Pix {
+		diff := int(v)<<4 - d.bg[i]
+		if d.mask[i] && (diff >= d.Threshold<<4 || -diff >= d.Threshold<<4) {
+			changed++
+		}
+		d.bg[i] += diff / MOTION_BG_RATE
+	}
+	score = float64(changed) * 100 / float64(d.nmask)
+	d.Score = score
+
+	if score >= d.MinArea {
+		d.AtMotion = now
+		if !d.Motion {
+			d.Motion = true
+			d.Events = append(d.Events, "motion-start")
+		}
+	} else if d.Motion && now.Sub(d.AtMotion) >= d.Hold {
+		d.Motion = false
+		d.Events = append(d.Events, "motion-end")
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// MotionProcessor pushes motion-start/-end events and writes the scores into <track>@motion
+// ---------------------------------------------------------------------------------
+type MotionProcessor struct {
+	Trigger  string `json:"trigger,omitempty"` // record or snapshot
+	Held     bool   `json:"held"`              // the trigger is turned on by the motion
+	det      *MotionDetector
+	pc       *ProcContext
+	interval time.Duration // to check the frames
+	atCheck  time.Time
+	tracks   string // shoot tracks of the channel before the motion
+}
+
+func (d *MotionProcessor) Init(pc *ProcContext) (err error) {
+	if !strings.Contains(pc.Mime, "jpeg") {
+		return fmt.Errorf("not jpeg track: %s", pc.Mime)
+	}
+	zones, err := ParseMotionZones(pc.Params["zones"])
+	if err != nil {
+		return
+	}
+	sensitivity := pc.getParamInt("sensitivity", 50)
+	hold := pc.getParamInt("hold", int(MOTION_HOLD_TIME/time.Second))
+	fps := pc.getParamInt("fps", MOTION_CHECK_FPS)
+	if sensitivity < 1 || sensitivity > 100 || hold < 0 || fps < 1 {
+		return fmt.Errorf("invalid motion params: %v", pc.Params)
+	}
+	d.Trigger = pc.Params["trigger"]
+	if d.Trigger != "" && d.Trigger != "record" && d.Trigger != "snapshot" {
+		return fmt.Errorf("invalid trigger: %s [record|snapshot]", d.Trigger)
+	}
+	d.det = NewMotionDetector(sensitivity, zones, time.Duration(hold)*time.Second)
+	d.interval = time.Second / time.Duration(fps)
+	d.pc = pc
+	pc.OutMime = "application/json"
+	return
+}
+
+func (d *MotionProcessor) Process(bs *Slot) (outs []Slot, events []ProcEvent, err error) {
+	if bs.FrameType != websocket.BinaryMessage || !IsKeyFrame("jpeg", bs.Data) {
+		return
+	}
+	now := time.Now()
+	if now.Sub(d.atCheck) < d.interval {
+		return
+	}
+	d.atCheck = now
+
+	score, err := d.det.Add(bs.Data, now)
+	if err != nil {
+		return
+	}
+	data, _ := json.Marshal(struct {
+		Score  float64   `json:"score"`
+		Motion bool      `json:"motion"`
+		Time   time.Time `json:"time"`
+	}{score, d.det.Motion, now})
+	outs = append(outs, Slot{FrameType: websocket.BinaryMessage, Capture: bs.Capture, Data: data})
+
+	for _, name := range d.det.Events {
+		data, _ := json.Marshal(struct {
+			Track string  `json:"track"`
+			Score float64 `json:"score"`
+		}{d.pc.Source + "/" + d.pc.Track, math.Round(score*100) / 100})
+		events = append(events, ProcEvent{Name: name, Data: string(data)})
+		d.trigger(name == "motion-start")
+	}
+	d.det.Events = d.det.Events[:0]
+	return
+}
+
+// turn on/off the recording or snapshot of channel by the motion,
+// kept as it is if turned on by the operator, and restored at the end of motion
+func (d *MotionProcessor) trigger(on bool) {
+	if d.Trigger == "" || on == d.Held {
+		return
+	}
+	chn := pStudio.findChannelByID(d.pc.ChannelID)
+	if chn == nil {
+		return
+	}
+	chn.Lock()
+	recording, shooting, tracks := chn.RecordAuto, chn.ShootAuto, chn.ShootTracks
+	chn.Unlock()
+
+	switch d.Trigger {
+	case "record":
+		if on && recording {
+			return
+		}
+		chn.setRecordAuto(on, "")
+	case "snapshot":
+		if on && shooting {
+			return
+		}
+		if on {
+			d.tracks = tracks
+			tracks = d.pc.Source + "/" + d.pc.Track
+		} else if tracks = d.tracks; tracks == "" {
+			tracks = "all" // to clear the tracks
+		}
+		chn.setShootAuto(on, 0, tracks)
+	}
+	d.Held = on
+}
+
+func (d *MotionProcessor) Close() error {
+	d.trigger(false)
+	return nil
+}
+
+//=================================================================================
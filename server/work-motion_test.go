@@ -0,0 +1,158 @@
+// =================================================================================
+// Filename: work-motion_test.go
+// Function: Test functions for work-motion.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// ---------------------------------------------------------------------------------
+// make the gray jpeg of 320x240 with the white box of x:y:w:h in percent, if any
+func newMotionJPEG(t *testing.T, box ...int) []byte {
+	img := image.NewGray(image.Rect(0, 0, 320, 240))
+	for i := range img.Pix {
+		img.Pix[i] = 80
+	}
+	if len(box) == 4 {
+		for y := box[1] * 240 / 100; y < (box[1]+box[3])*240/100; y++ {
+			for x := box[0] * 320 / 100; x < (box[0]+box[2])*320/100; x++ {
+				img.SetGray(x, y, color.Gray{240})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMotionDetector(t *testing.T) {
+	for _, str := range []string{"0:0:50", "50:50:60:10", "a:0:10:10"} {
+		if _, err := ParseMotionZones(str); err == nil {
+			t.Fatal("invalid zone is accepted:", str)
+		}
+	}
+	zones, err := ParseMotionZones("50:0:50:100")
+	if err != nil || len(zones) != 1 {
+		t.Fatal("invalid zones:", zones, err)
+	}
+
+	still, left, right := newMotionJPEG(t), newMotionJPEG(t, 10, 10, 20, 20), newMotionJPEG(t, 70, 10, 20, 20)
+	m := NewMotionDetector(50, zones, MOTION_HOLD_TIME)
+	now := time.Now()
+	m.Add(still, now)
+	if score, _ := m.Add(still, now); score != 0 || m.Motion {
+		t.Fatal("motion in still frames:", score)
+	}
+	if score, _ := m.Add(left, now); score != 0 || m.Motion {
+		t.Fatal("motion out of zones:", score)
+	}
+	if score, _ := m.Add(right, now); score < 5 || !m.Motion || !slices.Equal(m.Events, []string{"motion-start"}) {
+		t.Fatal("motion is not detected:", score, m.Events)
+	}
+
+	// ended after the hold time
+	m.Add(still, now.Add(MOTION_HOLD_TIME/2))
+	if !m.Motion {
+		t.Fatal("motion is ended early")
+	}
+	m.Add(still, now.Add(MOTION_HOLD_TIME))
+	if m.Motion || !slices.Equal(m.Events, []string{"motion-start", "motion-end"}) {
+		t.Fatal("motion is not ended:", m.Events)
+	}
+	if _, err := m.Add([]byte("not jpeg"), now); err == nil {
+		t.Fatal("invalid jpeg is accepted")
+	}
+}
+
+func TestMotionProcessor(t *testing.T) {
+	chn := pStudio.addChannel(NewChannelPointer())
+	defer pStudio.deleteChannel(chn)
+	chn.ShootTracks = "base/other" // configured by the operator
+
+	p, _ := NewProcessor("motion")
+	pc := &ProcContext{ChannelID: chn.ID, Source: "cam", Track: "video", Mime: MIME_VIDEO_JPEG,
+		Params: map[string]string{"trigger": "snapshot", "fps": "1000"}}
+	if err := p.Init(&ProcContext{Mime: MIME_AUDIO_OPUS}); err == nil {
+		t.Fatal("not jpeg track is accepted")
+	}
+	if err := p.Init(pc); err != nil || pc.OutMime != "application/json" {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, data := range [][]byte{newMotionJPEG(t), newMotionJPEG(t, 40, 40, 20, 20)} {
+		time.Sleep(5 * time.Millisecond)
+		outs, events, err := p.Process(&Slot{FrameType: websocket.BinaryMessage, Data: data})
+		if err != nil || len(outs) != 1 {
+			t.Fatal("invalid process:", len(outs), err)
+		}
+		for _, ev := range events {
+			var v struct {
+				Track string  `json:"track"`
+				Score float64 `json:"score"`
+			}
+			if json.Unmarshal([]byte(ev.Data), &v) != nil || v.Track != "cam/video" || v.Score <= 0 {
+				t.Fatal("invalid event:", ev.Data)
+			}
+			names = append(names, ev.Name)
+		}
+	}
+	chn.Lock()
+	auto, tracks := chn.ShootAuto, chn.ShootTracks
+	chn.Unlock()
+	if !slices.Equal(names, []string{"motion-start"}) || !auto || tracks != "cam/video" {
+		t.Fatal("snapshot is not triggered:", names, auto, tracks)
+	}
+
+	p.Close()
+	chn.Lock()
+	auto, tracks = chn.ShootAuto, chn.ShootTracks
+	chn.Unlock()
+	if auto || tracks != "base/other" {
+		t.Fatal("snapshot is not restored:", auto, tracks)
+	}
+
+	// the recording turned on by the operator is kept at the end of motion
+	chn.setRecordAuto(true, "")
+	defer chn.setRecordAuto(false, "")
+	p, _ = NewProcessor("motion")
+	pc.Params["trigger"] = "record"
+	if err := p.Init(pc); err != nil {
+		t.Fatal(err)
+	}
+	mp := p.(*MotionProcessor)
+	mp.trigger(true)
+	mp.trigger(false)
+	chn.Lock()
+	auto = chn.RecordAuto
+	chn.Unlock()
+	if !auto || mp.Held {
+		t.Fatal("recording of the operator is turned off")
+	}
+	chn.setRecordAuto(false, "")
+	mp.trigger(true)
+	p.Close()
+	chn.Lock()
+	auto = chn.RecordAuto
+	chn.Unlock()
+	if auto {
+		t.Fatal("recording by the motion is not stopped")
+	}
+}
+
+//=================================================================================
@@ -0,0 +1,251 @@
+// =================================================================================
+// Filename: work-player.go
+// Function: Player of a recorded RSSP file as a virtual publisher of channel
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------------
+const (
+	PLAYER_MIN_SPEED = 0.1
+	PLAYER_MAX_SPEED = 16.0
+	PLAYER_MAX_WAIT  = 100 * time.Millisecond // max sleep to check the stop
+)
+
+// ---------------------------------------------------------------------------------
+// Player republishes the tracks of a recording into the channel
+// ---------------------------------------------------------------------------------
+type Player struct {
+	FileName  string        `json:"file_name"`
+	ChannelID string        `json:"channel_id"`
+	Speed     float64       `json:"speed"` // 1.0: original timing
+	Loop      bool          `json:"loop"`
+	Seek      time.Duration `json:"seek"` // offset from the start of recording
+	// --- internal variables
+	wkr  *Worker
+	chn  *Channel
+	pubs map[int]*Session // publisher session per track index
+}
+
+func NewPlayerPointer(fname, chid string) (d *Player) {
+	d = &Player{FileName: fname, ChannelID: chid, Speed: 1.0}
+	d.pubs = make(map[int]*Session)
+	return
+}
+
+// get the path of recording in DirRecord, not allowed to go out of it
+func GetRecordPath(fname string) (path string, err error) {
+	if fname == "" || strings.Contains(fname, "..") {
+		err = fmt.Errorf("invalid record file: %s", fname)
+		return
+	}
+	if filepath.Ext(fname) == "" {
+		fname += RECORD_FILE_EXT
+	}
+	path = filepath.Join(mConfig.DirRecord, filepath.Clean("/"+fname))
+	return
+}
+
+// startPlayer opens the recording and starts to play it as a worker
+func (d *Studio) startPlayer(p *Player) (w *Worker, err error) {
+	if p.Speed < PLAYER_MIN_SPEED || p.Speed > PLAYER_MAX_SPEED {
+		err = fmt.Errorf("invalid speed: %v (%v-%v)", p.Speed, PLAYER_MIN_SPEED, PLAYER_MAX_SPEED)
+		return
+	}
+	path, err := GetRecordPath(p.FileName)
+	if err != nil {
+		return
+	}
+	rr, err := OpenRecordReader(path)
+	if err != nil {
+		return
+	}
+	p.chn = d.setChannelByIDState(p.ChannelID, Using)
+	if p.chn == nil {
+		rr.Close()
+		err = fmt.Errorf("not found channel: %s", p.ChannelID)
+		return
+	}
+	if p.chn.Blocked {
+		rr.Close()
+		d.afterSetChannelIdleByID(p.ChannelID)
+		err = fmt.Errorf("not allowed to use: %s", p.ChannelID)
+		return
+	}
+
+	w = d.addNewWorkerWithParams("/worker/moth/player", p.ChannelID, "player")
+	w.Proto = "rssp"
+	w.Addr = p.FileName
+	p.wkr = w
+
+	go p.runPlayer(rr)
+	return
+}
+
+// stopPlayer lets the player worker end at the next check
+func (d *Studio) stopPlayer(id string) (err error) {
+	w := d.findWorkerByID(id)
+	if w == nil || w.Style != "player" {
+		err = fmt.Errorf("not found player: %s", id)
+		return
+	}
+	w.Lock()
+	w.State = Idle
+	w.Unlock()
+	return
+}
+
+func (d *Player) isPlaying() bool {
+	d.wkr.Lock()
+	defer d.wkr.Unlock()
+	return d.wkr.State == Using
+}
+
+// ---------------------------------------------------------------------------------
+func (d *Player) runPlayer(rr *RecordReader) {
+	log.Println("i.runPlayer:", d.FileName, d.ChannelID, d.Speed, d.Loop, d.Seek)
+	defer log.Println("o.runPlayer:", d.FileName, d.ChannelID)
+
+	defer func() {
+		rr.Close()
+		for _, s := range d.pubs {
+			d.deletePublisher(s)
+		}
+		pStudio.deleteWorker(d.wkr)
+		pStudio.afterSetChannelIdleByID(d.ChannelID)
+	}()
+
+	for {
+		err := d.playRecord(rr)
+		if err != nil && err != io.EOF {
+			log.Println("player:", err)
+			return
+		}
+		if err == nil || !d.Loop { // stopped or the end of file
+			return
+		}
+		err = rr.Rewind()
+		if err != nil {
+			log.Println("player:", err)
+			return
+		}
+	}
+}
+
+// play the slots of the recording from the seek position to the end of file
+//   - returns nil if stopped, io.EOF at the end of file
+func (d *Player) playRecord(rr *RecordReader) (err error) {
+	var tfirst, tstart time.Time
+	started := make(map[int]bool) // track started at the key frame after seek
+
+	for d.isPlaying() {
+		var index int
+		var bs *Slot
+		index, bs, err = rr.readSlot()
+		if err != nil {
+			return
+		}
+		if tfirst.IsZero() {
+			tfirst = bs.Time
+		}
+		offset := bs.Time.Sub(tfirst)
+		if offset < d.Seek {
+			continue
+		}
+		if index >= len(rr.Tracks) {
+			continue
+		}
+		rt := rr.Tracks[index]
+		if !started[index] {
+			if strings.HasPrefix(rt.Mime, "video/") && !bs.Key {
+				continue
+			}
+			started[index] = true
+		}
+		if tstart.IsZero() {
+			tstart = time.Now().Add(-time.Duration(float64(offset-d.Seek) / d.Speed))
+		}
+
+		// wait until the time scaled by speed, checking the stop
+		due := tstart.Add(time.Duration(float64(offset-d.Seek) / d.Speed))
+		for wait := time.Until(due); wait > 0; wait = time.Until(due) {
+			if !d.isPlaying() {
+				return nil
+			}
+			time.Sleep(min(wait, PLAYER_MAX_WAIT))
+		}
+
+		s := d.pubs[index]
+		if s == nil {
+			s, err = d.addPublisher(rt)
+			if err != nil {
+				return
+			}
+			d.pubs[index] = s
+		}
+		d.writeSlot(s, rt, bs)
+		d.wkr.AtUsed = time.Now()
+	}
+	return nil
+}
+
+// write the recorded slot into the track as a live one
+func (d *Player) writeSlot(s *Session, rt RecordTrack, bs *Slot) {
+	b := s.trk.getRingByOrder(BUFFER_NUM_FORE)
+	if s.trk.getRingMime(b) != rt.Mime {
+		s.trk.setRingMime(b, rt.Mime)
+	}
+
+	bs.Head = s.ID
+	bs.getLengthTime()
+	if s.trk.inspectCodec(bs, b) { // keep the recorded key frame otherwise
+		bs.Key = true
+	}
+	bs.Seq = b.writeSlot(*bs, false)
+	b.cacheGopSlot(bs.Seq, bs.Key)
+	s.recordDVRSlot(b, bs)
+
+	s.countInSlot(bs.Length)
+}
+
+// add a publisher session for the recorded track
+func (d *Player) addPublisher(rt RecordTrack) (s *Session, err error) {
+	if pStudio.findPublisherByResource(d.ChannelID, rt.Source, rt.Track) != nil {
+		err = fmt.Errorf("resource [%s/%s/%s] already used", d.ChannelID, rt.Source, rt.Track)
+		return
+	}
+
+	s = pStudio.addNewSessionWithNameRequest("/pang/int/pub", d.wkr.ID)
+	s.chn = d.chn
+	s.ChannelID = d.chn.ID
+	s.src, s.trk, err = d.chn.addSourceTrackBySize(rt.Source, rt.Track, BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+	if err != nil {
+		pStudio.deleteSessionWithClose(s)
+		return
+	}
+	s.SourceID = rt.Source
+	s.TrackID = rt.Track
+	d.chn.addPublisher(s)
+	d.chn.pushEvent("pub-in", s.ID, s.Name, s.RequestID)
+	log.Println("player track:", rt.Index, rt.Source, rt.Track, rt.Mime)
+	return
+}
+
+func (d *Player) deletePublisher(s *Session) {
+	d.chn.pushEvent("pub-out", s.ID, s.Name, s.RequestID)
+	s.resetTrackInfo()
+	d.chn.deletePublisher(s)
+	pStudio.deleteSessionWithClose(s)
+}
+
+//=================================================================================
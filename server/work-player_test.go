@@ -0,0 +1,71 @@
+// =================================================================================
+// Filename: work-player_test.go
+// Function: Test functions for work-player.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------------------------------------
+func TestPlayerSeekAndSpeed(t *testing.T) {
+	dir := mConfig.DirRecord
+	mConfig.DirRecord = t.TempDir()
+	defer func() { mConfig.DirRecord = dir }()
+
+	// 2 seconds of 10 slots per second, a key frame every 0.5 second
+	rw := NewRecordWriter(mConfig.DirRecord, "chplay")
+	rw.writeTrack(RecordTrack{Index: 0, Source: "base", Track: "video", Mime: "video/h264"})
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 20; i++ {
+		bs := newSeqSlot(uint64(i + 1))
+		bs.Seq = uint64(i + 1)
+		bs.Time = start.Add(time.Duration(i) * 100 * time.Millisecond)
+		bs.Key = (i%5 == 0)
+		if err := rw.writeSlot(0, &bs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rw.closeFile()
+
+	chn := pStudio.addChannel(NewChannelPointer())
+	defer pStudio.deleteChannel(chn)
+
+	// from 0.7s, starting at the key frame of 1.0s, at 4x speed
+	p := NewPlayerPointer(filepath.Base(rw.FileName), chn.ID)
+	p.Speed = 4
+	p.Seek = 700 * time.Millisecond
+	tstart := time.Now()
+	w, err := pStudio.startPlayer(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && pStudio.findWorkerByID(w.ID) != nil; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	elapsed := time.Since(tstart)
+	if pStudio.findWorkerByID(w.ID) != nil {
+		t.Fatal("player is not ended")
+	}
+	if elapsed < 200*time.Millisecond || elapsed > time.Second {
+		t.Fatal("invalid play time:", elapsed)
+	}
+
+	_, trk, _ := chn.addSourceTrackBySize("base", "video", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+	if in, _ := trk.getBytes(); in != 0 { // reset at the end of publisher
+		t.Fatal("track is not reset:", in)
+	}
+	if in, _ := chn.getBytes(); in != 10*8 {
+		t.Fatal("invalid played bytes:", in)
+	}
+	if _, err = pStudio.startPlayer(NewPlayerPointer("../etc/passwd", chn.ID)); err == nil {
+		t.Fatal("invalid path is allowed")
+	}
+}
+
+//=================================================================================
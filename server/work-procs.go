@@ -0,0 +1,518 @@
+// =================================================================================
+// Filename: work-procs.go
+// Function: In-process frame processors attached to channel tracks
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------------
+const (
+	PROCS_SCAN_PERIOD = time.Second
+	PROCS_MAX_GAP     = 30 // slots behind the write to skip to the latest
+	PROCS_TICK_PERIOD = 100 * time.Millisecond
+)
+
+// ---------------------------------------------------------------------------------
+// Processor handles the slots of a track in the server process
+//   - Init is called once with the context before the first slot
+//   - Process returns the slots for the derived track <track>@<name>
+//     and the events pushed into the channel, in the order of slots
+//   - Close is called at the end, even if Init failed
+//
+// A processor registers its factory in init() of its own file,
+// and is attached by the query option proc=<spec> or the manager command,
+// or to all the tracks of its mime by RegisterAutoProcessor.
+// ---------------------------------------------------------------------------------
+type Processor interface {
+	Init(pc *ProcContext) error
+	Process(bs *Slot) (outs []Slot, events []ProcEvent, err error)
+	Close() error
+}
+
+// ProcTicker is the processor called at every PROCS_TICK_PERIOD, also without slots
+type ProcTicker interface {
+	Tick(now time.Time) (events []ProcEvent)
+}
+
+// ProcContext is the information of track given to the processor
+type ProcContext struct {
+	ChannelID string            `json:"channel_id"`
+	Source    string            `json:"source"`
+	Track     string            `json:"track"`
+	Mime      string            `json:"mime"`
+	Params    map[string]string `json:"params,omitempty"`   // from the spec, name,key=value,...
+	OutMime   string            `json:"out_mime,omitempty"` // mime of the derived track, set by Init if any
+	// --- internal variables
+	trk *Track // the input track, to set its info by the processor
+}
+
+// get the parameter of int, the default if not given or invalid
+func (d *ProcContext) getParamInt(key string, def int) int {
+	if n, err := strconv.Atoi(d.Params[key]); err == nil {
+		return n
+	}
+	return def
+}
+
+// ProcEvent is pushed into the channel as the event of name
+type ProcEvent struct {
+	Name string `json:"name"`
+	Data string `json:"data"`
+}
+
+// ---------------------------------------------------------------------------------
+// registry of processors by name
+// ---------------------------------------------------------------------------------
+var procRegistry = struct {
+	sync.RWMutex
+	factories map[string]func() Processor
+	autos     map[string]func(mime string) bool // processors attached by the mime of track
+}{factories: make(map[string]func() Processor), autos: make(map[string]func(mime string) bool)}
+
+// RegisterProcessor adds the factory of processor, replacing the same name
+func RegisterProcessor(name string, factory func() Processor) {
+	procRegistry.Lock()
+	defer procRegistry.Unlock()
+	procRegistry.factories[name] = factory
+}
+
+// RegisterAutoProcessor attaches the processor to the tracks of mime matched,
+// without the spec of track, and regardless of ProcsAuto
+func RegisterAutoProcessor(name string, match func(mime string) bool) {
+	procRegistry.Lock()
+	defer procRegistry.Unlock()
+	procRegistry.autos[name] = match
+}
+
+// GetAutoProcessor returns the name of processor attached to the mime, the first in name order
+func GetAutoProcessor(mime string) (name string) {
+	procRegistry.RLock()
+	defer procRegistry.RUnlock()
+	for n, match := range procRegistry.autos {
+		if match(mime) && (name == "" || n < name) {
+			name = n
+		}
+	}
+	return
+}
+
+func NewProcessor(name string) (p Processor, err error) {
+	procRegistry.RLock()
+	factory := procRegistry.factories[name]
+	procRegistry.RUnlock()
+	if factory == nil {
+		err = fmt.Errorf("not found processor: %s [%s]", name, strings.Join(GetProcessorNames(), "|"))
+		return
+	}
+	p = factory()
+	return
+}
+
+func GetProcessorNames() (names []string) {
+	procRegistry.RLock()
+	defer procRegistry.RUnlock()
+	for name := range procRegistry.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// ParseProcSpec parses the spec of processor, name,key=value,...
+func ParseProcSpec(spec string) (name string, params map[string]string, err error) {
+	items := strings.Split(spec, ",")
+	name = strings.TrimSpace(items[0])
+	params = make(map[string]string)
+	for _, item := range items[1:] {
+		key, value, _ := strings.Cut(item, "=")
+		if key = strings.TrimSpace(key); key != "" {
+			params[key] = strings.TrimSpace(value)
+		}
+	}
+	procRegistry.RLock()
+	factory := procRegistry.factories[name]
+	procRegistry.RUnlock()
+	if factory == nil {
+		err = fmt.Errorf("not found processor: %s [%s]", name, strings.Join(GetProcessorNames(), "|"))
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// setProcsAuto turns on/off the processing, starting it at once if published
+func (d *Channel) setProcsAuto(on bool) {
+	d.Lock()
+	d.ProcsAuto = on
+	npub := d.countSourcePublishers()
+	d.Unlock()
+
+	if on && npub > 0 {
+		d.startProcessor()
+	}
+}
+
+// attachTrackProc sets the processor spec of track and turns on the processing,
+// detached if the spec is "off"
+func (d *Channel) attachTrackProc(t *Track, spec string) (err error) {
+	if spec == "" {
+		return
+	}
+	if spec != "off" {
+		_, _, err = ParseProcSpec(spec)
+		if err != nil {
+			return
+		}
+	}
+	t.Lock()
+	t.ProcName = spec
+	if spec == "off" {
+		t.ProcName = ""
+	}
+	t.Unlock()
+
+	if spec != "off" {
+		d.setProcsAuto(true)
+	}
+	return
+}
+
+// setTrackProc attaches the processor to the track of source/track by the manager
+func (d *Channel) setTrackProc(label, spec string) (err error) {
+	source, track, ok := strings.Cut(label, "/")
+	if !ok {
+		err = fmt.Errorf("invalid track: %s [source/track]", label)
+		return
+	}
+	_, t, err := d.findSourceTrackByLabel(source, track)
+	if err != nil {
+		return
+	}
+	if spec == "" {
+		spec = "off"
+	}
+	return d.attachTrackProc(t, spec)
+}
+
+// startProcessor starts the processor worker of channel at pub-in, for the tracks
+// of the processor spec if ProcsAuto is set, and of the auto processors always
+func (d *Channel) startProcessor() {
+	d.Lock()
+	if d.ProcsState == Using {
+		d.Unlock()
+		return
+	}
+	d.ProcsState = Using
+	d.Unlock()
+
+	go d.runProcessor()
+}
+
+// processing while there are publishers other than the derived ones
+func (d *Channel) isProcessing() bool {
+	d.Lock()
+	defer d.Unlock()
+	return d.ProcsState == Using && d.countSourcePublishers() > 0
+}
+
+func (d *Channel) getProcsState() State {
+	d.Lock()
+	defer d.Unlock()
+	return d.ProcsState
+}
+
+// ---------------------------------------------------------------------------------
+// runProcessor runs the processors of tracks until the last pub-out
+func (d *Channel) runProcessor() {
+	var w *Worker
+	runners := make(map[*Buffer]*ProcRunner)
+	var wg sync.WaitGroup
+	defer func() {
+		for _, r := range runners {
+			r.stop()
+		}
+		wg.Wait()
+		if w != nil {
+			pStudio.deleteWorker(w)
+			log.Println("o.runProcessor:", d.ID)
+		}
+		d.Lock()
+		d.ProcsState = Idle
+		d.Unlock()
+	}()
+
+	for d.isProcessing() {
+		specs := d.scanProcsTracks()
+		for b, r := range runners { // detached or changed
+			if pr := specs[b]; pr == nil || pr.Spec != r.Spec || pr.Mime != r.Mime {
+				r.stop()
+				<-r.ended // to release the derived track
+				delete(runners, b)
+			}
+		}
+		for b, r := range specs {
+			if runners[b] != nil {
+				continue
+			}
+			if w == nil { // registered only if the channel has the tracks to process
+				log.Println("i.runProcessor:", d.ID)
+				w = pStudio.addNewWorkerWithParams("/worker/moth/procs", d.ID, "procs")
+			}
+			runners[b] = r
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r.run()
+			}()
+		}
+		if w != nil {
+			w.AtUsed = time.Now()
+		}
+		time.Sleep(PROCS_SCAN_PERIOD)
+	}
+}
+
+// get the runners of tracks having the processor and mime, not derived ones
+//   - the spec of track if ProcsAuto, or the auto processor of its mime
+func (d *Channel) scanProcsTracks() (runners map[*Buffer]*ProcRunner) {
+	runners = make(map[*Buffer]*ProcRunner)
+	d.Lock()
+	defer d.Unlock()
+	for _, src := range d.Sources {
+		src.RLock()
+		for _, t := range src.Tracks {
+			t.RLock()
+			spec := t.ProcName
+			t.RUnlock()
+			b := t.getRingByOrder(BUFFER_NUM_FORE)
+			if b == nil || strings.Contains(t.Label, TRANS_DERIVED_MARK) {
+				continue
+			}
+			mime := t.getRingMime(b)
+			if spec == "" || !d.ProcsAuto {
+				spec = GetAutoProcessor(mime)
+			}
+			if spec == "" || mime == "" {
+				continue
+			}
+			runners[b] = &ProcRunner{Source: src.Label, Track: t.Label, Spec: spec, Mime: mime,
+				chn: d, trk: t, ring: b, done: make(chan struct{}), ended: make(chan struct{})}
+		}
+		src.RUnlock()
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// ProcRunner feeds the slots of a track into the processor
+// ---------------------------------------------------------------------------------
+type ProcRunner struct {
+	Source  string `json:"source"`
+	Track   string `json:"track"`
+	Spec    string `json:"spec"`
+	Mime    string `json:"mime"`
+	Derived string `json:"derived"`
+	Slots   int    `json:"slots"` // number of slots processed
+	Errors  int    `json:"errors"`
+	// --- internal variables
+	chn   *Channel
+	trk   *Track
+	ring  *Buffer
+	pub   *Session // publisher of the derived track, added at the first output
+	done  chan struct{}
+	ended chan struct{} // closed at the end of run
+}
+
+func (d *ProcRunner) stop() {
+	select {
+	case <-d.done:
+	default:
+		close(d.done)
+	}
+}
+
+func (d *ProcRunner) isStopped() bool {
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *ProcRunner) run() {
+	log.Println("i.ProcRunner:", d.Source, d.Track, d.Spec)
+	defer close(d.ended)
+	defer func() { log.Println("o.ProcRunner:", d.Source, d.Track, d.Slots, d.Errors) }()
+
+	name, params, err := ParseProcSpec(d.Spec)
+	if err != nil {
+		log.Println("procs:", err)
+		return
+	}
+	p, err := NewProcessor(name)
+	if err != nil {
+		log.Println("procs:", err)
+		return
+	}
+	defer p.Close()
+	d.Derived = d.Track + TRANS_DERIVED_MARK + name
+	defer func() {
+		if d.pub != nil {
+			deleteDerivedPublisher(d.pub)
+		}
+	}()
+
+	pc := &ProcContext{ChannelID: d.chn.ID, Source: d.Source, Track: d.Track, Mime: d.Mime, Params: params, trk: d.trk}
+	err = p.Init(pc)
+	if err != nil {
+		log.Println("procs:", name, err)
+		return
+	}
+	if pc.OutMime == "" {
+		pc.OutMime = d.Mime
+	}
+
+	path := "/proc/" + name + "/" + d.Source + "/" + d.Track
+	lseq := d.ring.getWriteSeq()
+	if slots := d.ring.getGopSlots(); len(slots) > 0 {
+		lseq = slots[0].Seq - 1
+	}
+	ticker, _ := p.(ProcTicker)
+	var ttick time.Time
+	for !d.isStopped() {
+		if now := time.Now(); ticker != nil && now.Sub(ttick) >= PROCS_TICK_PERIOD {
+			ttick = now
+			for _, ev := range d.tick(ticker, now) {
+				d.chn.pushEvent(ev.Name, ev.Data, path, "")
+			}
+		}
+		bs, nseq, lost := d.ring.readSlotNext(lseq, PROCS_MAX_GAP)
+		if lost > 0 {
+			log.Println("procs lost:", d.Track, lost)
+		}
+		lseq = nseq
+		if bs == nil {
+			time.Sleep(5 * time.Millisecond)
+			continue
+		}
+		if bs.To != "" { // the directed slot is not processed
+			continue
+		}
+		outs, events, err := d.process(p, bs)
+		d.Slots++
+		if err != nil {
+			d.Errors++
+			log.Println("procs:", name, err)
+			continue
+		}
+		for _, ev := range events {
+			d.chn.pushEvent(ev.Name, ev.Data, path, "")
+		}
+		for i := range outs {
+			if d.pub == nil {
+				d.pub, err = addDerivedPublisher(d.chn, d.Source, d.Derived, pc.OutMime)
+				if err != nil {
+					log.Println("procs:", err)
+					return
+				}
+			}
+			outs[i].Head = d.pub.ID
+			writeDerivedSlot(d.pub, &outs[i])
+		}
+	}
+}
+
+// process the slot, recovering the panic of processor not to stop the server
+func (d *ProcRunner) process(p Processor, bs *Slot) (outs []Slot, events []ProcEvent, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic in processor: %v", r)
+		}
+	}()
+	return p.Process(bs)
+}
+
+func (d *ProcRunner) tick(p ProcTicker, now time.Time) (events []ProcEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			d.Errors++
+			log.Println("procs: panic in tick:", r)
+		}
+	}()
+	return p.Tick(now)
+}
+
+// ---------------------------------------------------------------------------------
+// built-in processors, also the samples of the interface
+//   - pass: copies the slots into the derived track
+//   - count: pushes the event of count every n slots, count,n=100
+//
+// ---------------------------------------------------------------------------------
+func init() {
+	RegisterProcessor("pass", func() Processor { return &PassProcessor{} })
+	RegisterProcessor("count", func() Processor { return &CountProcessor{} })
+}
+
+type PassProcessor struct{}
+
+func (d *PassProcessor) Init(pc *ProcContext) error { return nil }
+
+func (d *PassProcessor) Process(bs *Slot) (outs []Slot, events []ProcEvent, err error) {
+	out := *bs
+	out.Data = append([]byte(nil), bs.Data...)
+	outs = append(outs, out)
+	return
+}
+
+func (d *PassProcessor) Close() error { return nil }
+
+type CountProcessor struct {
+	N      int `json:"n"`
+	Slots  int `json:"slots"`
+	Bytes  int `json:"bytes"`
+	Keys   int `json:"keys"`
+	source string
+}
+
+func (d *CountProcessor) Init(pc *ProcContext) error {
+	d.N = pc.getParamInt("n", 100)
+	if d.N < 1 {
+		return fmt.Errorf("invalid n: %d", d.N)
+	}
+	d.source = pc.Source + "/" + pc.Track
+	return nil
+}
+
+func (d *CountProcessor) Process(bs *Slot) (outs []Slot, events []ProcEvent, err error) {
+	d.Slots++
+	d.Bytes += len(bs.Data)
+	if bs.Key {
+		d.Keys++
+	}
+	if d.Slots%d.N == 0 {
+		data, _ := json.Marshal(struct {
+			Track string `json:"track"`
+			Slots int    `json:"slots"`
+			Bytes int    `json:"bytes"`
+			Keys  int    `json:"keys"`
+		}{d.source, d.Slots, d.Bytes, d.Keys})
+		events = append(events, ProcEvent{Name: "proc-count", Data: string(data)})
+	}
+	return
+}
+
+func (d *CountProcessor) Close() error { return nil }
+
+//=================================================================================
@@ -0,0 +1,118 @@
+// =================================================================================
+// Filename: work-procs_test.go
+// Function: Test functions for work-procs.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------------------------------------
+// processor panicking at the slot of "panic", upper case of data otherwise
+type upperProcessor struct{}
+
+func (d *upperProcessor) Init(pc *ProcContext) error { return nil }
+
+func (d *upperProcessor) Process(bs *Slot) (outs []Slot, events []ProcEvent, err error) {
+	if string(bs.Data) == "panic" {
+		panic("test")
+	}
+	outs = append(outs, Slot{Data: bytes.ToUpper(bs.Data)})
+	return
+}
+
+func (d *upperProcessor) Close() error { return nil }
+
+func TestProcessorAttachDetach(t *testing.T) {
+	RegisterProcessor("test-upper", func() Processor { return &upperProcessor{} })
+
+	chn := NewChannelPointer()
+	_, trk, _ := chn.addSourceTrackBySize("base", "video", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+	b := trk.getRingByOrder(BUFFER_NUM_FORE)
+	trk.setRingMime(b, "text/plain")
+
+	s := NewSessionPointerWithName("/pang/ws/pub")
+	s.TrackID = "video"
+	chn.addPublisher(s)
+
+	if err := chn.setTrackProc("base/video", "none"); err == nil {
+		t.Fatal("unknown processor is attached")
+	}
+	if err := chn.setTrackProc("base/video", "test-upper,n=1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the panic of processor is recovered, and the next slots are processed
+	found := false
+	for i := 0; i < 100 && !found; i++ {
+		if i == 0 {
+			b.writeSlot(Slot{Data: []byte("panic"), Length: 5}, false)
+		}
+		data := []byte(fmt.Sprintf("frame-%d", i))
+		b.writeSlot(Slot{Data: data, Length: len(data)}, false)
+		time.Sleep(20 * time.Millisecond)
+		chn.Lock()
+		out := chn.Sources["base"].Tracks["video@test-upper"]
+		chn.Unlock()
+		if out != nil {
+			found = waitSlotData(out.getRingByOrder(BUFFER_NUM_FORE), bytes.ToUpper(data), 100*time.Millisecond)
+		}
+	}
+	if !found {
+		t.Fatal("no data in the derived track")
+	}
+
+	// detached, the derived publisher is removed
+	if err := chn.setTrackProc("base/video", ""); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && pStudio.findPublisherByResource(chn.ID, "base", "video@test-upper") != nil; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if pStudio.findPublisherByResource(chn.ID, "base", "video@test-upper") != nil {
+		t.Fatal("derived track is not released")
+	}
+
+	chn.deletePublisher(s)
+	for i := 0; i < 100 && chn.getProcsState() == Using; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if chn.getProcsState() != Idle {
+		t.Fatal("processor is not stopped")
+	}
+}
+
+func TestParseProcSpec(t *testing.T) {
+	name, params, err := ParseProcSpec("count, n=10 ,x")
+	if err != nil || name != "count" || params["n"] != "10" || len(params) != 2 {
+		t.Fatal("invalid spec:", name, params, err)
+	}
+}
+
+func TestCountProcessor(t *testing.T) {
+	p, _ := NewProcessor("count")
+	if err := p.Init(&ProcContext{Source: "cam\x00", Track: "\"v\"", Params: map[string]string{"n": "2"}}); err != nil {
+		t.Fatal(err)
+	}
+	var events []ProcEvent
+	for i := 0; i < 2; i++ {
+		_, events, _ = p.Process(&Slot{Data: []byte("data"), Key: i == 0})
+	}
+	var v struct {
+		Track string `json:"track"`
+		Slots int    `json:"slots"`
+		Keys  int    `json:"keys"`
+	}
+	if len(events) != 1 || json.Unmarshal([]byte(events[0].Data), &v) != nil || v.Track != "cam\x00/\"v\"" || v.Slots != 2 || v.Keys != 1 {
+		t.Fatal("invalid count event:", events)
+	}
+}
+
+//=================================================================================
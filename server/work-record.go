@@ -0,0 +1,650 @@
+// =================================================================================
+// Filename: work-record.go
+// Function: Recorder of channel tracks to the RSSP container file
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------------
+// RSSP container: a sequence of chunks, [4CC][length(4) BE][payload] as the TCP framing
+//   - RHDR: json of RecordHeader, at the start of file
+//   - RTRK: json of RecordTrack, at the start of track and its mime change
+//   - RSLT: track index(2) + slot record of DVR
+//   - RGAP: json of RecordGap, the slots lost by the recorder before the next RSLT of track
+//   - REND: json of RecordHeader with the end time and counts, at the end of file
+//
+// ---------------------------------------------------------------------------------
+const (
+	RECORD_MARK_HEAD    = "RHDR"
+	RECORD_MARK_TRACK   = "RTRK"
+	RECORD_MARK_SLOT    = "RSLT"
+	RECORD_MARK_END     = "REND"
+	RECORD_MARK_GAP     = "RGAP"
+	RECORD_FILE_EXT     = ".rssp"
+	RECORD_MAX_SIZE     = 1024 * 1024 * 1024 // rotate the file by size, 1GB
+	RECORD_MAX_DURATION = time.Hour          // rotate the file by time
+	RECORD_SCAN_PERIOD  = time.Second        // period to find the new tracks
+)
+
+type RecordHeader struct {
+	Version   string    `json:"version"`
+	ChannelID string    `json:"channel_id"`
+	Name      string    `json:"name"`
+	AtStarted time.Time `json:"at_started"`
+	AtEnded   time.Time `json:"at_ended,omitempty"`
+	NumSlots  int       `json:"num_slots,omitempty"`
+	NumBytes  int64     `json:"num_bytes,omitempty"`
+	NumLost   int       `json:"num_lost,omitempty"`
+}
+
+// RecordGap marks the slots of track lost by the recorder
+type RecordGap struct {
+	Index int       `json:"index"`
+	Lost  int       `json:"lost"`
+	Time  time.Time `json:"time"`
+}
+
+type RecordTrack struct {
+	Index  int       `json:"index"`
+	Source string    `json:"source"`
+	Track  string    `json:"track"`
+	Mime   string    `json:"mime,omitempty"`
+	Codec  CodecInfo `json:"codec,omitempty"`
+}
+
+// ---------------------------------------------------------------------------------
+// RecordOutput is the output format of recorder, rssp or mp4
+// ---------------------------------------------------------------------------------
+type RecordOutput interface {
+	writeTrack(rt RecordTrack) error
+	writeSlot(index int, bs *Slot) error
+	writeGap(gap RecordGap) error
+	flush() error
+	closeFile() error
+	getFileName() string
+}
+
+func NewRecordOutput(format, dir, chid string) (d RecordOutput) {
+	switch format {
+	case "mp4":
+		d = NewMP4RecordWriter(dir, chid)
+	default:
+		d = NewRecordWriter(dir, chid)
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// RecordWriter writes a recording file, and rotates it by size or time
+// ---------------------------------------------------------------------------------
+type RecordWriter struct {
+	Dir      string
+	Header   RecordHeader
+	Tracks   []RecordTrack
+	MaxSize  int64
+	MaxTime  time.Duration
+	FileName string
+	// --- internal variables
+	file *os.File
+	bw   *bufio.Writer
+	size int64
+}
+
+func NewRecordWriter(dir, chid string) (d *RecordWriter) {
+	d = &RecordWriter{Dir: dir, MaxSize: RECORD_MAX_SIZE, MaxTime: RECORD_MAX_DURATION}
+	d.Header = RecordHeader{Version: Version, ChannelID: chid}
+	return
+}
+
+func (d *RecordWriter) getFileName() string {
+	return d.FileName
+}
+
+func (d *RecordWriter) writeChunk(mark string, payload []byte) (err error) {
+	head := make([]byte, 8)
+	copy(head[0:4], mark)
+	binary.BigEndian.PutUint32(head[4:8], uint32(len(payload)))
+	if _, err = d.bw.Write(head); err != nil {
+		return
+	}
+	if _, err = d.bw.Write(payload); err != nil {
+		return
+	}
+	d.size += int64(len(head) + len(payload))
+	return
+}
+
+func (d *RecordWriter) writeJSONChunk(mark string, v interface{}) (err error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	return d.writeChunk(mark, data)
+}
+
+// open a new file with the header and the current tracks
+func (d *RecordWriter) openFile(now time.Time) (err error) {
+	err = os.MkdirAll(d.Dir, os.ModePerm)
+	if err != nil {
+		return
+	}
+	d.Header.Name = fmt.Sprintf("%s-%s", d.Header.ChannelID, now.Format("20060102-150405"))
+	d.Header.AtStarted = now
+	d.Header.AtEnded = time.Time{}
+	d.Header.NumSlots, d.Header.NumBytes, d.Header.NumLost = 0, 0, 0
+
+	d.FileName = filepath.Join(d.Dir, d.Header.Name+RECORD_FILE_EXT)
+	for i := 1; ; i++ { // rotated in the same second
+		if _, err = os.Stat(d.FileName); err != nil {
+			break
+		}
+		d.FileName = filepath.Join(d.Dir, fmt.Sprintf("%s-%d%s", d.Header.Name, i, RECORD_FILE_EXT))
+	}
+	d.file, err = os.Create(d.FileName)
+	if err != nil {
+		return
+	}
+	d.bw = bufio.NewWriter(d.file)
+	d.size = 0
+	log.Println("record file:", d.FileName)
+
+	err = d.writeJSONChunk(RECORD_MARK_HEAD, d.Header)
+	if err != nil {
+		return
+	}
+	for _, rt := range d.Tracks {
+		err = d.writeJSONChunk(RECORD_MARK_TRACK, rt)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (d *RecordWriter) closeFile() (err error) {
+	if d.file == nil {
+		return
+	}
+	d.Header.AtEnded = time.Now()
+	d.writeJSONChunk(RECORD_MARK_END, d.Header)
+	d.bw.Flush()
+	err = d.file.Close()
+	d.file, d.bw = nil, nil
+	log.Println("record closed:", d.FileName, d.Header.NumSlots, d.Header.NumBytes)
+	return
+}
+
+// add or update the track, it is written in the file at once
+func (d *RecordWriter) writeTrack(rt RecordTrack) (err error) {
+	if rt.Index < len(d.Tracks) {
+		d.Tracks[rt.Index] = rt
+	} else {
+		d.Tracks = append(d.Tracks, rt)
+	}
+	if d.file == nil {
+		return
+	}
+	return d.writeJSONChunk(RECORD_MARK_TRACK, rt)
+}
+
+// write the slot of track, rotating the file by size or time
+func (d *RecordWriter) writeSlot(index int, bs *Slot) (err error) {
+	if d.file != nil && (d.size >= d.MaxSize || bs.Time.Sub(d.Header.AtStarted) >= d.MaxTime) {
+		err = d.closeFile()
+		if err != nil {
+			return
+		}
+	}
+	if d.file == nil {
+		err = d.openFile(bs.Time)
+		if err != nil {
+			return
+		}
+	}
+
+	payload := binary.BigEndian.AppendUint16(nil, uint16(index))
+	payload = append(payload, EncodeDVRSlot(bs)...)
+	err = d.writeChunk(RECORD_MARK_SLOT, payload)
+	if err != nil {
+		return
+	}
+	d.Header.NumSlots++
+	d.Header.NumBytes += int64(bs.Length)
+	return
+}
+
+// write the gap of track, lost before the next slot
+func (d *RecordWriter) writeGap(gap RecordGap) (err error) {
+	if d.file == nil { // before the first slot
+		return
+	}
+	d.Header.NumLost += gap.Lost
+	return d.writeJSONChunk(RECORD_MARK_GAP, gap)
+}
+
+func (d *RecordWriter) flush() error {
+	if d.bw == nil {
+		return nil
+	}
+	return d.bw.Flush()
+}
+
+// ---------------------------------------------------------------------------------
+// MP4RecordWriter writes the h264/h265/opus tracks to fMP4 files, and rotates them
+// at the key frame after the size or time, other tracks are not written
+// ---------------------------------------------------------------------------------
+type MP4RecordWriter struct {
+	Dir       string
+	ChannelID string
+	Tracks    []RecordTrack
+	MaxSize   int64
+	MaxTime   time.Duration
+	FileName  string
+	// --- internal variables
+	file    *os.File
+	bw      *bufio.Writer
+	mux     *MP4Muxer
+	ids     map[int]int  // record track index to mp4 track id, 0 if not supported
+	gaps    map[int]bool // video tracks waiting the key frame after the gap
+	tstart  time.Time
+	lastKey time.Time // the last key frame of video
+}
+
+func NewMP4RecordWriter(dir, chid string) (d *MP4RecordWriter) {
+	d = &MP4RecordWriter{Dir: dir, ChannelID: chid, MaxSize: RECORD_MAX_SIZE, MaxTime: RECORD_MAX_DURATION}
+	return
+}
+
+func (d *MP4RecordWriter) getFileName() string {
+	return d.FileName
+}
+
+func (d *MP4RecordWriter) writeTrack(rt RecordTrack) (err error) {
+	if rt.Index < len(d.Tracks) {
+		d.Tracks[rt.Index] = rt
+	} else {
+		d.Tracks = append(d.Tracks, rt)
+	}
+	if d.ids != nil {
+		delete(d.ids, rt.Index) // mime is changed, added if not initialized yet
+	}
+	return
+}
+
+func (d *MP4RecordWriter) openFile(now time.Time) (err error) {
+	err = os.MkdirAll(d.Dir, os.ModePerm)
+	if err != nil {
+		return
+	}
+	name := fmt.Sprintf("%s-%s", d.ChannelID, now.Format("20060102-150405"))
+	d.FileName = filepath.Join(d.Dir, name+".mp4")
+	for i := 1; ; i++ { // rotated in the same second
+		if _, err = os.Stat(d.FileName); err != nil {
+			break
+		}
+		d.FileName = filepath.Join(d.Dir, fmt.Sprintf("%s-%d.mp4", name, i))
+	}
+	d.file, err = os.Create(d.FileName)
+	if err != nil {
+		return
+	}
+	d.bw = bufio.NewWriter(d.file)
+	d.mux = NewMP4Muxer(d.bw)
+	d.ids = make(map[int]int)
+	d.tstart = now
+	log.Println("record file:", d.FileName)
+	return
+}
+
+func (d *MP4RecordWriter) closeFile() (err error) {
+	if d.file == nil {
+		return
+	}
+	d.mux.Close()
+	d.bw.Flush()
+	err = d.file.Close()
+	d.file, d.bw = nil, nil
+	log.Println("record closed:", d.FileName, d.mux.Size)
+	return
+}
+
+func (d *MP4RecordWriter) writeSlot(index int, bs *Slot) (err error) {
+	if index >= len(d.Tracks) || bs.Mark == RSSP_MARK_RTXT {
+		return
+	}
+	codec := GetRecordCodec(d.Tracks[index])
+	if codec == "" {
+		return
+	}
+	isVideo := codec != "opus"
+	if isVideo && d.gaps[index] { // not decodable until the next key frame
+		if !bs.Key && !bs.Param {
+			return
+		}
+		delete(d.gaps, index)
+	}
+	if isVideo && bs.Key {
+		d.lastKey = bs.Time
+	}
+	// rotate at the key frame of video, or any frame if no video
+	if d.file != nil && (d.mux.Size >= d.MaxSize || bs.Time.Sub(d.tstart) >= d.MaxTime) &&
+		(bs.Key || bs.Time.Sub(d.lastKey) > MP4_FRAGMENT_MAX) {
+		err = d.closeFile()
+		if err != nil {
+			return
+		}
+	}
+	if d.file == nil {
+		err = d.openFile(bs.Time)
+		if err != nil {
+			return
+		}
+	}
+
+	id, ok := d.ids[index]
+	if !ok {
+		id, _ = d.mux.addTrack(codec) // 0 if already initialized
+		d.ids[index] = id
+	}
+	if id == 0 {
+		return
+	}
+	return d.mux.writeSample(id, bs.Data, bs.Time)
+}
+
+// the video samples after the gap are dropped until the next key frame,
+// the gap is left in the timeline of track
+func (d *MP4RecordWriter) writeGap(gap RecordGap) (err error) {
+	if d.gaps == nil {
+		d.gaps = make(map[int]bool)
+	}
+	d.gaps[gap.Index] = true
+	return
+}
+
+func (d *MP4RecordWriter) flush() error {
+	if d.bw == nil {
+		return nil
+	}
+	return d.bw.Flush()
+}
+
+// ---------------------------------------------------------------------------------
+// RecordReader reads chunks of a recording file
+// ---------------------------------------------------------------------------------
+type RecordReader struct {
+	FileName string
+	Header   RecordHeader
+	Tracks   []RecordTrack
+	Gaps     []RecordGap
+	// --- internal variables
+	file *os.File
+	br   *bufio.Reader
+}
+
+func OpenRecordReader(fname string) (d *RecordReader, err error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return
+	}
+	d = &RecordReader{FileName: fname, file: file, br: bufio.NewReader(file)}
+
+	mark, payload, err := d.readChunk()
+	if err == nil && mark != RECORD_MARK_HEAD {
+		err = fmt.Errorf("not a record file: %s", fname)
+	}
+	if err == nil {
+		err = json.Unmarshal(payload, &d.Header)
+	}
+	if err != nil {
+		file.Close()
+		d = nil
+	}
+	return
+}
+
+func (d *RecordReader) Close() error {
+	return d.file.Close()
+}
+
+// rewind to the start of file, after the header
+func (d *RecordReader) Rewind() (err error) {
+	_, err = d.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return
+	}
+	d.br.Reset(d.file)
+	_, _, err = d.readChunk()
+	return
+}
+
+func (d *RecordReader) readChunk() (mark string, payload []byte, err error) {
+	head := make([]byte, 8)
+	_, err = io.ReadFull(d.br, head)
+	if err != nil {
+		return
+	}
+	mark = string(head[0:4])
+	payload = make([]byte, binary.BigEndian.Uint32(head[4:8]))
+	_, err = io.ReadFull(d.br, payload)
+	return
+}
+
+// readSlot returns the next slot and its track index, updating the tracks on the way
+//   - io.EOF at the end of file
+func (d *RecordReader) readSlot() (index int, bs *Slot, err error) {
+	for {
+		var mark string
+		var payload []byte
+		mark, payload, err = d.readChunk()
+		if err == io.ErrUnexpectedEOF { // not closed file in recording
+			err = io.EOF
+		}
+		if err != nil {
+			return
+		}
+		switch mark {
+		case RECORD_MARK_TRACK:
+			var rt RecordTrack
+			if err = json.Unmarshal(payload, &rt); err != nil {
+				return
+			}
+			for len(d.Tracks) <= rt.Index {
+				d.Tracks = append(d.Tracks, RecordTrack{Index: len(d.Tracks)})
+			}
+			d.Tracks[rt.Index] = rt
+		case RECORD_MARK_SLOT:
+			if len(payload) < 2 {
+				err = fmt.Errorf("invalid slot chunk")
+				return
+			}
+			index = int(binary.BigEndian.Uint16(payload[0:2]))
+			bs, err = ReadDVRSlot(strings.NewReader(string(payload[2:])))
+			return
+		case RECORD_MARK_GAP:
+			var gap RecordGap
+			if json.Unmarshal(payload, &gap) == nil {
+				d.Gaps = append(d.Gaps, gap)
+			}
+		case RECORD_MARK_END:
+			json.Unmarshal(payload, &d.Header)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------------
+// track reader of the recorder, registered as a blocking reader of the ring
+type recordTrackReader struct {
+	index int
+	ring  *Buffer
+	src   string
+	trk   *Track
+	lseq  uint64
+	mime  string
+	s     *Session
+}
+
+// startRecorder starts the recorder worker of channel if RecordAuto is set, at pub-in
+func (d *Channel) startRecorder() {
+	d.Lock()
+	if !d.RecordAuto || d.RecordState == Using {
+		d.Unlock()
+		return
+	}
+	d.RecordState = Using
+	d.Unlock()
+
+	go d.runRecorder()
+}
+
+// setRecordAuto turns on/off the recording, starting it at once if published
+//   - format is rssp or mp4, applied at the next start of recorder
+func (d *Channel) setRecordAuto(on bool, format string) {
+	d.Lock()
+	d.RecordAuto = on
+	if format != "" {
+		d.RecordFormat = format
+	}
+	npub := d.countSourcePublishers()
+	d.Unlock()
+
+	if on && npub > 0 {
+		d.startRecorder()
+	}
+}
+
+func (d *Channel) getRecordState() State {
+	d.Lock()
+	defer d.Unlock()
+	return d.RecordState
+}
+
+func (d *Channel) isRecording() bool {
+	d.Lock()
+	defer d.Unlock()
+	return d.RecordAuto && d.RecordState == Using && d.countSourcePublishers() > 0
+}
+
+// runRecorder writes the forward ring of all source/tracks until the last pub-out
+func (d *Channel) runRecorder() {
+	log.Println("i.runRecorder:", d.ID)
+	defer log.Println("o.runRecorder:", d.ID)
+
+	w := pStudio.addNewWorkerWithParams("/worker/moth/record", d.ID, "record")
+	defer pStudio.deleteWorker(w)
+
+	d.Lock()
+	format := d.RecordFormat
+	d.Unlock()
+	rw := NewRecordOutput(format, filepath.Join(mConfig.DirRecord, d.ID), d.ID)
+	defer func() {
+		rw.closeFile()
+		d.Lock()
+		d.RecordState = Idle
+		d.RecordFile = ""
+		d.Unlock()
+		d.pushEvent("record-out", rw.getFileName(), w.Name, w.ID)
+	}()
+	d.pushEvent("record-in", d.ID, w.Name, w.ID)
+
+	readers := make(map[*Buffer]*recordTrackReader)
+	defer func() {
+		for _, tr := range readers {
+			tr.s.seqRead.Store(math.MaxUint64) // release the writer waiting it
+			tr.ring.deleteReader(tr.s)
+		}
+	}()
+	var tscan time.Time
+	for d.isRecording() {
+		now := time.Now()
+		if now.Sub(tscan) >= RECORD_SCAN_PERIOD {
+			d.scanRecordTracks(readers)
+			tscan = now
+			w.AtUsed = now
+		}
+
+		nslot := 0
+		for _, tr := range readers {
+			if mime := tr.trk.getRingMime(tr.ring); mime != tr.mime { // mime of the pipe is changed
+				tr.mime = mime
+				rw.writeTrack(RecordTrack{Index: tr.index, Source: tr.src, Track: tr.trk.Label, Mime: tr.mime, Codec: tr.trk.getCodec()})
+			}
+			for {
+				bs, nseq, lost := tr.ring.readSlotNext(tr.lseq, 0)
+				tr.s.seqRead.Store(nseq)
+				tr.lseq = nseq
+				if lost > 0 { // downgraded by the slow write
+					log.Println("record lost:", tr.trk.Label, lost)
+					err := rw.writeGap(RecordGap{Index: tr.index, Lost: lost, Time: time.Now()})
+					if err != nil {
+						log.Println("record:", err)
+						return
+					}
+				}
+				if bs == nil {
+					break
+				}
+				if bs.To != "" { // the directed slot is not recorded
+					continue
+				}
+				bs = tr.trk.decompressSlot(bs) // the dictionaries are not in the file
+				err := rw.writeSlot(tr.index, bs)
+				if err != nil {
+					log.Println("record:", err)
+					return
+				}
+				nslot++
+			}
+		}
+		if nslot == 0 {
+			rw.flush()
+			d.Lock()
+			d.RecordFile = rw.getFileName()
+			d.Unlock()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// add the readers of new tracks, starting at the cached gop if any
+//   - the writer of ring waits the recorder not to lose the slots, up to BUFFER_BLOCK_WAIT
+func (d *Channel) scanRecordTracks(readers map[*Buffer]*recordTrackReader) {
+	d.Lock()
+	defer d.Unlock()
+	for _, src := range d.Sources {
+		src.RLock()
+		for _, t := range src.Tracks {
+			b := t.getRingByOrder(BUFFER_NUM_FORE)
+			if b == nil || readers[b] != nil {
+				continue
+			}
+			tr := &recordTrackReader{index: len(readers), ring: b, src: src.Label, trk: t, lseq: b.getWriteSeq()}
+			if slots := b.getGopSlots(); len(slots) > 0 {
+				tr.lseq = slots[0].Seq - 1
+			}
+			tr.s = NewSessionPointerWithName("/worker/moth/record")
+			tr.s.Policy = "block"
+			b.addReader(tr.s, tr.lseq)
+			readers[b] = tr
+			log.Println("record track:", tr.index, src.Label, t.Label)
+		}
+		src.RUnlock()
+	}
+}
+
+//=================================================================================
@@ -0,0 +1,160 @@
+// =================================================================================
+// Filename: work-record_test.go
+// Function: Test functions for work-record.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------------------------------------
+func TestRecordWriterRotate(t *testing.T) {
+	rw := NewRecordWriter(t.TempDir(), "chtest")
+	rw.MaxTime = 2 * time.Second
+	rw.writeTrack(RecordTrack{Index: 0, Source: "base", Track: "video", Mime: "video/h264"})
+
+	// 10 slots per second for 5 seconds, rotated at 2 seconds
+	start := time.Unix(1700000000, 0)
+	var files []string
+	for i := 0; i < 50; i++ {
+		bs := newSeqSlot(uint64(i + 1))
+		bs.Seq = uint64(i + 1)
+		bs.Time = start.Add(time.Duration(i) * 100 * time.Millisecond)
+		bs.Key = (i%10 == 0)
+		if err := rw.writeSlot(0, &bs); err != nil {
+			t.Fatal(err)
+		}
+		if len(files) == 0 || files[len(files)-1] != rw.FileName {
+			files = append(files, rw.FileName)
+		}
+	}
+	rw.closeFile()
+	if len(files) != 3 {
+		t.Fatal("invalid rotation:", files)
+	}
+
+	rr, err := OpenRecordReader(files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	var count int
+	var first uint64
+	for {
+		index, bs, err := rr.readSlot()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == 0 {
+			first = bs.Seq
+		}
+		if index != 0 || len(rr.Tracks) != 1 || rr.Tracks[0].Mime != "video/h264" {
+			t.Fatal("invalid track:", index, rr.Tracks)
+		}
+		count++
+	}
+	if first != 21 || count != 20 || rr.Header.NumSlots != 20 || rr.Header.AtEnded.IsZero() {
+		t.Fatal("invalid record:", first, count, rr.Header)
+	}
+}
+
+func TestRecordWriterGap(t *testing.T) {
+	rw := NewRecordWriter(t.TempDir(), "chn")
+	rw.writeTrack(RecordTrack{Index: 0, Source: "base", Track: "video", Mime: "video/h264"})
+	for i := 0; i < 4; i++ {
+		if i == 2 {
+			rw.writeGap(RecordGap{Index: 0, Lost: 5, Time: time.Now()})
+		}
+		bs := newSeqSlot(uint64(i + 1))
+		bs.Time = time.Now()
+		rw.writeSlot(0, &bs)
+	}
+	rw.closeFile()
+
+	rr, err := OpenRecordReader(rw.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	for count := 0; ; count++ {
+		_, _, err := rr.readSlot()
+		if err == io.EOF {
+			break
+		}
+		if count == 2 && len(rr.Gaps) != 1 { // read before the 3rd slot
+			t.Fatal("gap is not marked before the slot:", rr.Gaps)
+		}
+	}
+	if len(rr.Gaps) != 1 || rr.Gaps[0].Lost != 5 || rr.Header.NumLost != 5 {
+		t.Fatal("invalid gap:", rr.Gaps, rr.Header)
+	}
+}
+
+func TestRecorderPubInOut(t *testing.T) {
+	dir := mConfig.DirRecord
+	mConfig.DirRecord = t.TempDir()
+	defer func() { mConfig.DirRecord = dir }()
+
+	chn := NewChannelPointer()
+	_, trk, err := chn.addSourceTrackBySize("base", "video", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSessionPointerWithName("/pang/ws/pub")
+	chn.addPublisher(s)
+	chn.RecordAuto = true
+	chn.pushEvent("pub-in", s.ID, s.Name, s.RequestID)
+
+	b := trk.getRingByOrder(BUFFER_NUM_FORE)
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 10; i++ { // the mime changed by the publisher while recording, checked by -race
+		trk.setRingMime(b, []string{MIME_VIDEO_H264, MIME_VIDEO_JPEG}[i%2])
+		bs := newSeqSlot(uint64(i + 1))
+		bs.Time = time.Now()
+		b.writeSlot(bs, false)
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if chn.getRecordState() != Using {
+		t.Fatal("recorder is not started:", chn.getRecordState())
+	}
+	if b.nblock.Load() != 1 {
+		t.Fatal("recorder is not a blocking reader:", b.nblock.Load())
+	}
+
+	chn.deletePublisher(s)
+	for i := 0; i < 50 && chn.getRecordState() == Using; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if chn.getRecordState() != Idle || b.countReaders() != 0 {
+		t.Fatal("recorder is not stopped:", chn.getRecordState(), b.countReaders())
+	}
+
+	files, _ := filepath.Glob(filepath.Join(mConfig.DirRecord, chn.ID, "*"+RECORD_FILE_EXT))
+	if len(files) != 1 {
+		t.Fatal("invalid record files:", files)
+	}
+	rr, err := OpenRecordReader(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	if fi, _ := os.Stat(files[0]); fi.Size() == 0 {
+		t.Fatal("empty record file")
+	}
+	if rr.Header.ChannelID != chn.ID {
+		t.Fatal("invalid record header:", rr.Header)
+	}
+}
+
+//=================================================================================
@@ -0,0 +1,464 @@
+// =================================================================================
+// Filename: work-relay.go
+// Function: Relay of all channel tracks to upstream servers by the WS pusher
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// ---------------------------------------------------------------------------------
+const (
+	RELAY_SCAN_PERIOD = time.Second
+	RELAY_MIN_BACKOFF = time.Second // retry delay of the pusher, doubled up to max
+	RELAY_MAX_BACKOFF = 30 * time.Second
+	RELAY_DEFAULT_API = "/pang/ws/pub"
+	RELAY_TIMEOUT     = 10 // seconds of the pusher session without slots
+)
+
+// ---------------------------------------------------------------------------------
+// RelayTarget is an upstream server where all tracks of channel are pushed
+// ---------------------------------------------------------------------------------
+type RelayTarget struct {
+	URL     string    `json:"url"`               // ws(s)://host:port[/pang/ws/pub]
+	Channel string    `json:"channel,omitempty"` // remote channel id, the same as local if empty
+	Key     string    `json:"key,omitempty"`     // stream key of the remote channel
+	State   State     `json:"state"`
+	Tracks  int       `json:"tracks"`  // number of tracks in pushing
+	Retries int       `json:"retries"` // number of failed pushes
+	Error   string    `json:"error,omitempty"`
+	AtUsed  time.Time `json:"at_used,omitempty"`
+	// --- internal variables
+	sync.Mutex
+}
+
+func (d *RelayTarget) String() (str string) {
+	d.Lock()
+	defer d.Unlock()
+	str = fmt.Sprintf("%s (%s) %s, tracks: %d, retries: %d", d.URL, d.Channel, d.State.String(), d.Tracks, d.Retries)
+	if d.Error != "" {
+		str += ", error: " + d.Error
+	}
+	return
+}
+
+func (d *RelayTarget) MarshalJSON() ([]byte, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	type Alias RelayTarget
+	return json.Marshal((*Alias)(d))
+}
+
+// ParseRelayTarget parses the target given by url[,channel[,key]]
+func ParseRelayTarget(value string) (rt *RelayTarget, err error) {
+	items := strings.Split(value, ",")
+	u, err := url.Parse(strings.TrimSpace(items[0]))
+	if err != nil {
+		return
+	}
+	if (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+		err = fmt.Errorf("invalid relay url: %s [ws|wss://host:port]", items[0])
+		return
+	}
+	rt = &RelayTarget{URL: u.String()}
+	if len(items) > 1 {
+		rt.Channel = strings.TrimSpace(items[1])
+	}
+	if len(items) > 2 {
+		rt.Key = strings.TrimSpace(items[2])
+	}
+	return
+}
+
+// get the scheme, host and api path of the target url
+func (d *RelayTarget) getSpot() (to Spot) {
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return
+	}
+	to = Spot{Proto: u.Scheme, Addr: u.Host, API: u.Path}
+	if to.API == "" || to.API == "/" {
+		to.API = RELAY_DEFAULT_API
+	}
+	return
+}
+
+func (d *RelayTarget) setPushing(delta int, err error) {
+	d.Lock()
+	defer d.Unlock()
+	d.Tracks += delta
+	d.State = Idle
+	if d.Tracks > 0 {
+		d.State = Using
+	}
+	if err != nil {
+		d.Error = err.Error()
+		d.Retries++
+	}
+	d.AtUsed = time.Now()
+}
+
+// ---------------------------------------------------------------------------------
+// initRelayTargets adds the relay of config to the channel if it has no targets
+func (d *Channel) initRelayTargets() {
+	for _, rt := range d.RelayTargets { // loaded from the file
+		rt.State, rt.Tracks = Idle, 0
+	}
+	if mConfig.RelayURL == "" || len(d.RelayTargets) > 0 {
+		return
+	}
+	rt, err := ParseRelayTarget(mConfig.RelayURL)
+	if err != nil {
+		log.Println("relay:", err)
+		return
+	}
+	rt.Key = mConfig.RelayKey
+	d.RelayTargets = append(d.RelayTargets, rt)
+	d.RelayAuto = true
+}
+
+// setRelayAuto turns on/off the relaying, starting it at once if published
+func (d *Channel) setRelayAuto(on bool) {
+	d.Lock()
+	d.RelayAuto = on
+	npub := len(d.Publishers)
+	d.Unlock()
+
+	if on && npub > 0 {
+		d.startRelayer()
+	}
+}
+
+// addRelayTarget adds or replaces the target of the same url and channel
+func (d *Channel) addRelayTarget(rt *RelayTarget) {
+	d.Lock()
+	defer d.Unlock()
+	for i, v := range d.RelayTargets {
+		if v.URL == rt.URL && v.Channel == rt.Channel {
+			d.RelayTargets[i] = rt
+			return
+		}
+	}
+	d.RelayTargets = append(d.RelayTargets, rt)
+}
+
+// deleteRelayTarget removes the targets of url, all if "all"
+func (d *Channel) deleteRelayTarget(turl string) (err error) {
+	d.Lock()
+	defer d.Unlock()
+	var rts []*RelayTarget
+	for _, v := range d.RelayTargets {
+		if turl != "all" && v.URL != turl {
+			rts = append(rts, v)
+		}
+	}
+	if len(rts) == len(d.RelayTargets) {
+		err = fmt.Errorf("not found relay target: %s", turl)
+		return
+	}
+	d.RelayTargets = rts
+	return
+}
+
+func (d *Channel) getRelayTargets() (rts []*RelayTarget) {
+	d.Lock()
+	defer d.Unlock()
+	return append(rts, d.RelayTargets...)
+}
+
+// startRelayer starts the relay worker of channel if RelayAuto is set, at pub-in
+func (d *Channel) startRelayer() {
+	d.Lock()
+	if !d.RelayAuto || d.RelayState == Using || len(d.RelayTargets) == 0 {
+		d.Unlock()
+		return
+	}
+	d.RelayState = Using
+	d.Unlock()
+
+	go d.runRelayer()
+}
+
+// relaying while there are publishers other than the derived ones
+func (d *Channel) isRelaying() bool {
+	d.Lock()
+	defer d.Unlock()
+	if !d.RelayAuto || d.RelayState != Using {
+		return false
+	}
+	for _, s := range d.Publishers {
+		if !strings.Contains(s.TrackID, TRANS_DERIVED_MARK) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Channel) getRelayState() State {
+	d.Lock()
+	defer d.Unlock()
+	return d.RelayState
+}
+
+// ---------------------------------------------------------------------------------
+// runRelayer pushes all tracks to every target until the last pub-out
+func (d *Channel) runRelayer() {
+	log.Println("i.runRelayer:", d.ID)
+	defer log.Println("o.runRelayer:", d.ID)
+
+	w := pStudio.addNewWorkerWithParams("/worker/moth/relay", d.ID, "relay")
+	defer pStudio.deleteWorker(w)
+
+	pushers := make(map[string]*RelayPusher) // by url|channel|source/track
+	bridges := make(map[*RelayTarget]*Bridge)
+	var wg sync.WaitGroup
+	defer func() {
+		for _, p := range pushers {
+			p.stop()
+		}
+		wg.Wait()
+		for _, b := range bridges {
+			pStudio.deleteBridge(b)
+		}
+		d.Lock()
+		d.RelayState = Idle
+		d.Unlock()
+	}()
+
+	for d.isRelaying() {
+		rts := d.getRelayTargets()
+		for k, p := range pushers { // ended or its target removed
+			if p.isEnded() || !containsRelayTarget(rts, p.rt) {
+				p.stop()
+				<-p.ended
+				delete(pushers, k)
+			}
+		}
+		for rt, b := range bridges {
+			if !containsRelayTarget(rts, rt) {
+				pStudio.deleteBridge(b)
+				delete(bridges, rt)
+			}
+		}
+		for _, rt := range rts {
+			b := bridges[rt]
+			if b == nil {
+				b = d.newRelayBridge(rt)
+				bridges[rt] = b
+			}
+			for _, p := range d.scanRelayTracks(rt, b) {
+				key := rt.URL + "|" + rt.Channel + "|" + p.Source + "/" + p.Track
+				if pushers[key] != nil {
+					continue
+				}
+				pushers[key] = p
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					p.run()
+				}()
+			}
+		}
+		w.AtUsed = time.Now()
+		time.Sleep(RELAY_SCAN_PERIOD)
+	}
+}
+
+func containsRelayTarget(rts []*RelayTarget, rt *RelayTarget) bool {
+	for _, v := range rts {
+		if v == rt {
+			return true
+		}
+	}
+	return false
+}
+
+// add a bridge of the target to be shown by the manager
+func (d *Channel) newRelayBridge(rt *RelayTarget) (b *Bridge) {
+	b = NewBridgePointerWithName("/bridge/moth/relay")
+	b.Attr = "relay"
+	b.Timeout = RELAY_TIMEOUT
+	b.From = Spot{Proto: "int", API: "/pang/int/sub", ChannelID: d.ID}
+	b.To = rt.getSpot()
+	b.To.ChannelID = rt.Channel
+	if b.To.ChannelID == "" {
+		b.To.ChannelID = d.ID
+	}
+	return pStudio.addBridge(b)
+}
+
+// get the pushers of published tracks having mime
+func (d *Channel) scanRelayTracks(rt *RelayTarget, b *Bridge) (pushers []*RelayPusher) {
+	d.Lock()
+	defer d.Unlock()
+	published := make(map[string]bool)
+	for _, s := range d.Publishers {
+		published[s.SourceID+"/"+s.TrackID] = true
+	}
+	for _, src := range d.Sources {
+		src.RLock()
+		for _, t := range src.Tracks {
+			rb := t.getRingByOrder(BUFFER_NUM_FORE)
+			if rb == nil || t.getRingMime(rb) == "" || !published[src.Label+"/"+t.Label] {
+				continue
+			}
+			pushers = append(pushers, &RelayPusher{Source: src.Label, Track: t.Label, Mode: t.Mode,
+				chn: d, rt: rt, bridge: b, done: make(chan struct{}), ended: make(chan struct{})})
+		}
+		src.RUnlock()
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// RelayPusher pushes a track to the target, retrying with backoff
+// ---------------------------------------------------------------------------------
+type RelayPusher struct {
+	Source string `json:"source"`
+	Track  string `json:"track"`
+	Mode   string `json:"mode"`
+	// --- internal variables
+	chn    *Channel
+	rt     *RelayTarget
+	bridge *Bridge
+	ws     *websocket.Conn
+	done   chan struct{}
+	ended  chan struct{} // closed at the end of run
+	sync.Mutex
+}
+
+func (d *RelayPusher) stop() {
+	select {
+	case <-d.done:
+	default:
+		close(d.done)
+	}
+	// close the connection to end the sender at its next write
+	d.Lock()
+	if d.ws != nil {
+		d.ws.Close()
+	}
+	d.Unlock()
+}
+
+func (d *RelayPusher) isStopped() bool {
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *RelayPusher) isEnded() bool {
+	select {
+	case <-d.ended:
+		return true
+	default:
+		return false
+	}
+}
+
+// run pushes the track while it is published, the scanner starts it again if republished
+func (d *RelayPusher) run() {
+	log.Println("i.RelayPusher:", d.rt.URL, d.Source, d.Track)
+	defer log.Println("o.RelayPusher:", d.rt.URL, d.Source, d.Track)
+	defer close(d.ended)
+
+	b := d.bridge
+	frqry, toqry := d.getQueries()
+
+	backoff := RELAY_MIN_BACKOFF
+	for !d.isStopped() && d.chn.hasPublishedTrack(d.Source, d.Track) {
+		tstart := time.Now()
+		d.rt.setPushing(1, nil)
+		err := d.push(frqry.Encode(), fmt.Sprintf("%s://%s%s?%s", b.To.Proto, b.To.Addr, b.To.API, toqry.Encode()))
+		if d.isStopped() {
+			d.rt.setPushing(-1, nil)
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("pusher ended: %s/%s", d.Source, d.Track)
+		}
+		d.rt.setPushing(-1, err)
+		if time.Since(tstart) > RELAY_MAX_BACKOFF { // it worked for a while
+			backoff = RELAY_MIN_BACKOFF
+		}
+		select {
+		case <-d.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, RELAY_MAX_BACKOFF)
+	}
+}
+
+// get the queries of the internal subscriber and the remote publisher, labels escaped
+func (d *RelayPusher) getQueries() (frqry, toqry url.Values) {
+	b := d.bridge
+	frqry = url.Values{}
+	frqry.Set("channel", b.From.ChannelID)
+	frqry.Set("source", d.Source)
+	frqry.Set("track", d.Track)
+	frqry.Set("timeout", fmt.Sprint(b.Timeout))
+	toqry = url.Values{}
+	toqry.Set("channel", b.To.ChannelID)
+	toqry.Set("source", d.Source)
+	toqry.Set("track", d.Track)
+	toqry.Set("timeout", fmt.Sprint(b.Timeout))
+	if d.Mode != "" {
+		toqry.Set("mode", d.Mode)
+	}
+	if d.rt.Key != "" {
+		toqry.Set("key", d.rt.Key)
+	}
+	return
+}
+
+// push the track by the bridge pusher, sub(int) -> pub(ws)
+func (d *RelayPusher) push(frqry, turl string) (err error) {
+	qo, err := GetQueryOptionFromString(d.bridge.From.Proto, d.bridge.From.API, frqry)
+	if err != nil {
+		return
+	}
+	ws, _, err := websocket.DefaultDialer.Dial(turl, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	d.Lock()
+	d.ws = ws
+	d.Unlock()
+	if d.isStopped() {
+		return
+	}
+	err = PangWSPusher(d.bridge, ws, qo)
+	return
+}
+
+// check if the track of source is published in the channel
+func (d *Channel) hasPublishedTrack(source, track string) bool {
+	d.Lock()
+	defer d.Unlock()
+	for _, s := range d.Publishers {
+		if s.SourceID == source && s.TrackID == track {
+			return true
+		}
+	}
+	return false
+}
+
+//=================================================================================
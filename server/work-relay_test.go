@@ -0,0 +1,160 @@
+// =================================================================================
+// Filename: work-relay_test.go
+// Function: Test functions for work-relay.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// ---------------------------------------------------------------------------------
+// upstream server receiving the messages of pushers, closing the first connection
+func newRelayUpstream(t *testing.T) (ts *httptest.Server, queries chan string, msgs chan string) {
+	queries = make(chan string, 10)
+	msgs = make(chan string, 100)
+	var nconn atomic.Int32
+	upgrader := websocket.Upgrader{}
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		n := nconn.Add(1)
+		queries <- r.URL.Path + "?" + r.URL.RawQuery
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			msgs <- string(data)
+			if n == 1 { // to test the retry
+				return
+			}
+		}
+	}))
+	return
+}
+
+func TestRelayPushRetry(t *testing.T) {
+	ts, queries, msgs := newRelayUpstream(t)
+	defer ts.Close()
+
+	chn := pStudio.addChannel(NewChannelPointer())
+	defer pStudio.deleteChannel(chn)
+	chn.State = Using
+	_, trk, _ := chn.addSourceTrackBySize("base", "data", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+	b := trk.getRingByOrder(BUFFER_NUM_FORE)
+	trk.setRingMime(b, "text/plain")
+
+	s := pStudio.addNewSessionWithName("/pang/ws/pub") // to keep the channel using
+	defer pStudio.deleteSessionWithClose(s)
+	s.ChannelID, s.SourceID, s.TrackID = chn.ID, "base", "data"
+	chn.addPublisher(s)
+
+	if _, err := ParseRelayTarget("http://localhost"); err == nil {
+		t.Fatal("invalid url is accepted")
+	}
+	rt, err := ParseRelayTarget("ws" + strings.TrimPrefix(ts.URL, "http") + ",remote,secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chn.addRelayTarget(rt)
+	chn.setRelayAuto(true)
+
+	// the first connection is closed by the upstream, and retried
+	for k := 0; k < 2; k++ {
+		connected := false
+		for tout := time.After(5 * time.Second); !connected; {
+			b.writeSlot(Slot{FrameType: websocket.BinaryMessage, Data: []byte("hello"), Length: 5}, false)
+			select {
+			case q := <-queries:
+				if !strings.HasPrefix(q, RELAY_DEFAULT_API+"?") || !strings.Contains(q, "channel=remote") ||
+					!strings.Contains(q, "key=secret") || !strings.Contains(q, "track=data") {
+					t.Fatal("invalid query:", q)
+				}
+				connected = true
+			case <-tout:
+				t.Fatal("no connection:", k)
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+		found := false
+		for tout := time.After(5 * time.Second); !found; {
+			b.writeSlot(Slot{FrameType: websocket.BinaryMessage, Data: []byte("hello"), Length: 5}, false)
+			select {
+			case m := <-msgs:
+				found = m == "text/plain"
+			case <-tout:
+				t.Fatal("no mime:", k)
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}
+	if rt.String() == "" || rt.Retries < 1 {
+		t.Fatal("retry is not counted:", rt.String())
+	}
+
+	chn.deletePublisher(s)
+	for i := 0; i < 200 && chn.getRelayState() == Using; i++ {
+		b.writeSlot(Slot{FrameType: websocket.BinaryMessage, Data: []byte("bye"), Length: 3}, false)
+		time.Sleep(20 * time.Millisecond)
+	}
+	if chn.getRelayState() != Idle {
+		t.Fatal("relay is not stopped")
+	}
+}
+
+func TestRelayQueries(t *testing.T) {
+	b := &Bridge{Timeout: 10}
+	b.From.ChannelID, b.To.ChannelID = "local", "remote"
+	d := &RelayPusher{Source: "a&source=x", Track: "t#1", bridge: b, rt: &RelayTarget{Key: "k"}}
+	frqry, toqry := d.getQueries()
+	for _, str := range []string{frqry.Encode(), toqry.Encode()} {
+		qo, err := GetQueryOptionFromString("int", "/pang/int/sub", str)
+		if err != nil || qo.Source.Label != d.Source || qo.Track.Label != d.Track {
+			t.Fatal("labels are not escaped:", str, qo.Source.Label, qo.Track.Label, err)
+		}
+	}
+	if toqry.Get("channel") != "remote" || toqry.Get("key") != "k" || frqry.Get("channel") != "local" {
+		t.Fatal("invalid queries:", frqry, toqry)
+	}
+}
+
+func TestRelayScanTracks(t *testing.T) {
+	chn := NewChannelPointer()
+	_, trk, _ := chn.addSourceTrackBySize("base", "video", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+	s := NewSessionPointerWithName("/pang/ws/pub")
+	s.SourceID, s.TrackID = "base", "video"
+	chn.addPublisher(s)
+	if pushers := chn.scanRelayTracks(nil, nil); len(pushers) != 0 {
+		t.Fatal("track without mime is relayed")
+	}
+
+	done := make(chan struct{})
+	go func() { // the mime set while scanning, checked by -race
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			trk.setRingMime(trk.getRingByOrder(BUFFER_NUM_FORE), "video/jpeg")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		chn.scanRelayTracks(nil, nil)
+	}
+	<-done
+	if pushers := chn.scanRelayTracks(nil, nil); len(pushers) != 1 || pushers[0].Track != "video" {
+		t.Fatal("invalid relay pushers:", pushers)
+	}
+}
+
+//=================================================================================
@@ -0,0 +1,192 @@
+// =================================================================================
+// Filename: work-scale.go
+// Function: JPEG renditions of tracks for the subscribers of scale or quality
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"fmt"
+	"image/jpeg"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// ---------------------------------------------------------------------------------
+// A subscriber of scale=320x240 and/or quality=50 reads the derived track of rendition,
+// <track>@320x240q50, made once for the same parameters and shared by the subscribers.
+// The jpeg frames are decoded, fit into the box keeping the aspect ratio and encoded.
+// ---------------------------------------------------------------------------------
+const (
+	SCALE_MIN_SIZE = 16
+	SCALE_MAX_SIZE = 4096
+	SCALE_MAX_GAP  = 3 // frames skipped if late, for the low latency
+)
+
+// ParseScaleOptions parses scale=WxH and quality=1-100 of subscriber
+func ParseScaleOptions(scale, quality string) (w, h, q int, err error) {
+	if scale != "" {
+		ws, hs, ok := strings.Cut(scale, "x")
+		w, err = strconv.Atoi(ws)
+		if err == nil && ok {
+			h, err = strconv.Atoi(hs)
+		}
+		if err != nil || !ok || w < SCALE_MIN_SIZE || w > SCALE_MAX_SIZE || h < SCALE_MIN_SIZE || h > SCALE_MAX_SIZE {
+			err = fmt.Errorf("invalid scale: %s [WxH, %d-%d]", scale, SCALE_MIN_SIZE, SCALE_MAX_SIZE)
+			return
+		}
+	}
+	if quality != "" {
+		q, err = strconv.Atoi(quality)
+		if err != nil || q < 1 || q > 100 {
+			err = fmt.Errorf("invalid quality: %s [1-100]", quality)
+			return
+		}
+	}
+	return
+}
+
+// GetRenditionLabel returns the label of derived track, 320x240q50, 320x240 or q50
+func GetRenditionLabel(track string, w, h, q int) (label string) {
+	label = track + TRANS_DERIVED_MARK
+	if w > 0 {
+		label += fmt.Sprintf("%dx%d", w, h)
+	}
+	if q > 0 {
+		label += fmt.Sprintf("q%d", q)
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+type Rendition struct {
+	Source  string `json:"source"`
+	Track   string `json:"track"`
+	Label   string `json:"label"` // of the derived track
+	Width   int    `json:"width,omitempty"`
+	Height  int    `json:"height,omitempty"`
+	Quality int    `json:"quality,omitempty"`
+	Subs    int    `json:"subs"` // number of subscribers
+	Frames  int    `json:"frames"`
+	Errors  int    `json:"errors"`
+	// --- internal variables
+	chn   *Channel
+	strk  *Track   // source track
+	pub   *Session // publisher of the derived track
+	done  chan struct{}
+	ended chan struct{} // closed at the end of run
+}
+
+// acquireRendition returns the rendition of the track for the subscriber, started at the first
+func (d *Channel) acquireRendition(source, track string, w, h, q int) (r *Rendition, err error) {
+	d.scaleLock.Lock()
+	defer d.scaleLock.Unlock()
+
+	label := GetRenditionLabel(track, w, h, q)
+	key := source + "/" + label
+	if r = d.renditions[key]; r != nil {
+		r.Subs++
+		return
+	}
+	_, strk, err := d.addSourceTrackByLabel(source, track)
+	if err != nil {
+		return
+	}
+	mime := strk.getRingMime(strk.getRingByOrder(BUFFER_NUM_FORE))
+	if !strings.Contains(mime, "jpeg") {
+		err = fmt.Errorf("not jpeg track for rendition: %s/%s, %s", source, track, mime)
+		return
+	}
+	if q == 0 {
+		q = jpeg.DefaultQuality
+	}
+	r = &Rendition{Source: source, Track: track, Label: label, Width: w, Height: h, Quality: q, Subs: 1,
+		chn: d, strk: strk, done: make(chan struct{}), ended: make(chan struct{})}
+	r.pub, err = addDerivedPublisher(d, source, label, mime)
+	if err != nil {
+		return
+	}
+	if d.renditions == nil {
+		d.renditions = make(map[string]*Rendition)
+	}
+	d.renditions[key] = r
+	go r.run()
+	return
+}
+
+// releaseRendition stops the rendition at the last subscriber
+func (d *Channel) releaseRendition(r *Rendition) {
+	d.scaleLock.Lock()
+	defer d.scaleLock.Unlock()
+
+	r.Subs--
+	if r.Subs > 0 {
+		return
+	}
+	delete(d.renditions, r.Source+"/"+r.Label)
+	close(r.done)
+	<-r.ended // to release the derived track
+}
+
+func (d *Rendition) getTrack() *Track {
+	return d.pub.trk
+}
+
+func (d *Rendition) isStopped() bool {
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// run makes the frames of rendition from the latest one of source track
+func (d *Rendition) run() {
+	log.Println("i.Rendition:", d.Source, d.Label)
+	defer close(d.ended)
+	defer func() { log.Println("o.Rendition:", d.Source, d.Label, d.Frames, d.Errors) }()
+	defer deleteDerivedPublisher(d.pub)
+
+	w := pStudio.addNewWorkerWithParams("/worker/moth/scale", d.chn.ID, "scale")
+	defer pStudio.deleteWorker(w)
+
+	ring := d.strk.getRingByOrder(BUFFER_NUM_FORE)
+	lseq := ring.getWriteSeq()
+	if bs := GetLatestJPEGSlot(ring); bs != nil {
+		lseq = bs.Seq - 1 // the latest frame at once
+	}
+	for !d.isStopped() {
+		bs, nseq, _ := ring.readSlotNext(lseq, SCALE_MAX_GAP)
+		lseq = nseq
+		if bs == nil {
+			time.Sleep(5 * time.Millisecond)
+			continue
+		}
+		if bs.To != "" || bs.FrameType != websocket.BinaryMessage || !IsKeyFrame("jpeg", bs.Data) {
+			continue
+		}
+		data, err := RescaleJPEG(bs.Data, d.Width, d.Height, d.Quality)
+		if err != nil {
+			d.Errors++
+			log.Println("scale:", d.Label, err)
+			continue
+		}
+		d.Frames++
+		w.AtUsed = time.Now()
+
+		b := d.pub.trk.getRingByOrder(BUFFER_NUM_FORE)
+		if mime := d.strk.getRingMime(ring); mime != "" && mime != d.pub.trk.getRingMime(b) {
+			d.pub.trk.setRingMime(b, mime)
+		}
+		writeDerivedSlot(d.pub, &Slot{FrameType: websocket.BinaryMessage, Head: d.pub.ID,
+			Capture: bs.Capture, Data: data})
+	}
+}
+
+//=================================================================================
@@ -0,0 +1,121 @@
+// =================================================================================
+// Filename: work-scale_test.go
+// Function: Test functions for work-scale.go and util-image.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// ---------------------------------------------------------------------------------
+func newTestJPEG(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestScaleImage(t *testing.T) {
+	if w, h := GetFitSize(640, 480, 320, 320); w != 320 || h != 240 {
+		t.Fatal("invalid fit size:", w, h)
+	}
+	if w, h := GetFitSize(100, 50, 320, 240); w != 100 || h != 50 {
+		t.Fatal("enlarged:", w, h)
+	}
+	for _, opt := range [][2]string{{"320", ""}, {"8x8", ""}, {"320x240", "0"}, {"axb", "50"}} {
+		if _, _, _, err := ParseScaleOptions(opt[0], opt[1]); err == nil {
+			t.Fatal("invalid option is accepted:", opt)
+		}
+	}
+
+	data := newTestJPEG(t, 640, 480)
+	out, err := RescaleJPEG(data, 160, 160, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(out))
+	if err != nil || img.Bounds().Dx() != 160 || img.Bounds().Dy() != 120 || len(out) >= len(data) {
+		t.Fatal("invalid rescale:", img.Bounds(), len(out), err)
+	}
+	r, g, _, _ := img.At(100, 50).RGBA() // averaged from around (400, 200)
+	if d := int(r>>8) - 400%256; d < -16 || d > 16 || int(g>>8) < 184 || int(g>>8) > 216 {
+		t.Fatal("invalid pixel:", r>>8, g>>8)
+	}
+}
+
+func TestRendition(t *testing.T) {
+	chn := pStudio.addChannel(NewChannelPointer())
+	defer pStudio.deleteChannel(chn)
+	chn.State = Using
+	_, trk, _ := chn.addSourceTrackBySize("cam", "video", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+	b := trk.getRingByOrder(BUFFER_NUM_FORE)
+	if _, err := chn.acquireRendition("cam", "video", 160, 160, 0); err == nil {
+		t.Fatal("rendition of non-jpeg track is acquired")
+	}
+	trk.setRingMime(b, MIME_VIDEO_JPEG)
+
+	// shared by the subscribers of the same parameters
+	r1, err := chn.acquireRendition("cam", "video", 160, 160, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, _ := chn.acquireRendition("cam", "video", 160, 160, 0)
+	if r1 != r2 || r1.Subs != 2 || r1.Label != "video@160x160" || r1.Quality != jpeg.DefaultQuality {
+		t.Fatal("rendition is not shared:", r1.Label, r1.Subs)
+	}
+
+	data := newTestJPEG(t, 640, 480)
+	rb := r1.getTrack().getRingByOrder(BUFFER_NUM_FORE)
+	var bs *Slot
+	for tout := time.After(5 * time.Second); bs == nil; {
+		b.writeSlot(Slot{FrameType: websocket.BinaryMessage, Data: data, Length: len(data)}, false)
+		select {
+		case <-tout:
+			t.Fatal("no rendition frame")
+		case <-time.After(50 * time.Millisecond):
+		}
+		bs = rb.readSlotBySeq(rb.getWriteSeq())
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(bs.Data))
+	if err != nil || cfg.Width != 160 || cfg.Height != 120 || trk.getRingMime(rb) != MIME_VIDEO_JPEG {
+		t.Fatal("invalid rendition frame:", cfg, err)
+	}
+
+	// the derived publisher does not start the workers of channel
+	chn.setRecordAuto(true, "")
+	chn.setShootAuto(true, 0, "")
+	if chn.getRecordState() != Idle || chn.getShootState() != Idle || chn.isRecording() || chn.isShooting() {
+		t.Fatal("workers are started by the rendition")
+	}
+	chn.setRecordAuto(false, "")
+	chn.setShootAuto(false, 0, "")
+
+	// stopped at the last subscriber
+	chn.releaseRendition(r1)
+	if pStudio.findPublisherByResource(chn.ID, "cam", r1.Label) == nil {
+		t.Fatal("rendition is stopped early")
+	}
+	chn.releaseRendition(r2)
+	if pStudio.findPublisherByResource(chn.ID, "cam", r1.Label) != nil || len(chn.renditions) != 0 {
+		t.Fatal("rendition is not stopped")
+	}
+}
+
+//=================================================================================
@@ -0,0 +1,272 @@
+// =================================================================================
+// Filename: work-shoot.go
+// Function: Snapshot worker saving the latest JPEG frames of channel tracks
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------------
+const (
+	SHOOT_DEFAULT_INTERVAL = 10   // seconds between snapshots
+	SHOOT_MAX_INTERVAL     = 3600 // seconds
+	SHOOT_DEFAULT_KEEP     = 100  // number of snapshots kept per track
+	SHOOT_MAX_AGE          = 24 * time.Hour
+	SHOOT_LATEST_NAME      = "latest.jpg"
+	SHOOT_CHECK_PERIOD     = 200 * time.Millisecond // period to check the stop
+)
+
+// time format in the file name of snapshot, <name>-<time>.jpg
+const SHOOT_TIME_FORMAT = "20060102-150405"
+
+// ---------------------------------------------------------------------------------
+// get the directory of snapshots, served as /data/image/{channel}/ in the default config
+func GetShootDir(chid string) string {
+	return filepath.Join(mConfig.DirData, "image", chid)
+}
+
+// CleanShootName replaces the characters except [A-Za-z0-9_-] of the labels with '_',
+// not to make a path or pattern out of the snapshot directory
+func CleanShootName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// setShootAuto turns on/off the snapshot, starting it at once if published
+//   - interval in seconds, 0 keeps the current one
+//   - tracks is a list of track labels or source/track, empty for all jpeg tracks
+func (d *Channel) setShootAuto(on bool, interval int, tracks string) (err error) {
+	if interval < 0 || interval > SHOOT_MAX_INTERVAL {
+		err = fmt.Errorf("invalid shoot interval: %d (1-%d)", interval, SHOOT_MAX_INTERVAL)
+		return
+	}
+	d.Lock()
+	d.ShootAuto = on
+	if interval > 0 {
+		d.ShootInterval = interval
+	}
+	if tracks != "" {
+		d.ShootTracks = tracks
+		if tracks == "all" {
+			d.ShootTracks = ""
+		}
+	}
+	npub := d.countSourcePublishers()
+	d.Unlock()
+
+	if on && npub > 0 {
+		d.startShooter()
+	}
+	return
+}
+
+// startShooter starts the snapshot worker of channel if ShootAuto is set, at pub-in
+func (d *Channel) startShooter() {
+	d.Lock()
+	if !d.ShootAuto || d.ShootState == Using {
+		d.Unlock()
+		return
+	}
+	d.ShootState = Using
+	d.Unlock()
+
+	go d.runShooter()
+}
+
+func (d *Channel) isShooting() bool {
+	d.Lock()
+	defer d.Unlock()
+	return d.ShootAuto && d.ShootState == Using && d.countSourcePublishers() > 0
+}
+
+func (d *Channel) getShootState() State {
+	d.Lock()
+	defer d.Unlock()
+	return d.ShootState
+}
+
+func (d *Channel) getShootOptions() (interval time.Duration, keep int, tracks []string) {
+	d.Lock()
+	defer d.Unlock()
+	interval = time.Duration(d.ShootInterval) * time.Second
+	if interval <= 0 {
+		interval = SHOOT_DEFAULT_INTERVAL * time.Second
+	}
+	keep = d.ShootKeep
+	if keep <= 0 {
+		keep = SHOOT_DEFAULT_KEEP
+	}
+	if d.ShootTracks != "" {
+		tracks = strings.Split(d.ShootTracks, ",")
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// runShooter saves the snapshots of tracks at the interval until the last pub-out
+func (d *Channel) runShooter() {
+	log.Println("i.runShooter:", d.ID)
+	defer log.Println("o.runShooter:", d.ID)
+
+	w := pStudio.addNewWorkerWithParams("/worker/moth/shoot", d.ID, "shoot")
+	defer pStudio.deleteWorker(w)
+	defer func() {
+		d.Lock()
+		d.ShootState = Idle
+		d.Unlock()
+	}()
+
+	dir := GetShootDir(d.ID)
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		log.Println("shoot:", err)
+		return
+	}
+
+	lseqs := make(map[*Buffer]uint64) // the last saved one per ring
+	var tshot time.Time
+	for d.isShooting() {
+		interval, keep, tracks := d.getShootOptions()
+		now := time.Now()
+		if now.Sub(tshot) < interval {
+			time.Sleep(SHOOT_CHECK_PERIOD)
+			continue
+		}
+		tshot = now
+		w.AtUsed = now
+
+		for i, st := range d.getShootTracks(tracks) {
+			bs := GetLatestJPEGSlot(st.ring)
+			if bs == nil || bs.Seq == lseqs[st.ring] {
+				continue // no new frame
+			}
+			lseqs[st.ring] = bs.Seq
+			err = SaveShootImage(dir, st.name, bs.Data, now, i == 0)
+			if err != nil {
+				log.Println("shoot:", err)
+				continue
+			}
+			PurgeShootImages(dir, st.name, keep, now.Add(-SHOOT_MAX_AGE))
+		}
+	}
+}
+
+type shootTrack struct {
+	name string // source_track
+	ring *Buffer
+}
+
+// get the jpeg tracks selected by labels in the order of source/track, not derived ones
+func (d *Channel) getShootTracks(labels []string) (sts []shootTrack) {
+	d.Lock()
+	defer d.Unlock()
+	for _, src := range d.Sources {
+		src.RLock()
+		for _, t := range src.Tracks {
+			b := t.getRingByOrder(BUFFER_NUM_FORE)
+			if b == nil || strings.Contains(t.Label, TRANS_DERIVED_MARK) || GetCodecFromMime(t.getRingMime(b)) != "jpeg" {
+				continue
+			}
+			selected := len(labels) == 0
+			for _, l := range labels {
+				if l == t.Label || l == src.Label+"/"+t.Label {
+					selected = true
+				}
+			}
+			if selected {
+				sts = append(sts, shootTrack{name: CleanShootName(src.Label + "_" + t.Label), ring: b})
+			}
+		}
+		src.RUnlock()
+	}
+	sort.Slice(sts, func(i, j int) bool { return sts[i].name < sts[j].name })
+	return
+}
+
+// GetLatestJPEGSlot returns the last jpeg key frame in the ring, nil if not found
+//   - the directed slot is not public, skipped
+func GetLatestJPEGSlot(b *Buffer) (bs *Slot) {
+	isPublicJPEG := func(bs *Slot) bool {
+		return bs != nil && bs.To == "" && IsKeyFrame("jpeg", bs.Data)
+	}
+	if bs = b.readSlotBySeq(b.getKeySeq()); isPublicJPEG(bs) {
+		return
+	}
+	wseq := b.getWriteSeq()
+	for seq := wseq; seq > 0 && wseq-seq < uint64(b.getSizeLen()); seq-- {
+		if bs = b.readSlotBySeq(seq); isPublicJPEG(bs) {
+			return
+		}
+	}
+	return nil
+}
+
+// SaveShootImage writes the snapshot of the track and its latest one,
+// and the latest of channel if flatest, by renaming to be read safely
+func SaveShootImage(dir, name string, data []byte, now time.Time, flatest bool) (err error) {
+	name = CleanShootName(name)
+	fname := filepath.Join(dir, fmt.Sprintf("%s-%s.jpg", name, now.Format(SHOOT_TIME_FORMAT)))
+	err = os.WriteFile(fname, data, 0644)
+	if err != nil {
+		return
+	}
+	latests := []string{name + "-" + SHOOT_LATEST_NAME}
+	if flatest {
+		latests = append(latests, SHOOT_LATEST_NAME)
+	}
+	for _, latest := range latests {
+		tname := filepath.Join(dir, "."+latest+".tmp")
+		err = os.WriteFile(tname, data, 0644)
+		if err != nil {
+			return
+		}
+		err = os.Rename(tname, filepath.Join(dir, latest))
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// PurgeShootImages removes the snapshots of the track over keep or older than the expiry
+//   - matched by <name>-<time>.jpg, not by a glob pattern of the name
+func PurgeShootImages(dir, name string, keep int, expiry time.Time) {
+	name = CleanShootName(name)
+	entries, _ := os.ReadDir(dir)
+	var files []string
+	for _, e := range entries {
+		stamp, ok := strings.CutPrefix(e.Name(), name+"-")
+		if !ok || !strings.HasSuffix(stamp, ".jpg") {
+			continue
+		}
+		if _, err := time.Parse(SHOOT_TIME_FORMAT, strings.TrimSuffix(stamp, ".jpg")); err == nil {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files) // in time order by the name
+	for i, fname := range files {
+		if i < len(files)-keep {
+			os.Remove(fname)
+			continue
+		}
+		if fi, err := os.Stat(fname); err == nil && fi.ModTime().Before(expiry) {
+			os.Remove(fname)
+		}
+	}
+}
+
+//=================================================================================
@@ -0,0 +1,105 @@
+// =================================================================================
+// Filename: work-shoot_test.go
+// Function: Test functions for work-shoot.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------------------------------------
+func TestShootLatestAndRetention(t *testing.T) {
+	dir := mConfig.DirData
+	mConfig.DirData = t.TempDir()
+	defer func() { mConfig.DirData = dir }()
+
+	chn := NewChannelPointer()
+	_, cam, _ := chn.addSourceTrackBySize("base", "cam", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+	_, txt, _ := chn.addSourceTrackBySize("base", "text", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+	cam.setRingMime(cam.getRingByOrder(BUFFER_NUM_FORE), "image/jpeg")
+	txt.setRingMime(txt.getRingByOrder(BUFFER_NUM_FORE), "text/plain")
+
+	s := NewSessionPointerWithName("/pang/ws/pub")
+	chn.addPublisher(s)
+	chn.ShootKeep = 2
+	if err := chn.setShootAuto(true, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// no frame yet, then jpeg frames followed by a non key one
+	b := cam.getRingByOrder(BUFFER_NUM_FORE)
+	jpeg := []byte{0xff, 0xd8, 0xff, 0xd9}
+	b.writeSlot(Slot{Data: jpeg, Key: true}, false)
+	b.writeSlot(Slot{Data: []byte("not jpeg")}, false)
+
+	latest := filepath.Join(GetShootDir(chn.ID), SHOOT_LATEST_NAME)
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(latest); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if data, _ := os.ReadFile(latest); !bytes.Equal(data, jpeg) {
+		t.Fatal("invalid latest image:", data)
+	}
+	if _, err := os.Stat(filepath.Join(GetShootDir(chn.ID), "base_cam-"+SHOOT_LATEST_NAME)); err != nil {
+		t.Fatal(err)
+	}
+
+	chn.deletePublisher(s)
+	for i := 0; i < 50 && chn.getShootState() == Using; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if chn.getShootState() != Idle {
+		t.Fatal("shooter is not stopped")
+	}
+
+	// the directed frame and the derived track are not shot
+	b.writeSlot(Slot{Data: []byte{0xff, 0xd8, 0x00, 0xff, 0xd9}, Key: true, To: "viewer"}, false)
+	if bs := GetLatestJPEGSlot(b); bs == nil || !bytes.Equal(bs.Data, jpeg) {
+		t.Fatal("directed frame is shot:", bs)
+	}
+	_, rtrk, _ := chn.addSourceTrackBySize("base", "cam@160x120", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+	rtrk.setRingMime(rtrk.getRingByOrder(BUFFER_NUM_FORE), "image/jpeg")
+	if sts := chn.getShootTracks(nil); len(sts) != 1 || sts[0].name != "base_cam" {
+		t.Fatal("invalid shoot tracks:", sts)
+	}
+
+	// retention by the number and age
+	sdir := t.TempDir()
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		SaveShootImage(sdir, "base_cam", jpeg, now.Add(time.Duration(i)*time.Second), false)
+	}
+	PurgeShootImages(sdir, "base_cam", 3, now.Add(-time.Hour))
+	if files, _ := filepath.Glob(filepath.Join(sdir, "base_cam-2*.jpg")); len(files) != 3 {
+		t.Fatal("invalid retention by number:", files)
+	}
+	PurgeShootImages(sdir, "base_cam", 3, now.Add(time.Hour))
+	if files, _ := filepath.Glob(filepath.Join(sdir, "base_cam-2*.jpg")); len(files) != 0 {
+		t.Fatal("invalid retention by age:", files)
+	}
+
+	// labels are cleaned not to be out of the directory or a pattern
+	if name := CleanShootName("../cam/*[x]"); name != "___cam___x_" {
+		t.Fatal("invalid clean name:", name)
+	}
+	SaveShootImage(sdir, "../cam", jpeg, now, false)
+	SaveShootImage(sdir, "*", jpeg, now, false)
+	if files, _ := filepath.Glob(filepath.Join(filepath.Dir(sdir), "cam-2*.jpg")); len(files) != 0 {
+		t.Fatal("saved out of the directory:", files)
+	}
+	PurgeShootImages(sdir, "*", 0, now.Add(-time.Hour))
+	if files, _ := filepath.Glob(filepath.Join(sdir, "___cam-2*.jpg")); len(files) != 1 {
+		t.Fatal("purged by the pattern:", files)
+	}
+}
+
+//=================================================================================
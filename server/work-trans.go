@@ -0,0 +1,481 @@
+// =================================================================================
+// Filename: work-trans.go
+// Function: Transcoding pipelines of external processes for channel tracks
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// ---------------------------------------------------------------------------------
+const (
+	TRANS_DEFAULT_SUFFIX = "@480p" // label suffix of the derived track
+	TRANS_DERIVED_MARK   = "@"     // tracks labeled with it are not transcoded again
+	TRANS_SCAN_PERIOD    = time.Second
+	TRANS_MIN_BACKOFF    = time.Second // restart delay of the process, doubled up to max
+	TRANS_MAX_BACKOFF    = 30 * time.Second
+	TRANS_RAW_CHUNK      = 64 * 1024 // read size of stdout in raw framing
+)
+
+// ---------------------------------------------------------------------------------
+// framed message of stdin/stdout, [4CC][length(4) BE][data] as the TCP framing
+//   - RBIN: binary data of slot, RTXT: text data (mime) of slot
+//
+// ---------------------------------------------------------------------------------
+func WriteFramedMessage(w io.Writer, prefix string, data []byte) (err error) {
+	head := make([]byte, 8)
+	copy(head[0:4], prefix)
+	binary.BigEndian.PutUint32(head[4:8], uint32(len(data)))
+	if _, err = w.Write(head); err != nil {
+		return
+	}
+	_, err = w.Write(data)
+	return
+}
+
+func ReadFramedMessage(r io.Reader) (prefix string, data []byte, err error) {
+	head := make([]byte, 8)
+	if _, err = io.ReadFull(r, head); err != nil {
+		return
+	}
+	prefix = string(head[0:4])
+	data = make([]byte, binary.BigEndian.Uint32(head[4:8]))
+	_, err = io.ReadFull(r, data)
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// TransPipe is a transcoding process of a track, supervised and restarted
+// ---------------------------------------------------------------------------------
+type TransPipe struct {
+	Source   string    `json:"source"`
+	Track    string    `json:"track"`
+	Derived  string    `json:"derived"` // label of the output track
+	Command  []string  `json:"command"`
+	Framing  string    `json:"framing"` // frame (4CC+length), raw
+	Mime     string    `json:"mime,omitempty"`
+	Restarts int       `json:"restarts"`
+	AtStart  time.Time `json:"at_start"`
+	// --- internal variables
+	chn  *Channel
+	ring *Buffer  // forward ring of the input track
+	pub  *Session // publisher of the derived track
+	cmd  *exec.Cmd
+	done chan struct{}
+	exit chan struct{} // closed at the end of run
+	sync.Mutex
+}
+
+// check the value substituted into the argument of command, given by clients
+//   - only [A-Za-z0-9_.@/+-], not an option by the leading '-' nor a parent path
+func isSafeTransValue(v string) bool {
+	if v == "" || v[0] == '-' || strings.Contains(v, "..") {
+		return false
+	}
+	for _, r := range v {
+		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || strings.ContainsRune("_.@/+-", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// expand the command template with {channel}, {source}, {track}, {mime}, {codec}
+//   - the values used are checked not to inject the options or paths into the arguments
+func ExpandTransCommand(template string, chid, source, track, mime string) (args []string, err error) {
+	for key, v := range map[string]string{"{channel}": chid, "{source}": source, "{track}": track,
+		"{mime}": mime, "{codec}": mime} {
+		if strings.Contains(template, key) && !isSafeTransValue(v) {
+			err = fmt.Errorf("invalid value of %s for trans command: %q", key, v)
+			return
+		}
+	}
+	r := strings.NewReplacer("{channel}", chid, "{source}", source, "{track}", track,
+		"{mime}", mime, "{codec}", GetCodecFromMime(mime))
+	for _, f := range strings.Fields(template) {
+		args = append(args, r.Replace(f))
+	}
+	return
+}
+
+// setTransAuto turns on/off the transcoding, starting it at once if published
+//   - cmd is the command template, empty keeps the current one or the config
+func (d *Channel) setTransAuto(on bool, cmd string) {
+	d.Lock()
+	d.TransAuto = on
+	if cmd != "" {
+		d.TransCmd = cmd
+	}
+	npub := d.countSourcePublishers()
+	d.Unlock()
+
+	if on && npub > 0 {
+		d.startTranscoder()
+	}
+}
+
+// startTranscoder starts the transcoder worker of channel if TransAuto is set, at pub-in
+func (d *Channel) startTranscoder() {
+	d.Lock()
+	if !d.TransAuto || d.TransState == Using {
+		d.Unlock()
+		return
+	}
+	d.TransState = Using
+	d.Unlock()
+
+	go d.runTranscoder()
+}
+
+// transcoding while there are publishers other than the derived ones
+func (d *Channel) isTranscoding() bool {
+	d.Lock()
+	defer d.Unlock()
+	return d.TransAuto && d.TransState == Using && d.countSourcePublishers() > 0
+}
+
+func (d *Channel) getTransState() State {
+	d.Lock()
+	defer d.Unlock()
+	return d.TransState
+}
+
+func (d *Channel) getTransOptions() (template, suffix, framing, mime string) {
+	d.Lock()
+	defer d.Unlock()
+	template, suffix, framing, mime = d.TransCmd, d.TransSuffix, d.TransFraming, d.TransMime
+	if template == "" {
+		template = mConfig.TransCmd
+	}
+	if suffix == "" {
+		suffix = TRANS_DEFAULT_SUFFIX
+	}
+	if framing == "" {
+		framing = "frame"
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+// runTranscoder starts a pipe per track until the last pub-out
+func (d *Channel) runTranscoder() {
+	log.Println("i.runTranscoder:", d.ID)
+	defer log.Println("o.runTranscoder:", d.ID)
+
+	w := pStudio.addNewWorkerWithParams("/worker/moth/trans", d.ID, "trans")
+	defer pStudio.deleteWorker(w)
+
+	pipes := make(map[*Buffer]*TransPipe)
+	var wg sync.WaitGroup
+	defer func() {
+		for _, p := range pipes {
+			p.stop()
+		}
+		wg.Wait()
+		d.Lock()
+		d.TransState = Idle
+		d.transCmd = nil
+		d.Unlock()
+	}()
+
+	for d.isTranscoding() {
+		template, suffix, framing, mime := d.getTransOptions()
+		if template == "" {
+			log.Println("no trans command:", d.ID)
+			return
+		}
+		for ring, p := range pipes { // the input track is unpublished
+			if !d.hasPublishedTrack(p.Source, p.Track) {
+				p.stop()
+				<-p.exit // the derived track is released for the next one
+				delete(pipes, ring)
+			}
+		}
+		for _, p := range d.scanTransTracks(pipes, template, suffix, framing, mime) {
+			if !d.hasPublishedTrack(p.Source, p.Track) { // left with its mime
+				continue
+			}
+			pipes[p.ring] = p
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer close(p.exit)
+				p.run()
+			}()
+		}
+		w.AtUsed = time.Now()
+		time.Sleep(TRANS_SCAN_PERIOD)
+	}
+}
+
+// get the new pipes of tracks having mime, not derived ones
+func (d *Channel) scanTransTracks(pipes map[*Buffer]*TransPipe, template, suffix, framing, mime string) (news []*TransPipe) {
+	d.Lock()
+	defer d.Unlock()
+	for _, src := range d.Sources {
+		src.RLock()
+		for _, t := range src.Tracks {
+			b := t.getRingByOrder(BUFFER_NUM_FORE)
+			if b == nil || pipes[b] != nil || strings.Contains(t.Label, TRANS_DERIVED_MARK) {
+				continue
+			}
+			tmime := t.getRingMime(b)
+			if tmime == "" {
+				continue
+			}
+			p := &TransPipe{Source: src.Label, Track: t.Label, Derived: t.Label + suffix,
+				Framing: framing, Mime: mime, chn: d, ring: b, done: make(chan struct{}), exit: make(chan struct{})}
+			var err error
+			p.Command, err = ExpandTransCommand(template, d.ID, src.Label, t.Label, tmime)
+			if err != nil {
+				log.Println("trans:", err)
+				continue
+			}
+			if p.Mime == "" {
+				p.Mime = tmime
+			}
+			news = append(news, p)
+		}
+		src.RUnlock()
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------
+func (d *TransPipe) stop() {
+	d.Lock()
+	defer d.Unlock()
+	select {
+	case <-d.done:
+	default:
+		close(d.done)
+	}
+	if d.cmd != nil && d.cmd.Process != nil {
+		d.cmd.Process.Kill()
+	}
+}
+
+func (d *TransPipe) isStopped() bool {
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// run supervises the process, restarting it with backoff until stopped
+func (d *TransPipe) run() {
+	log.Println("i.TransPipe:", d.Source, d.Track, d.Derived, d.Command)
+	defer log.Println("o.TransPipe:", d.Source, d.Track, d.Derived, d.Restarts)
+
+	var err error
+	d.pub, err = addDerivedPublisher(d.chn, d.Source, d.Derived, d.Mime)
+	if err != nil {
+		log.Println("trans:", err)
+		return
+	}
+	defer deleteDerivedPublisher(d.pub)
+
+	backoff := TRANS_MIN_BACKOFF
+	for !d.isStopped() {
+		tstart := time.Now()
+		err = d.runProcess()
+		if d.isStopped() {
+			return
+		}
+		log.Println("trans process ended:", d.Derived, err)
+		if time.Since(tstart) > TRANS_MAX_BACKOFF { // it worked for a while
+			backoff = TRANS_MIN_BACKOFF
+		}
+		select {
+		case <-d.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, TRANS_MAX_BACKOFF)
+		d.Restarts++
+	}
+}
+
+// run the process once, feeding the slots to stdin and reading stdout to the derived track
+func (d *TransPipe) runProcess() (err error) {
+	if len(d.Command) == 0 {
+		return fmt.Errorf("no trans command")
+	}
+	cmd := exec.Command(d.Command[0], d.Command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return
+	}
+	d.Lock()
+	if d.isStopped() {
+		d.Unlock()
+		return
+	}
+	err = cmd.Start()
+	if err != nil {
+		d.Unlock()
+		return
+	}
+	d.cmd = cmd
+	d.AtStart = time.Now()
+	d.chn.Lock()
+	d.chn.transCmd = cmd
+	d.chn.Unlock()
+	d.Unlock()
+
+	fin := make(chan struct{})
+	go func() {
+		defer stdin.Close()
+		d.feedSlots(stdin, fin)
+	}()
+
+	err = d.readSlots(stdout)
+	close(fin)
+	cmd.Process.Kill()
+	if werr := cmd.Wait(); err == nil || err == io.EOF {
+		err = werr
+	}
+	return
+}
+
+// feed the slots of the forward ring from the cached gop or the latest one
+func (d *TransPipe) feedSlots(w io.Writer, fin chan struct{}) {
+	bw := bufio.NewWriter(w)
+	lseq := d.ring.getWriteSeq()
+	if slots := d.ring.getGopSlots(); len(slots) > 0 {
+		lseq = slots[0].Seq - 1
+	}
+	for {
+		select {
+		case <-fin:
+			return
+		case <-d.done:
+			return
+		default:
+		}
+		bs, nseq, lost := d.ring.readSlotNext(lseq, 0)
+		if lost > 0 {
+			log.Println("trans lost:", d.Track, lost)
+		}
+		lseq = nseq
+		if bs == nil {
+			if bw.Flush() != nil {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+			continue
+		}
+		if bs.To != "" { // the directed slot is not transcoded
+			continue
+		}
+		var err error
+		if d.Framing == "raw" {
+			if bs.Mark != RSSP_MARK_RTXT {
+				_, err = bw.Write(bs.Data)
+			}
+		} else if bs.Mark == RSSP_MARK_RTXT {
+			err = WriteFramedMessage(bw, RSSP_MARK_RTXT, bs.Data)
+		} else {
+			err = WriteFramedMessage(bw, RSSP_MARK_RBIN, bs.Data)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// read the output of process into the derived track
+func (d *TransPipe) readSlots(r io.Reader) (err error) {
+	br := bufio.NewReader(r)
+	for {
+		bs := Slot{FrameType: websocket.BinaryMessage, Head: d.pub.ID}
+		if d.Framing == "raw" {
+			data := make([]byte, TRANS_RAW_CHUNK)
+			var n int
+			n, err = br.Read(data)
+			if err != nil {
+				return
+			}
+			bs.Data = data[:n]
+		} else {
+			var prefix string
+			prefix, bs.Data, err = ReadFramedMessage(br)
+			if err != nil {
+				return
+			}
+			if prefix == RSSP_MARK_RTXT {
+				bs.FrameType, bs.Mark = websocket.TextMessage, RSSP_MARK_RTXT
+			}
+		}
+		writeDerivedSlot(d.pub, &bs)
+	}
+}
+
+// ---------------------------------------------------------------------------------
+// derived track published by the internal session
+// ---------------------------------------------------------------------------------
+func addDerivedPublisher(chn *Channel, source, track, mime string) (s *Session, err error) {
+	if pStudio.findPublisherByResource(chn.ID, source, track) != nil {
+		err = fmt.Errorf("resource [%s/%s/%s] already used", chn.ID, source, track)
+		return
+	}
+	s = pStudio.addNewSessionWithName("/pang/int/pub")
+	s.chn = chn
+	s.ChannelID = chn.ID
+	s.src, s.trk, err = chn.addSourceTrackBySize(source, track, BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+	if err != nil {
+		pStudio.deleteSessionWithClose(s)
+		return
+	}
+	s.SourceID = source
+	s.TrackID = track
+	if mime != "" {
+		s.trk.setRingMime(s.trk.getRingByOrder(BUFFER_NUM_FORE), mime)
+	}
+	chn.addPublisher(s)
+	chn.pushEvent("pub-in", s.ID, s.Name, s.RequestID)
+	return
+}
+
+func deleteDerivedPublisher(s *Session) {
+	s.chn.pushEvent("pub-out", s.ID, s.Name, s.RequestID)
+	s.resetTrackInfo()
+	s.chn.deletePublisher(s)
+	pStudio.deleteSessionWithClose(s)
+}
+
+// write the slot into the forward ring of the derived track, text of mime sets it
+func writeDerivedSlot(s *Session, bs *Slot) {
+	b := s.trk.getRingByOrder(BUFFER_NUM_FORE)
+	if bs.Mark == RSSP_MARK_RTXT {
+		s.trk.setRingMime(b, string(bs.Data))
+	}
+	bs.getLengthTime()
+	bs.Key = s.trk.inspectCodec(bs, b)
+	bs.Seq = b.writeSlot(*bs, false)
+	b.cacheGopSlot(bs.Seq, bs.Key)
+	s.recordDVRSlot(b, bs)
+
+	s.countInSlot(bs.Length)
+}
+
+//=================================================================================
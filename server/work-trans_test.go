@@ -0,0 +1,146 @@
+// =================================================================================
+// Filename: work-trans_test.go
+// Function: Test functions for work-trans.go
+// Author: Stoney Kang, sikang@teamgrit.kr
+// Copyright: TeamGRIT, 2026
+// =================================================================================
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------------------------------------
+// wait for the slot of data in the ring
+func waitSlotData(b *Buffer, data []byte, tout time.Duration) bool {
+	for t := time.Now(); time.Since(t) < tout; time.Sleep(10 * time.Millisecond) {
+		if bs := b.readSlotBySeq(b.getWriteSeq()); bs != nil && bytes.Equal(bs.Data, data) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTranscoderByCat(t *testing.T) {
+	chn := NewChannelPointer()
+	_, trk, _ := chn.addSourceTrackBySize("base", "video", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+	b := trk.getRingByOrder(BUFFER_NUM_FORE)
+	trk.setRingMime(b, "text/plain")
+
+	s := NewSessionPointerWithName("/pang/ws/pub")
+	s.SourceID, s.TrackID = "base", "video"
+	chn.addPublisher(s)
+	chn.setTransAuto(true, "cat")
+
+	var out *Track
+	for i := 0; i < 100 && out == nil; i++ {
+		time.Sleep(20 * time.Millisecond)
+		chn.Lock()
+		out = chn.Sources["base"].Tracks["video"+TRANS_DEFAULT_SUFFIX]
+		chn.Unlock()
+	}
+	if out == nil {
+		t.Fatal("derived track is not added")
+	}
+	ob := out.getRingByOrder(BUFFER_NUM_FORE)
+	if ob.Mime != "text/plain" {
+		t.Fatal("invalid derived mime:", ob.Mime)
+	}
+
+	// the same data through cat, also after the restart of process
+	for k := 0; k < 2; k++ {
+		data := []byte(fmt.Sprintf("frame-%d", k))
+		found := false
+		for i := 0; i < 20 && !found; i++ {
+			b.writeSlot(Slot{Data: data, Length: len(data)}, false)
+			found = waitSlotData(ob, data, 100*time.Millisecond)
+		}
+		if !found {
+			t.Fatal("no data in the derived track:", k)
+		}
+		if k == 0 {
+			chn.Lock()
+			cmd := chn.transCmd
+			chn.Unlock()
+			cmd.Process.Kill()
+		}
+	}
+
+	chn.deletePublisher(s)
+	for i := 0; i < 100 && chn.getTransState() == Using; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if chn.getTransState() != Idle || pStudio.findPublisherByResource(chn.ID, "base", "video"+TRANS_DEFAULT_SUFFIX) != nil {
+		t.Fatal("transcoder is not stopped")
+	}
+}
+
+func TestTranscoderUnpublished(t *testing.T) {
+	chn := NewChannelPointer()
+	_, trk, _ := chn.addSourceTrackBySize("base", "video", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+	trk.setRingMime(trk.getRingByOrder(BUFFER_NUM_FORE), "text/plain")
+	_, atrk, _ := chn.addSourceTrackBySize("base", "audio", BUFFER_CAP_SLOTS, BUFFER_LEN_SLOTS, 0)
+
+	// the audio publisher keeps the transcoder while the video one is gone
+	video := NewSessionPointerWithName("/pang/ws/pub")
+	video.SourceID, video.TrackID = "base", "video"
+	audio := NewSessionPointerWithName("/pang/ws/pub")
+	audio.SourceID, audio.TrackID = "base", "audio"
+	chn.addPublisher(video)
+	chn.addPublisher(audio)
+	chn.setTransAuto(true, "cat")
+	defer chn.setTransAuto(false, "")
+
+	waitDerived := func(on bool) bool {
+		for i := 0; i < 150; i++ {
+			if chn.hasPublishedTrack("base", "video"+TRANS_DEFAULT_SUFFIX) == on {
+				return true
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	}
+	if !waitDerived(true) {
+		t.Fatal("derived track is not published")
+	}
+	done := make(chan struct{})
+	go func() { // the mime set while scanning, checked by -race
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			atrk.setRingMime(atrk.getRingByOrder(BUFFER_NUM_FORE), "text/plain")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		chn.scanTransTracks(map[*Buffer]*TransPipe{}, "cat", TRANS_DEFAULT_SUFFIX, "", "")
+	}
+	<-done
+	chn.deletePublisher(video)
+	if !waitDerived(false) {
+		t.Fatal("pipe of the unpublished track is not removed")
+	}
+	chn.addPublisher(video) // reconnected
+	if !waitDerived(true) {
+		t.Fatal("derived track is not published again")
+	}
+}
+
+func TestExpandTransCommand(t *testing.T) {
+	args, err := ExpandTransCommand("ffmpeg -f {codec} -i pipe:0 -metadata title={channel}/{source}/{track}",
+		"ch1", "base", "video", "video/h264")
+	if err != nil || len(args) != 7 || args[2] != "h264" || args[6] != "title=ch1/base/video" {
+		t.Fatal("invalid command:", args, err)
+	}
+	for _, track := range []string{"-y", "../../etc/x", "a b", "a;b", ""} {
+		if _, err = ExpandTransCommand("ffmpeg -i pipe:0 {track}.mp4", "ch1", "base", track, "video/h264"); err == nil {
+			t.Fatal("unsafe value is substituted:", track)
+		}
+	}
+	if _, err = ExpandTransCommand("cat", "ch1", "base", "a b", "text/plain; charset=utf-8"); err != nil {
+		t.Fatal("unused value is checked:", err)
+	}
+}
+
+//=================================================================================